@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiTokenPrefix - start of every personal access token, telling them apart from jwt in the Authorization header
+const apiTokenPrefix = "bkm_"
+
+// bearerToken - credential of the Authorization header, "" when there is no bearer one
+func bearerToken(r *http.Request) string {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+	if len(parts) == 2 && parts[0] == "Bearer" {
+		return parts[1]
+	}
+	return ""
+}
+
+// bearerAPIToken - personal access token of the Authorization header, "" when it carries something else (a jwt)
+func bearerAPIToken(r *http.Request) string {
+	if raw := bearerToken(r); strings.HasPrefix(raw, apiTokenPrefix) {
+		return raw
+	}
+	return ""
+}
+
+// requestScope - scope a personal access token needs for the request: reading or writing bookmarks
+func requestScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return models.ScopeBookmarksRead
+	}
+	return models.ScopeBookmarksWrite
+}
+
+// authenticateAPIToken - user of a personal access token, provided the token holds scope
+// The status tells an unknown or expired token (401) from a token lacking the scope (403)
+func (app *application) authenticateAPIToken(raw, scope string) (*models.User, *models.APIToken, int, error) {
+	token, err := app.DB.GetAPITokenByHash(hashToken(raw))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("api token:", err)
+		}
+		return nil, nil, http.StatusUnauthorized, errors.New("invalid api token")
+	}
+	if !token.HasScope(scope) {
+		return nil, nil, http.StatusForbidden, fmt.Errorf("api token lacks the %s scope", scope)
+	}
+
+	user, err := app.DB.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, http.StatusUnauthorized, errors.New("invalid api token")
+	}
+	err = app.DB.TouchAPIToken(token.ID)
+	if err != nil {
+		log.Println("api token:", err)
+	}
+	return user, token, http.StatusOK, nil
+}
+
+// sessionOnly - middleware refusing personal access tokens, for the account management routes
+// A leaked token must not be able to mint other tokens or to end the user's sessions
+func (app *application) sessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authAPIToken(r); ok {
+			app.errorJSON(w, errors.New("not available with an api token"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListAPITokens - Handler listing the personal access tokens of the current user
+func (app *application) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := app.DB.GetAPITokensByUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []*models.APIToken{}
+	}
+	_ = app.writeJSON(w, http.StatusOK, tokens)
+}
+
+// CreateAPIToken - Handler creating a personal access token for the current user
+// The token is only ever shown in this response
+func (app *application) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0: never expires
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" || len(payload.Name) > 100 {
+		app.errorJSON(w, errors.New("name is required, 100 characters at most"))
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		app.errorJSON(w, fmt.Errorf("at least one scope is required among %s", strings.Join(models.Scopes, ", ")))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !validScope(scope) {
+			app.errorJSON(w, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+		if scope == models.ScopeAdmin && !user.IsAdmin && len(user.Roles) == 0 {
+			app.errorJSON(w, errors.New("only users holding a role can create admin tokens"), http.StatusForbidden)
+			return
+		}
+	}
+	if payload.ExpiresInDays < 0 {
+		app.errorJSON(w, errors.New("expires_in_days must be positive"))
+		return
+	}
+
+	raw := apiTokenPrefix + generateRandomString(40)
+	token := &models.APIToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Prefix: raw[:len(apiTokenPrefix)+6],
+		Scopes: payload.Scopes,
+	}
+	if payload.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, payload.ExpiresInDays)
+		token.ExpiresAt = &expires
+	}
+
+	err = app.DB.CreateAPIToken(token, hashToken(raw))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, JSONResponse{
+		Error:   false,
+		Message: "copy this token now, it will not be shown again",
+		Data: struct {
+			*models.APIToken
+			Token string `json:"token"`
+		}{token, raw},
+	})
+}
+
+// RevokeAPIToken - Handler revoking a personal access token of the current user
+func (app *application) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid token id"))
+		return
+	}
+
+	err = app.DB.DeleteAPIToken(user.ID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such token"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apiTokenRepo - personal access tokens by hash, and their users
+type apiTokenRepo struct {
+	repository.DatabaseRepo
+	tokens  map[string]*models.APIToken
+	users   map[int]*models.User
+	touched []int
+}
+
+func (m *apiTokenRepo) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	t, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (m *apiTokenRepo) GetUserByID(userID int) (*models.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (m *apiTokenRepo) TouchAPIToken(id int) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+// TestAPITokenMiddlewares - personal access tokens are accepted by the middlewares within their scopes
+func TestAPITokenMiddlewares(t *testing.T) {
+	repo := &apiTokenRepo{
+		tokens: map[string]*models.APIToken{
+			hashToken("bkm_reader"): {ID: 1, UserID: 1, Scopes: []string{models.ScopeBookmarksRead}},
+			hashToken("bkm_writer"): {ID: 2, UserID: 1, Scopes: []string{models.ScopeBookmarksWrite}},
+			hashToken("bkm_admin"):  {ID: 3, UserID: 1, Scopes: []string{models.ScopeAdmin}},
+		},
+		users: map[int]*models.User{1: {ID: 1, UserName: "ned"}},
+	}
+	app := &application{DB: repo}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := authUser(r)
+		assert.Equal(t, 1, user.ID)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		method     string
+		token      string
+		status     int
+	}{
+		{"read", app.requireAuth, http.MethodGet, "bkm_reader", http.StatusOK},
+		{"write without scope", app.requireAuth, http.MethodPut, "bkm_reader", http.StatusForbidden},
+		{"write", app.requireAuth, http.MethodPut, "bkm_writer", http.StatusOK},
+		{"write implies read", app.requireAuth, http.MethodGet, "bkm_writer", http.StatusOK},
+		{"unknown token", app.requireAuth, http.MethodGet, "bkm_unknown", http.StatusUnauthorized},
+		{"admin scope without admin user", app.adminRequired, http.MethodGet, "bkm_admin", http.StatusForbidden},
+		{"admin route without admin scope", app.adminRequired, http.MethodGet, "bkm_writer", http.StatusForbidden},
+		{"account routes", func(next http.Handler) http.Handler { return app.requireAuth(app.sessionOnly(next)) },
+			http.MethodGet, "bkm_reader", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			tt.middleware(ok).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+
+	repo.users[1].IsAdmin = true
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer bkm_admin")
+	rec := httptest.NewRecorder()
+	app.adminRequired(ok).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Contains(t, repo.touched, 2, "uses are tracked")
+}
@@ -2,28 +2,32 @@ package main
 
 import (
 	"bookmarks/internal/models"
-	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Auth struct - structure to pack the authentication-token parameter
 type Auth struct {
-	Issuer        string
-	Audience      string
+	Issuer   string
+	Audience string
+	// Keys - sign and verify the access and refresh tokens
+	Keys *KeySet
+	// Secret - only derives the keys of the purpose tokens, which never leave this service
 	Secret        string
 	TokenExpiry   time.Duration
 	RefreshExpiry time.Duration
 	CookieDomain  string
 	CookiePath    string
 	CookieName    string
+	// Revocations - when set, tokens whose jwt id has been revoked are refused
+	Revocations RevocationChecker
 }
 
 // Data about an user to issue a token
@@ -36,14 +40,27 @@ type jwtUser struct {
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	AccessJTI    string `json:"-"`
+	RefreshJTI   string `json:"-"`
 }
 
 // Claims - wrapper type around the jwt registered claims
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID int `json:"user_id"`
+	// Type - tells the two tokens of a pair apart, so that a refresh token never passes for an access token
+	Type string `json:"typ,omitempty"`
 }
 
+// Types of the tokens of a pair
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+)
+
+// errWrongTokenType - a token of the pair used in place of the other
+var errWrongTokenType = errors.New("wrong token type")
+
 // GenerateTokenPair - generate the token pair
 func (j *Auth) GenerateTokenPair(userID int) (TokenPairs, error) {
 	claims := &Claims{
@@ -52,14 +69,14 @@ func (j *Auth) GenerateTokenPair(userID int) (TokenPairs, error) {
 			Audience:  jwt.ClaimStrings{j.Audience},
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.TokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
 		},
 		UserID: userID,
+		Type:   tokenTypeAccess,
 	}
 
-	// Create a token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	// create a signed token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	signedAccessToken, err := j.Keys.sign(claims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -70,14 +87,14 @@ func (j *Auth) GenerateTokenPair(userID int) (TokenPairs, error) {
 			Audience:  jwt.ClaimStrings{j.Audience},
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.RefreshExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(), // unique per token, so that two rotations never yield the same refresh token
 		},
 		UserID: userID,
+		Type:   tokenTypeRefresh,
 	}
 
-	// Create a refresh token - set claims - kinda parallel/similar methods here
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
 	// Create signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := j.Keys.sign(refreshClaims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -88,6 +105,8 @@ func (j *Auth) GenerateTokenPair(userID int) (TokenPairs, error) {
 	return TokenPairs{
 		Token:        signedAccessToken,
 		RefreshToken: signedRefreshToken,
+		AccessJTI:    claims.ID,
+		RefreshJTI:   refreshClaims.ID,
 	}, nil
 }
 
@@ -126,92 +145,116 @@ func (j *Auth) GetExpiredRefreshCookie() *http.Cookie {
 
 // GetTokenFromCookieAndVerify - scan the token stored in Cookie to check its validity
 func (j *Auth) GetTokenFromCookieAndVerify(tokenString string) (string, *Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.Secret), nil
-	})
-	if err != nil || !token.Valid {
+	claims, err := j.VerifyToken(tokenString)
+	if err != nil {
 		return "", nil, err
 	}
 	return tokenString, claims, nil
 }
 
-// GetTokenFromHeaderAndVerify - scan the token stored in header to check its validity
-func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
-	// Good practice to add header
-	w.Header().Add("Vary", "Authorization")
-
-	// get auth header
-	authHeader := r.Header.Get("Authorization")
-	log.Println("Authorization Header:", authHeader) // Ajoutez cette ligne pour loguer l'en-tête
-
-	// Sanity checks
-	if authHeader == "" {
-		return "", nil, errors.New("no auth header in header")
+// VerifyToken - claims of a token we issued, provided it is neither expired nor revoked
+func (j *Auth) VerifyToken(tokenString string) (*Claims, error) {
+	claims, err := j.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
 	}
+	err = j.checkRevocation(claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	// Split the header, to check for 'bearer'
-	headerParts := strings.Split(authHeader, " ")
-	if len(headerParts) != 2 {
-		return "", nil, errors.New("invalid auth header format")
+// VerifyTokenType - claims of a valid token of the given type (tokenTypeAccess or tokenTypeRefresh)
+func (j *Auth) VerifyTokenType(tokenString, typ string) (*Claims, error) {
+	claims, err := j.VerifyToken(tokenString)
+	if err != nil {
+		return nil, err
 	}
-	if headerParts[0] != "Bearer" {
-		return "", nil, errors.New("incorrect authorization format")
+	if claims.Type != typ {
+		return nil, errWrongTokenType
 	}
+	return claims, nil
+}
 
-	token := headerParts[1]
-	// Declare an empty claims
+// ParseToken - check the signature, expiry, issuer and audience of a token, without consulting the revocations
+// Only meant for the refresh endpoint, which checks the refresh token against the tokens table itself
+func (j *Auth) ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.Keys.keyFunc,
+		jwt.WithValidMethods(j.Keys.methods()),
+		jwt.WithIssuer(j.Issuer),
+		jwt.WithAudience(j.Audience),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// Purposes of the short-lived tokens handed out between two steps of a flow
+const (
+	purposeOauthLink      = "oauth-link"
+	purposeLoginChallenge = "login-challenge"
+	purposeUnsubscribe    = "unsubscribe"
+)
+
+// GeneratePurposeToken - short-lived token naming a user, only usable for purpose (the step of a flow it stands for)
+// It is signed with a key of its own per purpose, so that it can never pass for an access or refresh token
+func (j *Auth) GeneratePurposeToken(purpose string, userID int, expiry time.Duration) (string, error) {
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    j.Issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		UserID: userID,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(j.purposeKey(purpose))
+}
 
-	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+// ParsePurposeToken - user id carried by a valid token for purpose
+func (j *Auth) ParsePurposeToken(purpose, tokenString string) (int, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(j.Secret), nil
+		return j.purposeKey(purpose), nil
 	})
-
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
-		}
-		return "", nil, err
+		return 0, err
 	}
-	// Do we actually issue that token ?
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid user")
+	if !token.Valid || claims.UserID == 0 {
+		return 0, errors.New("invalid token")
 	}
-
-	return token, claims, nil
+	return claims.UserID, nil
 }
 
-// Middleware to add userID to context
-func (app *application) Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "No Authorization header", http.StatusUnauthorized)
-			return
-		}
-
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			http.Error(w, "Invalid Authorization header", http.StatusUnauthorized)
-			return
-		}
-		// token := headerParts[1]
-		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+func (j *Auth) purposeKey(purpose string) []byte {
+	key := sha256.Sum256([]byte(purpose + ":" + j.Secret))
+	return key[:]
+}
 
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// checkRevocation - refuse tokens without jwt id (issued before revocation existed) and revoked ones
+func (j *Auth) checkRevocation(claims *Claims) error {
+	if claims.ID == "" {
+		return errors.New("token has no id, please log in again")
+	}
+	if j.Revocations == nil {
+		return nil
+	}
+	revoked, err := j.Revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errors.New("token has been revoked")
+	}
+	return nil
 }
 
 // Credentials - structure to pack the credentials informations entered
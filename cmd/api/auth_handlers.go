@@ -5,11 +5,11 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -18,11 +18,22 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// emailTokenValidity - lifetime of an email confirmation link
+const emailTokenValidity = 24 * time.Hour
+
+// emailResendInterval - minimum delay between two confirmation emails sent to the same account
+const emailResendInterval = time.Minute
+
+// authCodeValidity - time given to the frontend to exchange the code of an Oauth login
+const authCodeValidity = time.Minute
+
 // RegisterRequest - structure to pack the request data when creating an account
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Language - of the emails, the one of the browser when empty
+	Language string `json:"language"`
 }
 
 // ClassicLogin - Handler responsible of classic login - email && password
@@ -41,63 +52,267 @@ func (app *application) ClassicLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Email and password are required", http.StatusBadRequest)
 		return
 	}
+
+	if wait := app.loginBlocked(r, loginReq.Email); wait > 0 {
+		app.tooManyLogins(w, wait)
+		return
+	}
+
 	// Query database - does this user exists ?
 	user, err := app.DB.GetUserByEmail(loginReq.Email)
 	if err != nil {
-		http.Error(w, "no such user in our dataabse", http.StatusNotFound)
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		// as slow as a wrong password, and counted the same
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(loginReq.Password))
+		app.loginFailed(r, loginReq.Email, nil)
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	// locked by an earlier process, or by another instance
+	if wait := time.Until(user.LockedUntil); wait > 0 {
+		app.tooManyLogins(w, wait)
 		return
 	}
 
-	log.Println("user id of riri => ", user.ID)
 	// Compare its hashed password with hashed value in database
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password))
 	if err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || user.Password == "" {
+			app.loginFailed(r, loginReq.Email, &user)
+			app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
 		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	app.loginAccountLimiter.Reset(loginAccountKey(loginReq.Email))
+
+	if !user.Verified {
+		app.errorCodeJSON(w, errCodeEmailNotVerified, errors.New("please confirm your email address before logging in"), http.StatusForbidden)
+		return
+	}
+
+	app.completeLogin(w, r, &user)
+}
+
+// completeLogin - last step of a login once the user is authenticated (password, magic link)
+// Answers with a two-factor challenge when it is enabled, otherwise starts the session: the access token
+// along with the user, the refresh token as a cookie
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// second step needed: the session is only started by LoginTwoFactor
+	if user.TwoFactorEnabled {
+		challenge, err := app.auth.GeneratePurposeToken(purposeLoginChallenge, user.ID, loginChallengeValidity)
+		if err != nil {
+			http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+			return
 		}
+		_ = app.writeJSON(w, http.StatusOK, struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Challenge         string `json:"challenge"`
+		}{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+		})
 		return
 	}
 
-	tokens, err := app.auth.GenerateTokenPair(user.ID)
+	app.loginResponse(w, r, user)
+}
+
+// loginResponse - start the session of user, answering with the access token along with the user
+func (app *application) loginResponse(w http.ResponseWriter, r *http.Request, user *models.User) {
+	tokens, err := app.startSession(w, r, user.ID)
 	if err != nil {
 		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
 		return
 	}
-	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
-	http.SetCookie(w, refreshCookie)
 
-	// Optionally, store the refresh token in the database
-	err = app.DB.StoreTokenPairs(user.ID, tokens.Token, tokens.RefreshToken, time.Now().Add(app.auth.TokenExpiry))
+	// never send the secrets of the user back
+	u := *user
+	u.Password, u.EmailToken, u.TokenHash = "", "", ""
+
+	_ = app.writeJSON(w, http.StatusOK, struct {
+		User  *models.User `json:"user"`
+		Token string       `json:"token"`
+	}{
+		User:  &u,
+		Token: tokens.Token,
+	})
+}
+
+// tokenRow - the tokens table row recording a freshly generated token pair
+func (app *application) tokenRow(userID int, familyID string, tokens TokenPairs) *models.Token {
+	return &models.Token{
+		UserID:       userID,
+		FamilyID:     familyID,
+		AccessToken:  tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+		AccessJTI:    tokens.AccessJTI,
+		RefreshJTI:   tokens.RefreshJTI,
+		ExpiryDate:   time.Now().Add(app.auth.RefreshExpiry),
+	}
+}
+
+// startSession - issue a token pair for a new login (new session and token family), store it and set the refresh cookie
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, userID int) (TokenPairs, error) {
+	tokens, err := app.auth.GenerateTokenPair(userID)
 	if err != nil {
-		http.Error(w, "Failed to store refresh token", http.StatusInternalServerError)
+		return TokenPairs{}, err
+	}
+
+	row := app.tokenRow(userID, uuid.NewString(), tokens)
+	err = app.DB.CreateSession(&models.Session{
+		ID:        row.FamilyID,
+		UserID:    userID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		ExpiresAt: row.ExpiryDate,
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	err = app.DB.StoreTokenPairs(row)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+	return tokens, nil
+}
+
+// RefreshToken - handler exchanging the refresh cookie for a new token pair
+// The refresh token is rotated: presenting an already rotated one means it leaked, the whole login is then revoked
+func (app *application) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		app.errorJSON(w, errors.New("no refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	// Not checked against the revocations: a rotated token is denylisted, but must reach the reuse detection below
+	claims, err := app.auth.ParseToken(cookie.Value)
+	if err == nil && claims.Type != tokenTypeRefresh {
+		err = errWrongTokenType
+	}
+	if err != nil {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	stored, err := app.DB.GetTokenByRefreshToken(cookie.Value)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		// Unknown token: logged out, or its family has been revoked
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
 		return
 	}
 
-	log.Printf("User info before encoding response: %+v\n\t", user)
+	if stored.RotatedAt != nil {
+		app.revokeReusedFamily(w, stored)
+		return
+	}
+	if stored.UserID != claims.UserID || time.Now().After(stored.ExpiryDate) {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
 
-	response := struct {
-		User  models.User `json:"user"`
-		Token string      `json:"token"`
+	tokens, err := app.auth.GenerateTokenPair(stored.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to generate tokens"), http.StatusInternalServerError)
+		return
+	}
+
+	next := app.tokenRow(stored.UserID, stored.FamilyID, tokens)
+	err = app.DB.RotateTokenPair(stored, next)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			app.revokeReusedFamily(w, stored)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.markRevoked(stored.RefreshJTI)
+
+	err = app.DB.TouchSession(stored.FamilyID, r.UserAgent(), clientIP(r), next.ExpiryDate)
+	if err != nil {
+		log.Println("failed to update session on refresh:", err)
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+	_ = app.writeJSON(w, http.StatusOK, struct {
+		Token string `json:"token"`
 	}{
-		User:  user,
 		Token: tokens.Token,
+	})
+}
+
+// revokeReusedFamily - a rotated refresh token came back: revoke every token of its login
+func (app *application) revokeReusedFamily(w http.ResponseWriter, stored *models.Token) {
+	log.Printf("refresh token reuse detected for user %d, revoking token family %s\n", stored.UserID, stored.FamilyID)
+
+	jtis, err := app.DB.RevokeTokenFamily(stored.FamilyID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.markRevoked(jtis...)
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	app.errorJSON(w, errors.New("refresh token reuse detected, please log in again"), http.StatusUnauthorized)
+}
+
+// markRevoked - make revocations done by this instance effective immediately in the cache
+func (app *application) markRevoked(jtis ...string) {
+	if app.revocations != nil {
+		app.revocations.MarkRevoked(jtis...)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
 
-// Logout - obviously handles the Logout button
+// revokeSession - revoke the login the token described by claims belongs to
+func (app *application) revokeSession(claims *Claims) error {
+	row, err := app.DB.GetTokenByJTI(claims.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// token not tracked in the tokens table, denylist it alone
+		err = app.DB.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time)
+		if err != nil {
+			return err
+		}
+		app.markRevoked(claims.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	jtis, err := app.DB.RevokeTokenFamily(row.FamilyID)
+	if err != nil {
+		return err
+	}
+	app.markRevoked(append(jtis, claims.ID)...)
+	return nil
+}
+
+// Logout - obviously handles the Logout button - revokes the current session only
 func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
 	refreshCookie := app.auth.GetExpiredRefreshCookie()
 	http.SetCookie(w, refreshCookie)
 
-	// get current user from the context
-	user, ok := r.Context().Value("user").(*models.User)
-	log.Println("do we have an user => \t", user)
-	if ok && user != nil {
-		err := app.DB.DeleteTokensPairOnLogOut(user.ID)
+	// get the token used for this request from the context
+	claims, ok := authClaims(r)
+	if ok && claims != nil {
+		err := app.revokeSession(claims)
 		if err != nil {
 			app.errorJSON(w, err)
 			return
@@ -107,25 +322,58 @@ func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// LogoutEverywhere - handler revoking every session of the current user, on every device
+func (app *application) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	jtis, err := app.DB.DeleteTokensPairOnLogOut(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.markRevoked(jtis...)
+
+	// the token of this very request may not be tracked in the tokens table
+	if claims, ok := authClaims(r); ok && claims != nil {
+		if err := app.DB.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		app.markRevoked(claims.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ConfirmEmail - handler to confirm the link + token sent via email
 func (app *application) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 
 	if token == "" {
-		http.Error(w, "token must be expired - please start over to register", http.StatusBadRequest)
+		app.errorCodeJSON(w, errCodeTokenInvalid, errors.New("missing confirmation token"), http.StatusBadRequest)
 		return
 	}
 
 	// Using confirmation token - we retrieve corresponding user (pre-registered)
-	user, err := app.DB.GetUserByConfirmationToken(token)
+	user, err := app.DB.GetUserByConfirmationToken(hashToken(token))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "Invalid or expired token", http.StatusNotFound)
+			app.errorCodeJSON(w, errCodeTokenInvalid, errors.New("invalid confirmation token"), http.StatusNotFound)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
+	if time.Now().After(user.EmailTokenExpiry) {
+		app.errorCodeJSON(w, errCodeTokenExpired, errors.New("confirmation link expired, please ask for a new one"), http.StatusGone)
+		return
+	}
 
 	// Evrything valid, we UPDATE the user as verified - Register is complete !
 	err = app.DB.VerifyUser(user.ID)
@@ -134,7 +382,52 @@ func (app *application) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "http://localhost:5173/email-confirmed", http.StatusAccepted)
+	http.Redirect(w, r, app.FrontendURL+"/email-confirmed", http.StatusAccepted)
+}
+
+// ResendConfirmationEmail - handler sending a fresh confirmation link to an unverified account
+// Same answer whatever the email, so that it does not tell which emails are registered; an account gets
+// at most one email per emailResendInterval, and a client a few requests per hour
+func (app *application) ResendConfirmationEmail(w http.ResponseWriter, r *http.Request) {
+	if !app.resendLimiter.Allow(clientIP(r)) {
+		app.errorCodeJSON(w, errCodeTooManyRequests, errors.New("too many requests, please try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil || payload.Email == "" {
+		app.errorJSON(w, errors.New("email is required"))
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "if this email belongs to an unconfirmed account, a new confirmation link has been sent to it",
+	}
+
+	user, err := app.DB.GetUserByEmail(payload.Email)
+	if err != nil || user.Verified || time.Since(user.EmailTokenSentAt) < emailResendInterval {
+		_ = app.writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	token := generateRandomString(32)
+	err = app.DB.SetEmailToken(user.ID, hashToken(token), time.Now().Add(emailTokenValidity))
+	if err != nil {
+		log.Println("resend confirmation:", err)
+		_ = app.writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	err = app.sendConfirmationEmail(&user, token)
+	if err != nil {
+		log.Printf("failed to queue confirmation email to user %d: %v\n", user.ID, err)
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, response)
 }
 
 // RegisterNewUser - handler for registering a new user with classic method (username + mail + password)
@@ -154,31 +447,49 @@ func (app *application) RegisterNewUser(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Request is properly formatted - pretending new user deserves an email confirmation
-	// generate a random token
+	// generate a random token - only its hash is stored
 	randomString := generateRandomString(32)
-	log.Println(randomString)
 
 	defaultAvatar := fmt.Sprintf("https://api.dicebear.com/8.x/pixel-art/svg?seed=%s", req.Username)
 
-	err = app.sendConfirmationEmail(req.Email, randomString)
+	language := requestLanguage(req.Language, r.Header.Get("Accept-Language"))
+	id, err := app.DB.InsertNewUser(req.Username, req.Email, req.Password, hashToken(randomString), defaultAvatar, language, time.Now().Add(emailTokenValidity))
 	if err != nil {
-		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		app.writeJSON(w, http.StatusBadRequest, err)
+		log.Println("Failed to register that new user")
 		return
 	}
 
-	id, err := app.DB.InsertNewUser(req.Username, req.Email, req.Password, randomString, defaultAvatar)
+	// queued, not sent: the smtp server never holds the registration up - the link can be resent if it gets lost
+	err = app.sendConfirmationEmail(&models.User{ID: id, Email: req.Email, Language: language}, randomString)
 	if err != nil {
-		app.writeJSON(w, http.StatusBadRequest, err)
-		log.Println("Failed to register that new user")
-		return
+		log.Printf("failed to queue confirmation email to user %d: %v\n", id, err)
 	}
 	// Optionally, you can redirect the user to a success page
-	http.Redirect(w, r, "http://localhost:5173/email-confirmation?redirect=login", http.StatusAccepted)
+	http.Redirect(w, r, app.FrontendURL+"/email-confirmation?redirect=login", http.StatusAccepted)
 	app.writeJSON(w, http.StatusAccepted, id)
 }
 
-// HandleAuth - handler for the authentication via Oauth (Github)
+// HandleAuth - handler for the authentication via one of the Oauth providers
+// With ?link=1 and the link cookie set by LinkIdentity, the provider account is linked to the logged in user instead
 func (app *application) HandleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("link") != "" {
+		// the link token was set by LinkIdentity, in the browser of the user who asked for it
+		cookie, err := r.Cookie(oauthLinkCookie)
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid or expired link request"), http.StatusUnauthorized)
+			return
+		}
+		if _, err := app.auth.ParsePurposeToken(purposeOauthLink, cookie.Value); err != nil {
+			setLinkCookie(w, "")
+			app.errorJSON(w, errors.New("invalid or expired link request"), http.StatusUnauthorized)
+			return
+		}
+	} else if _, err := r.Cookie(oauthLinkCookie); err == nil {
+		// a link given up halfway must not turn this login into a link
+		setLinkCookie(w, "")
+	}
+
 	q := r.URL.Query()
 	q.Add("provider", chi.URLParam(r, "provider"))
 	r.URL.RawQuery = q.Encode()
@@ -186,78 +497,89 @@ func (app *application) HandleAuth(w http.ResponseWriter, r *http.Request) {
 	gothic.BeginAuthHandler(w, r)
 }
 
-// HandleCallback - handler for the callback url via Github Oauth
+// HandleCallback - handler for the callback url of the Oauth providers
+// Logs in the user the provider account belongs to - registering them on first login - or links it
+// to the user who asked for it
 func (app *application) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	provider := chi.URLParam(r, "provider")
-	r = r.WithContext(context.WithValue(context.Background(), "provider", provider))
-	user, err := gothic.CompleteUserAuth(w, r)
+	r = r.WithContext(context.WithValue(r.Context(), "provider", provider))
+	gUser, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
 		log.Printf("Error completing user auth: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Check if this user has already logged in the past with Github
-	// existingUser, err := app.DB.GetUserByEmail(user.Email)
-	// if err != nil {
-	// 	log.Println("Error when checking user in database: %v", err)
-	// 	http.Error(w, "error when checking if user exists in database", http.StatusInternalServerError)
-	// 	return
-	// }
-
-	// var userID int
-	// if existingUser.UserName != "" {
-	// 	userID = existingUser.ID
-	// } else {
-	// generate a new ID for this new Github logger
-	userID, _ := strconv.Atoi(uuid.New().String())
-	// store that new user in DB
-	err = app.DB.StoreUserInDB(fmt.Sprint(userID), &user)
-	if err != nil {
-		log.Printf("Error storing user in database: %v", err)
-		http.Error(w, "Error storing user in database", http.StatusInternalServerError)
+	if linkUserID := app.pendingLink(w, r); linkUserID != 0 {
+		err = app.DB.LinkIdentity(identityFromGoth(linkUserID, gUser))
+		if err != nil {
+			if errors.Is(err, models.ErrIdentityTaken) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, "Error linking account", http.StatusInternalServerError)
+			}
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("%s/dashboard?linked=%s", app.FrontendURL, url.QueryEscape(provider)), http.StatusFound)
 		return
 	}
-	// }
 
-	// Generate token pair for user
-	tokenString, err := app.auth.GenerateTokenPair(userID)
+	user, err := app.identityUser(gUser)
+	if errors.Is(err, errEmailUnconfirmed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		log.Printf("Error storing user in database: %v", err)
+		http.Error(w, "Error storing user in database", http.StatusInternalServerError)
 		return
 	}
-	refreshCookie := app.auth.GetRefreshCookie(tokenString.RefreshToken)
-	http.SetCookie(w, refreshCookie)
 
-	// JSONify the user data fetched from oauth provider
-	userData, err := json.MarshalIndent(user, "", "\t")
+	// the tokens are not put in the url (browser history, referrers): the frontend exchanges this code for them
+	code := generateRandomString(32)
+	err = app.DB.CreateAuthCode(user.ID, hashToken(code), time.Now().Add(authCodeValidity))
 	if err != nil {
-		log.Println("error marshaling user data", err)
-		http.Error(w, "error encoding user data", http.StatusInternalServerError)
+		http.Error(w, "Failed to complete the login", http.StatusInternalServerError)
 		return
 	}
-	// log.Printf("user data from github %s", string(userData))
 
-	redirectURL := fmt.Sprintf("http://localhost:5173/dashboard?accessToken=%s&user=%s", tokenString.Token, url.QueryEscape(string(userData)))
-	http.Redirect(w, r, redirectURL, http.StatusFound)
-	app.writeJSON(w, http.StatusOK, user)
+	http.Redirect(w, r, fmt.Sprintf("%s/dashboard?code=%s", app.FrontendURL, url.QueryEscape(code)), http.StatusFound)
 }
 
-// AdminDashboard - Handler to serve the data to the Admin Dashboard
-func (app *application) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("userID").(int)
+// ExchangeAuthCode - handler trading the one-time code of an Oauth login for a session
+// Answers like ClassicLogin: a two-factor challenge, or the access token along with the user and the refresh cookie
+func (app *application) ExchangeAuthCode(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil || payload.Code == "" {
+		app.errorJSON(w, errors.New("code is required"))
+		return
+	}
 
-	user, err := app.DB.GetUserByID(userID)
+	userID, err := app.DB.ConsumeAuthCode(hashToken(payload.Code))
 	if err != nil {
-		http.Error(w, "user not found", http.StatusUnauthorized)
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("invalid or expired code"), http.StatusUnauthorized)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if !user.IsAdmin {
-		http.Error(w, "forbidden", http.StatusUnauthorized)
+	user, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	app.completeLogin(w, r, user)
+}
+
+// AdminDashboard - Handler to serve the data to the Admin Dashboard
+func (app *application) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	// adminRequired already checked the user may manage users
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(struct {
 		Message string `json:"message"`
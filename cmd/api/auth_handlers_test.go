@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refreshRepo - the token pairs of the logins by refresh token, as rotated and revoked by the refresh endpoint
+type refreshRepo struct {
+	repository.DatabaseRepo
+	rows    map[string]*models.Token
+	revoked []string // revoked families
+}
+
+func (m *refreshRepo) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
+	row, ok := m.rows[refreshToken]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *row
+	return &copied, nil
+}
+
+func (m *refreshRepo) RotateTokenPair(old, next *models.Token) error {
+	row, ok := m.rows[old.RefreshToken]
+	if !ok || row.RotatedAt != nil {
+		return models.ErrRefreshTokenReused
+	}
+	now := time.Now()
+	row.RotatedAt = &now
+	m.rows[next.RefreshToken] = next
+	return nil
+}
+
+func (m *refreshRepo) RevokeTokenFamily(familyID string) ([]string, error) {
+	var jtis []string
+	for refresh, row := range m.rows {
+		if row.FamilyID == familyID {
+			jtis = append(jtis, row.AccessJTI, row.RefreshJTI)
+			delete(m.rows, refresh)
+		}
+	}
+	m.revoked = append(m.revoked, familyID)
+	return jtis, nil
+}
+
+func (m *refreshRepo) TouchSession(id, userAgent, ip string, expiresAt time.Time) error { return nil }
+
+func newRefreshApp() (*application, *refreshRepo) {
+	repo := &refreshRepo{rows: map[string]*models.Token{}}
+	app := &application{
+		DB:   repo,
+		auth: Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, CookieName: "refresh_token"},
+	}
+	return app, repo
+}
+
+// testLogin - store the token pair of a new login of userID, as startSession does
+func testLogin(t *testing.T, app *application, repo *refreshRepo, userID int, familyID string) TokenPairs {
+	tokens, err := app.auth.GenerateTokenPair(userID)
+	require.NoError(t, err)
+	row := app.tokenRow(userID, familyID, tokens)
+	repo.rows[tokens.RefreshToken] = row
+	return tokens
+}
+
+// refresh - call the refresh endpoint with refreshToken as cookie, returning the answer and the cookie it set
+func refresh(app *application, refreshToken string) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodPost, "/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+	rec := httptest.NewRecorder()
+	app.RefreshToken(rec, req)
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "refresh_token" {
+			return rec, c
+		}
+	}
+	return rec, nil
+}
+
+// TestRefreshTokenRotation - a refresh token is exchanged once for a new pair, the login staying the same
+func TestRefreshTokenRotation(t *testing.T) {
+	app, repo := newRefreshApp()
+	login := testLogin(t, app, repo, 7, "family-1")
+
+	rec, cookie := refresh(app, login.RefreshToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NotNil(t, cookie)
+	assert.NotEqual(t, login.RefreshToken, cookie.Value, "the refresh token is rotated")
+
+	var res struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	claims, err := app.auth.VerifyTokenType(res.Token, tokenTypeAccess)
+	require.NoError(t, err)
+	assert.Equal(t, 7, claims.UserID)
+
+	assert.NotNil(t, repo.rows[login.RefreshToken].RotatedAt, "the previous pair is marked rotated")
+	next, ok := repo.rows[cookie.Value]
+	require.True(t, ok, "the new pair is stored")
+	assert.Equal(t, "family-1", next.FamilyID)
+
+	rec, _ = refresh(app, cookie.Value)
+	assert.Equal(t, http.StatusOK, rec.Code, "the new refresh token is usable in turn")
+}
+
+// TestRefreshTokenReuse - presenting a rotated refresh token revokes its whole login, the other logins are kept
+func TestRefreshTokenReuse(t *testing.T) {
+	app, repo := newRefreshApp()
+	app.revocations = newRevocationCache(func(jti string) (bool, error) { return false, nil }, time.Minute, time.Hour)
+	login := testLogin(t, app, repo, 7, "family-1")
+	other := testLogin(t, app, repo, 7, "family-2")
+
+	rec, rotated := refresh(app, login.RefreshToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec, cookie := refresh(app, login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.NotNil(t, cookie)
+	assert.Empty(t, cookie.Value, "the refresh cookie is cleared")
+	assert.Equal(t, []string{"family-1"}, repo.revoked)
+
+	revoked, err := app.revocations.IsRevoked(login.AccessJTI)
+	assert.NoError(t, err)
+	assert.True(t, revoked, "the access token of the login is revoked at once")
+
+	rec, _ = refresh(app, rotated.Value)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "the rotated pair went with its family")
+	rec, _ = refresh(app, other.RefreshToken)
+	assert.Equal(t, http.StatusOK, rec.Code, "other logins are left alone")
+}
+
+// TestRefreshTokenRefused - expired logins, access tokens and unknown tokens are refused, clearing the cookie
+func TestRefreshTokenRefused(t *testing.T) {
+	app, repo := newRefreshApp()
+
+	expired := testLogin(t, app, repo, 7, "family-1")
+	repo.rows[expired.RefreshToken].ExpiryDate = time.Now().Add(-time.Minute)
+	rec, cookie := refresh(app, expired.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "the stored expiry is enforced")
+	require.NotNil(t, cookie)
+	assert.Empty(t, cookie.Value)
+	assert.Nil(t, repo.rows[expired.RefreshToken].RotatedAt, "nothing is rotated")
+
+	login := testLogin(t, app, repo, 7, "family-2")
+	rec, _ = refresh(app, login.Token)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "an access token is not a refresh token")
+
+	delete(repo.rows, login.RefreshToken)
+	rec, _ = refresh(app, login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "logged out")
+
+	rec, _ = refresh(app, "garbage")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
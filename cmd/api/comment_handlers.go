@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// maxCommentLength - characters of a comment at most
+const maxCommentLength = 2000
+
+// CommentBookmark - Handler adding a comment of the current user to a bookmark
+func (app *application) CommentBookmark(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Body string `json:"body"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	body := strings.TrimSpace(bluemonday.UGCPolicy().Sanitize(payload.Body))
+	if body == "" {
+		app.errorJSON(w, errors.New("body is required"))
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		app.errorJSON(w, errors.New("comment too long"))
+		return
+	}
+
+	comment := &models.Comment{BookmarkID: bookmark.ID, UserID: user.ID, UserName: user.UserName, Body: body}
+	err = app.DB.InsertComment(comment)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to save comment"), http.StatusInternalServerError)
+		return
+	}
+	app.notifyOwner(r, models.NotifyBookmarkCommented, bookmark, map[string]interface{}{"comment_id": comment.ID})
+
+	_ = app.writeJSON(w, http.StatusCreated, comment)
+}
+
+// GetComments - Handler listing the comments of a bookmark, newest first
+func (app *application) GetComments(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.DB.GetComments(bookmark.ID, opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, page)
+}
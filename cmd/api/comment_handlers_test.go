@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commentRepo - the bookmarks of bookmarkRepo, keeping the comments inserted
+type commentRepo struct {
+	*bookmarkRepo
+	comments []*models.Comment
+}
+
+func (m *commentRepo) InsertComment(c *models.Comment) error {
+	c.ID = len(m.comments) + 1
+	m.comments = append(m.comments, c)
+	return nil
+}
+
+// TestCommentBookmark - the body of a comment is sanitized as the text of the bookmarks, and must hold some text
+func TestCommentBookmark(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		stored string
+	}{
+		{"plain", `{"body":"  Clear and short  "}`, http.StatusCreated, "Clear and short"},
+		{"markup kept", `{"body":"<b>Read</b> <a href=\"https://beej.us\">this</a>"}`, http.StatusCreated, `<b>Read</b> <a href="https://beej.us" rel="nofollow">this</a>`},
+		{"script stripped", `{"body":"nice<script>alert(1)</script><img src=x onerror=alert(1)>"}`, http.StatusCreated, `nice<img src="x">`},
+		{"only a script", `{"body":"<script>alert(1)</script>"}`, http.StatusBadRequest, ""},
+		{"empty", `{"body":"   "}`, http.StatusBadRequest, ""},
+		{"too long", `{"body":"` + strings.Repeat("a", maxCommentLength+1) + `"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, _, tokens := newBookmarkApp(t)
+			repo := &commentRepo{bookmarkRepo: bookmarks}
+			app := &application{DB: repo, events: newHub(), auth: Auth{Issuer: "test", Audience: "test", Keys: testKeys}}
+
+			req := httptest.NewRequest(http.MethodPost, "/bookmarks/id/1/comments", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tokens[bookmarkOther])
+			rec := httptest.NewRecorder()
+			app.routes().ServeHTTP(rec, req)
+
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status != http.StatusCreated {
+				assert.Empty(t, repo.comments, "nothing is stored")
+				return
+			}
+			require.Len(t, repo.comments, 1)
+			assert.Equal(t, tt.stored, repo.comments[0].Body)
+			assert.Equal(t, bookmarkOther, repo.comments[0].UserID)
+		})
+	}
+}
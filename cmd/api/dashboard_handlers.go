@@ -14,6 +14,12 @@ const uploadPath = "./uploads"
 
 // UploadAvatar - handling the avatar edition
 func (app *application) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	// ensure the directory to store avatarURL about users exists
 	// this function will be called by the first who change his default avatar basically
 	err := os.MkdirAll(uploadPath, os.ModePerm)
@@ -57,25 +63,14 @@ func (app *application) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 	// Construct the URL path
 	avatarURL := "/uploads/" + filepath.Base(tmpFile.Name())
 
-	cookie, err := r.Cookie("refresh_token")
-	if err != nil {
-		http.Error(w, "unable to extract cookie", http.StatusInternalServerError)
-		return
-	}
-
-	tokenStr := cookie.Value
-
 	// here we save avatarURL to the user's profile in the database
-	_, claims, _ := app.auth.GetTokenFromCookieAndVerify(tokenStr)
-	userID := claims.UserID
-
-	err = app.DB.SaveAvatarURL(userID, avatarURL)
+	err = app.DB.SaveAvatarURL(user.ID, avatarURL)
 	if err != nil {
 		http.Error(w, "unable to save avatar in database", http.StatusInternalServerError)
 		return
 	}
 
-	fullAvatarURL := "http://localhost:8080" + avatarURL
+	fullAvatarURL := app.APIURL + avatarURL
 	// return avatar URL to frontend
 	response := map[string]string{"avatar_url": fullAvatarURL}
 	app.writeJSON(w, http.StatusOK, response)
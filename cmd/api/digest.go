@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// digestBatch - users claimed at once by the digest job, digestLease - time they are held before being due again
+	digestBatch = 100
+	digestLease = 10 * time.Minute
+	// digestMaxBookmarks - bookmarks listed in a digest at most, the top-rated ones
+	digestMaxBookmarks = 50
+	// unsubscribeValidity - how long the unsubscribe link of a digest keeps working
+	unsubscribeValidity = 90 * 24 * time.Hour
+)
+
+// digestBookmark, digestProject - a digest, as seen by its template
+type digestBookmark struct {
+	Title string
+	URL   string
+}
+
+type digestProject struct {
+	Name      string
+	Category  string
+	Bookmarks []digestBookmark
+}
+
+// digestData - data of the digest template for user, with the link unsubscribing them
+func (app *application) digestData(user *models.User, projects []*models.DigestProject) (map[string]interface{}, error) {
+	token, err := app.auth.GeneratePurposeToken(purposeUnsubscribe, user.ID, unsubscribeValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []digestProject
+	for _, p := range projects {
+		entry := digestProject{Name: p.Name, Category: p.Category}
+		for _, b := range p.Bookmarks {
+			title := b.Description
+			if title == "" {
+				title = b.Url
+			}
+			entry.Bookmarks = append(entry.Bookmarks, digestBookmark{Title: title, URL: b.Url})
+		}
+		entries = append(entries, entry)
+	}
+
+	return map[string]interface{}{
+		"UserName":        user.UserName,
+		"Projects":        entries,
+		"SettingsLink":    app.FrontendURL + "/settings",
+		"UnsubscribeLink": tokenLink(app.APIURL, "/digest/unsubscribe", token),
+	}, nil
+}
+
+// sendDueDigests - queue the digests due at now, skipping the users without anything new; returns the number queued
+// A digest which could not be queued is tried again once the lease on its user is over, for the same period
+func (app *application) sendDueDigests(now time.Time) int {
+	queued := 0
+	for {
+		recipients, err := app.DB.ClaimDueDigests(now, now.Add(digestLease), digestBatch)
+		if err != nil {
+			log.Println("digest:", err)
+			return queued
+		}
+
+		for _, d := range recipients {
+			e, err := app.renderDigest(d)
+			if err == nil {
+				err = app.DB.CompleteDigest(d.User.ID, now, e)
+			}
+			if err != nil {
+				log.Printf("digest: failed to queue the digest of user %d: %v\n", d.User.ID, err)
+				continue
+			}
+			if e != nil {
+				queued++
+			}
+		}
+		if queued > 0 {
+			app.wakeOutbox()
+		}
+
+		if len(recipients) < digestBatch {
+			return queued
+		}
+	}
+}
+
+// renderDigest - the digest email of d, nil when there is nothing new for them
+func (app *application) renderDigest(d *models.DigestRecipient) (*models.OutboxEmail, error) {
+	projects, err := app.DB.GetDigestProjects(d.User.ID, d.Since, digestMaxBookmarks)
+	if err != nil || len(projects) == 0 {
+		return nil, err
+	}
+
+	data, err := app.digestData(&d.User, projects)
+	if err != nil {
+		return nil, err
+	}
+	e, err := app.renderEmail(&d.User, "digest", data)
+	if err != nil {
+		return nil, err
+	}
+	// the mail clients offer the one-click unsubscribe from the headers: a POST to the link of the body
+	e.UnsubscribeURL = data["UnsubscribeLink"].(string)
+	return e, nil
+}
+
+// runDigests - background job queuing the due digests, every interval
+func (app *application) runDigests(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n := app.sendDueDigests(time.Now()); n > 0 {
+			log.Printf("digest: queued %d digests\n", n)
+		}
+	}
+}
+
+// PreviewDigest - Handler rendering the digest of the current user without sending it, over the last period of
+// their frequency (a week when they turned it off)
+func (app *application) PreviewDigest(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	period, ok := models.DigestPeriods[user.DigestFrequency]
+	if !ok {
+		period = models.DigestPeriods[models.DigestWeekly]
+	}
+	projects, err := app.DB.GetDigestProjects(user.ID, time.Now().Add(-period), digestMaxBookmarks)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := app.digestData(user, projects)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	msg, err := app.templates.Render(user.Language, "digest", data)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subject":  msg.Subject,
+		"html":     msg.HTML,
+		"text":     msg.Text,
+		"projects": projects,
+		// empty digests are not sent
+		"empty": len(projects) == 0,
+	})
+}
+
+// ConfirmUnsubscribeDigest - Handler of the unsubscribe link of an email, changing nothing: mail scanners follow
+// links too. Sends the browser to the confirmation page of the frontend, which posts the token to UnsubscribeDigest
+func (app *application) ConfirmUnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := app.auth.ParsePurposeToken(purposeUnsubscribe, token); err != nil {
+		app.errorJSON(w, errors.New("invalid or expired unsubscribe link"))
+		return
+	}
+
+	http.Redirect(w, r, tokenLink(app.FrontendURL, "/digest-unsubscribe", token), http.StatusSeeOther)
+}
+
+// UnsubscribeDigest - Handler turning the digest off with the token of the unsubscribe link, no login needed
+// Posted by the confirmation page, or by the mail clients offering the one-click unsubscribe (RFC 8058)
+func (app *application) UnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.auth.ParsePurposeToken(purposeUnsubscribe, r.URL.Query().Get("token"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired unsubscribe link"))
+		return
+	}
+
+	err = app.DB.UpdateDigestFrequency(userID, models.DigestOff)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// digestRepo - two users due for a digest, only the first one with something new
+type digestRepo struct {
+	repository.DatabaseRepo
+	emails      []*models.OutboxEmail
+	frequencies map[int]string
+	last        map[int]time.Time // user id -> last digest
+	enqueueErr  error
+}
+
+func (m *digestRepo) ClaimDueDigests(now, leaseUntil time.Time, limit int) ([]*models.DigestRecipient, error) {
+	return []*models.DigestRecipient{
+		{User: models.User{ID: 1, UserName: "ned", Email: "ned@example.com", Language: "en", DigestFrequency: models.DigestWeekly}, Since: now.Add(-time.Hour)},
+		{User: models.User{ID: 2, UserName: "arya", Email: "arya@example.com", Language: "fr", DigestFrequency: models.DigestDaily}, Since: now.Add(-time.Hour)},
+	}, nil
+}
+
+func (m *digestRepo) GetDigestProjects(userID int, since time.Time, limit int) ([]*models.DigestProject, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+	return []*models.DigestProject{{ID: 3, Name: "readelf", Category: "System", Bookmarks: []*models.Bookmark{
+		{ID: 7, Url: "https://example.com/elf", Description: "The ELF format", AverageRating: 4.5},
+		{ID: 8, Url: "https://example.com/sections"},
+	}}}, nil
+}
+
+func (m *digestRepo) CompleteDigest(userID int, sentAt time.Time, e *models.OutboxEmail) error {
+	if e != nil {
+		if m.enqueueErr != nil {
+			return m.enqueueErr
+		}
+		m.emails = append(m.emails, e)
+	}
+	m.last[userID] = sentAt
+	return nil
+}
+
+func (m *digestRepo) UpdateDigestFrequency(userID int, frequency string) error {
+	m.frequencies[userID] = frequency
+	return nil
+}
+
+// TestDigest - digests are queued for the users with new bookmarks, and their link unsubscribes them
+func TestDigest(t *testing.T) {
+	repo := &digestRepo{frequencies: map[int]string{}, last: map[int]time.Time{}, enqueueErr: errors.New("connection reset")}
+	app := &application{
+		DB:          repo,
+		templates:   testTemplates,
+		auth:        Auth{Issuer: "test", Secret: "test-secret"},
+		FrontendURL: "http://front.test",
+		APIURL:      "http://api.test",
+	}
+
+	// the digest could not be queued: its period is kept for the next run
+	now := time.Now()
+	assert.Zero(t, app.sendDueDigests(now))
+	assert.NotContains(t, repo.last, 1)
+	assert.Equal(t, now, repo.last[2], "nothing new, the period is over all the same")
+
+	repo.enqueueErr = nil
+	assert.Equal(t, 1, app.sendDueDigests(now), "nothing new, no digest")
+	assert.Equal(t, now, repo.last[1])
+	require.Len(t, repo.emails, 1)
+	digest := repo.emails[0]
+	assert.Equal(t, "ned@example.com", digest.Recipient)
+	assert.Contains(t, digest.TextBody, "The ELF format: https://example.com/elf")
+	assert.Contains(t, digest.TextBody, "https://example.com/sections: https://example.com/sections", "the url stands for a missing description")
+	assert.Contains(t, digest.HTMLBody, "readelf")
+
+	link := regexp.MustCompile(`http://api.test/digest/unsubscribe\?token=\S+`).FindString(digest.TextBody)
+	require.NotEmpty(t, link)
+	assert.Equal(t, link, digest.UnsubscribeURL, "the List-Unsubscribe header carries the link of the body")
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+
+	// following the link only leads to the confirmation page
+	rec := httptest.NewRecorder()
+	app.ConfirmUnsubscribeDigest(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "http://front.test/digest-unsubscribe?"+u.RawQuery, rec.Header().Get("Location"))
+	assert.NotContains(t, repo.frequencies, 1, "a GET changes nothing")
+
+	// as posted by the mail clients (RFC 8058)
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, u.RequestURI(), strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app.UnsubscribeDigest(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, models.DigestOff, repo.frequencies[1])
+
+	// a token for another purpose is refused
+	other, err := app.auth.GeneratePurposeToken(purposeOauthLink, 2, time.Minute)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	app.UnsubscribeDigest(rec, httptest.NewRequest(http.MethodPost, "/digest/unsubscribe?token="+other, nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotContains(t, repo.frequencies, 2)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// eventsBuffer - notifications waiting for a slow stream; past it the stream is ended, and the client catches
+	// up on reconnecting: the notifications stay in the notifications table
+	eventsBuffer = 16
+	// eventsHeartbeat - comment sent on idle streams, so that proxies keep them open
+	eventsHeartbeat = 25 * time.Second
+	// eventsRetry - delay before the browsers reconnect a broken stream
+	eventsRetry = 5 * time.Second
+	// eventsCatchUp - missed notifications sent at most to a reconnecting client
+	eventsCatchUp = 100
+	// eventsListenRetry - delay before listening to postgres again after a failure
+	eventsListenRetry = 5 * time.Second
+)
+
+// Fan-outs of the notifications: local to the instance, or through postgres LISTEN/NOTIFY to every instance
+const (
+	fanoutLocal    = "local"
+	fanoutPostgres = "postgres"
+)
+
+// hub - in-process pub/sub of the notifications, per user, feeding their event streams
+// Every heartbeat, the idle streams are sent a comment and the credentials of every stream are checked again
+type hub struct {
+	mu        sync.Mutex
+	subs      map[int]map[*subscriber]struct{}
+	heartbeat time.Duration
+}
+
+// subscriber - a stream of the notifications of a user
+// lagging is closed when a notification could not be handed to it: it is dropped by the hub then, and is to be
+// ended so that its client reconnects and catches up
+type subscriber struct {
+	notifications chan *models.Notification
+	lagging       chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: map[int]map[*subscriber]struct{}{}, heartbeat: eventsHeartbeat}
+}
+
+// subscribe - subscriber to the notifications of a user, until the returned func is called
+func (h *hub) subscribe(userID int) (*subscriber, func()) {
+	sub := &subscriber{
+		notifications: make(chan *models.Notification, eventsBuffer),
+		lagging:       make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[*subscriber]struct{}{}
+	}
+	h.subs[userID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub, func() {
+		h.mu.Lock()
+		h.drop(userID, sub)
+		h.mu.Unlock()
+	}
+}
+
+// drop - forget sub, h.mu held
+func (h *hub) drop(userID int, sub *subscriber) {
+	delete(h.subs[userID], sub)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+// publish - hand n to the subscribers of its user, never waiting for a slow one: it is marked lagging instead
+func (h *hub) publish(n *models.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[n.UserID] {
+		select {
+		case sub.notifications <- n:
+		default:
+			close(sub.lagging)
+			h.drop(n.UserID, sub)
+		}
+	}
+}
+
+// publishNotifications - push notifications to the streams of their users: through postgres to every instance
+// with the postgres fan-out, else (or when postgres fails) to the streams of this instance
+func (app *application) publishNotifications(notifications ...*models.Notification) {
+	for _, n := range notifications {
+		if app.NotifyFanout == fanoutPostgres {
+			payload, err := json.Marshal(n)
+			if err == nil {
+				err = app.DB.PublishNotification(payload)
+			}
+			if err == nil {
+				continue
+			}
+			log.Printf("notifications: failed to publish notification %d, streamed by this instance only: %v\n", n.ID, err)
+		}
+		app.events.publish(n)
+	}
+}
+
+// listenNotifications - background job streaming the notifications published by every instance, until ctx is done
+func (app *application) listenNotifications(ctx context.Context) {
+	for {
+		err := app.DB.ListenNotifications(ctx, func(payload string) {
+			var n models.Notification
+			if err := json.Unmarshal([]byte(payload), &n); err != nil {
+				log.Println("notifications: invalid payload:", err)
+				return
+			}
+			app.events.publish(&n)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("notifications: listener stopped, listening again in %s: %v\n", eventsListenRetry, err)
+		time.Sleep(eventsListenRetry)
+	}
+}
+
+// writeEvent - n as a server-sent event, its id letting a reconnecting client resume after it
+func writeEvent(w io.Writer, n *models.Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", n.ID, data)
+	return err
+}
+
+// streamExpiry - when the credentials a stream was opened with expire, zero when they never do
+func streamExpiry(r *http.Request) time.Time {
+	if claims, ok := authClaims(r); ok && claims.ExpiresAt != nil {
+		return claims.ExpiresAt.Time
+	}
+	if token, ok := authAPIToken(r); ok && token.ExpiresAt != nil {
+		return *token.ExpiresAt
+	}
+	return time.Time{}
+}
+
+// checkStreamCredentials - whether the credentials a stream was opened with still stand: the jwt is not revoked
+// (logged out, password changed...), the personal access token is not deleted
+func (app *application) checkStreamCredentials(r *http.Request) error {
+	if claims, ok := authClaims(r); ok {
+		return app.auth.checkRevocation(claims)
+	}
+	if token, ok := authAPIToken(r); ok {
+		tokens, err := app.DB.GetAPITokensByUser(token.UserID)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if t.ID == token.ID {
+				return nil
+			}
+		}
+		return errors.New("api token deleted")
+	}
+	return nil
+}
+
+// Events - Handler streaming the notifications of the current user as server-sent events
+// A reconnecting client sends the id of the last event it got (Last-Event-ID), and is first sent what it missed.
+// A stream falling behind is ended, rather than silently skipping notifications. So is a stream whose credentials
+// expire or are revoked: the client reconnects, and has to authenticate again
+func (app *application) Events(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming unsupported"), http.StatusInternalServerError)
+		return
+	}
+
+	// subscribed before catching up, so that nothing falls in between
+	sub, unsubscribe := app.events.subscribe(user.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+
+	lastID := 0
+	if after, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && after > 0 {
+		missed, err := app.DB.GetNotificationsAfter(user.ID, after, eventsCatchUp)
+		if err != nil {
+			log.Printf("events: failed to catch user %d up: %v\n", user.ID, err)
+		}
+		for _, n := range missed {
+			if writeEvent(w, n) != nil {
+				return
+			}
+			lastID = n.ID
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(app.events.heartbeat)
+	defer heartbeat.Stop()
+
+	var expired <-chan time.Time
+	if at := streamExpiry(r); !at.IsZero() {
+		expiry := time.NewTimer(time.Until(at))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.lagging:
+			// notifications were left out: the client reconnects (see retry) with its Last-Event-ID and gets them
+			return
+		case <-expired:
+			return
+		case n := <-sub.notifications:
+			if n.ID <= lastID {
+				continue // already sent while catching up
+			}
+			if writeEvent(w, n) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := app.checkStreamCredentials(r); err != nil {
+				log.Printf("events: stream of user %d ended: %v\n", user.ID, err)
+				return
+			}
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
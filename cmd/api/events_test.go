@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventsRepo - notification 4 was missed by the client reconnecting; the personal access tokens of the users
+type eventsRepo struct {
+	repository.DatabaseRepo
+	mu     sync.Mutex
+	tokens []*models.APIToken
+}
+
+func (m *eventsRepo) GetNotificationsAfter(userID, afterID, limit int) ([]*models.Notification, error) {
+	return []*models.Notification{{ID: 4, UserID: userID, Kind: models.NotifyBookmarkRated}}, nil
+}
+
+func (m *eventsRepo) GetAPITokensByUser(userID int) ([]*models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []*models.APIToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *eventsRepo) deleteAPITokens() {
+	m.mu.Lock()
+	m.tokens = nil
+	m.mu.Unlock()
+}
+
+// TestHub - notifications reach the subscribers of their user only, and a slow subscriber never blocks: it is
+// dropped as lagging
+func TestHub(t *testing.T) {
+	h := newHub()
+	ned, unsubscribe := h.subscribe(1)
+	arya, _ := h.subscribe(2)
+	jon, _ := h.subscribe(1)
+
+	for i := 1; i <= eventsBuffer; i++ {
+		h.publish(&models.Notification{ID: i, UserID: 1})
+	}
+	assert.Len(t, ned.notifications, eventsBuffer)
+	assert.Empty(t, arya.notifications)
+	<-jon.notifications // jon keeps up, ned does not
+
+	h.publish(&models.Notification{ID: eventsBuffer + 1, UserID: 1})
+	select {
+	case <-ned.lagging:
+	default:
+		t.Fatal("past the buffer, the subscriber is lagging")
+	}
+	assert.NotContains(t, h.subs[1], ned, "a lagging subscriber gets nothing more")
+	assert.Contains(t, h.subs[1], jon)
+	assert.Len(t, jon.notifications, eventsBuffer)
+
+	unsubscribe() // dropped already, no harm
+	h.publish(&models.Notification{ID: 99, UserID: 2})
+	assert.Len(t, arya.notifications, 1)
+}
+
+// gatedWriter - a client reading nothing until the gate is opened
+type gatedWriter struct {
+	*httptest.ResponseRecorder
+	gate chan struct{}
+}
+
+func (w *gatedWriter) Write(b []byte) (int, error) {
+	<-w.gate
+	return w.ResponseRecorder.Write(b)
+}
+
+// TestEventsLagging - a stream falling behind is ended, for its client to reconnect and catch up
+func TestEventsLagging(t *testing.T) {
+	app := &application{DB: &eventsRepo{}, events: newHub(), NotifyFanout: fanoutLocal}
+	w := &gatedWriter{ResponseRecorder: httptest.NewRecorder(), gate: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.Events(w, withUser(httptest.NewRequest(http.MethodGet, "/events", nil), &models.User{ID: 1}))
+	}()
+	subscribed := func() bool {
+		app.events.mu.Lock()
+		defer app.events.mu.Unlock()
+		return len(app.events.subs[1]) == 1
+	}
+	require.Eventually(t, subscribed, time.Second, time.Millisecond)
+
+	for i := 1; i <= eventsBuffer+1; i++ {
+		app.publishNotifications(&models.Notification{ID: i, UserID: 1})
+	}
+	close(w.gate)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the lagging stream must be ended")
+	}
+	assert.False(t, subscribed())
+}
+
+// TestEvents - the stream first sends what the client missed, then the notifications as they come
+func TestEvents(t *testing.T) {
+	app := &application{DB: &eventsRepo{}, events: newHub(), NotifyFanout: fanoutLocal}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.Events(w, withUser(r, &models.User{ID: 1}))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewReader(resp.Body)
+	event := func() string {
+		var b strings.Builder
+		for {
+			line, err := lines.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return b.String()
+			}
+			b.WriteString(line)
+		}
+	}
+
+	assert.Equal(t, "retry: 5000\n", event())
+	assert.True(t, strings.HasPrefix(event(), "id: 4\nevent: notification\n"), "the missed notification comes first")
+
+	app.publishNotifications(
+		&models.Notification{ID: 4, UserID: 1},
+		&models.Notification{ID: 5, UserID: 2},
+		&models.Notification{ID: 6, UserID: 1, Kind: models.NotifyBookmarkNew},
+	)
+	next := event()
+	assert.True(t, strings.HasPrefix(next, "id: 6\n"), "already sent and others' notifications are skipped: %s", next)
+	assert.Contains(t, next, `"kind":"bookmark.new"`)
+}
+
+// stream - open the event stream of the principal p in the background; the returned channel is closed once it ended
+func stream(app *application, p *Principal) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		app.Events(httptest.NewRecorder(), r.WithContext(withPrincipal(r.Context(), p)))
+	}()
+	return done
+}
+
+// TestEventsCredentials - a stream lasts as long as its credentials: it ends when they expire, or are revoked
+func TestEventsCredentials(t *testing.T) {
+	newApp := func() (*application, *eventsRepo) {
+		repo := &eventsRepo{}
+		app := &application{DB: repo, events: newHub(), NotifyFanout: fanoutLocal}
+		app.events.heartbeat = 10 * time.Millisecond
+		app.revocations = newRevocationCache(func(jti string) (bool, error) { return false, nil }, time.Minute, time.Hour)
+		app.auth.Revocations = app.revocations
+		return app, repo
+	}
+	claims := func(expiry time.Duration) *Claims {
+		return &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ID: "jti-1", ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(expiry)}},
+			UserID:           1,
+			Type:             tokenTypeAccess,
+		}
+	}
+	user := &models.User{ID: 1}
+
+	ended := func(t *testing.T, done <-chan struct{}, msg string) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal(msg)
+		}
+	}
+	open := func(t *testing.T, done <-chan struct{}) {
+		select {
+		case <-done:
+			t.Fatal("the stream must stay open while its credentials stand")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Run("jwt expired", func(t *testing.T) {
+		app, _ := newApp()
+		app.events.heartbeat = time.Hour
+		done := stream(app, &Principal{User: user, Claims: claims(100 * time.Millisecond)})
+		open(t, done)
+		ended(t, done, "the stream must end when the token expires")
+	})
+
+	t.Run("jwt revoked", func(t *testing.T) {
+		app, _ := newApp()
+		done := stream(app, &Principal{User: user, Claims: claims(time.Hour)})
+		open(t, done)
+		app.markRevoked("jti-1")
+		ended(t, done, "the stream must end at the next heartbeat once the token is revoked")
+	})
+
+	t.Run("api token expired", func(t *testing.T) {
+		app, repo := newApp()
+		expiry := time.Now().Add(100 * time.Millisecond)
+		token := &models.APIToken{ID: 3, UserID: 1, ExpiresAt: &expiry}
+		repo.tokens = []*models.APIToken{token}
+		done := stream(app, &Principal{User: user, APIToken: token})
+		open(t, done)
+		ended(t, done, "the stream must end when the api token expires")
+	})
+
+	t.Run("api token deleted", func(t *testing.T) {
+		app, repo := newApp()
+		token := &models.APIToken{ID: 3, UserID: 1}
+		repo.tokens = []*models.APIToken{token, {ID: 4, UserID: 2}}
+		done := stream(app, &Principal{User: user, APIToken: token})
+		open(t, done)
+		repo.deleteAPITokens()
+		ended(t, done, "the stream must end at the next heartbeat once the api token is deleted")
+	})
+}
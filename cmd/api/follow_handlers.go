@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// followFromURL - type and id of the /follows/{type}/{id} url, writing the error response on failure
+func (app *application) followFromURL(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	followType := chi.URLParam(r, "type")
+	if !models.ValidFollowType(followType) {
+		app.errorJSON(w, errors.New("only projects, categories and users can be followed"))
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid "+followType+" id"))
+		return "", 0, false
+	}
+	return followType, id, true
+}
+
+// ListFollows - Handler listing the projects, categories and users followed by the current user
+func (app *application) ListFollows(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	follows, err := app.DB.GetFollows(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, follows)
+}
+
+// Follow - Handler following a project, category or user, whose activity then shows up in the feed (and the new
+// bookmarks of the projects in the digest)
+func (app *application) Follow(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+	followType, id, ok := app.followFromURL(w, r)
+	if !ok {
+		return
+	}
+	if followType == models.FollowUser && id == user.ID {
+		app.errorJSON(w, errors.New("you cannot follow yourself"))
+		return
+	}
+
+	err := app.DB.Follow(user.ID, followType, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such "+followType), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Unfollow - Handler to stop following a project, category or user
+func (app *application) Unfollow(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+	followType, id, ok := app.followFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.Unfollow(user.ID, followType, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("you do not follow this "+followType), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFeed - Handler listing the activity of what the current user follows: new and edited bookmarks, ratings and
+// comments, newest first
+func (app *application) GetFeed(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.DB.GetFeed(user.ID, opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, page)
+}
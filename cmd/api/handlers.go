@@ -2,7 +2,9 @@ package main
 
 import (
 	"bookmarks/internal/models"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
@@ -19,7 +21,7 @@ func (app *application) checkHealth(w http.ResponseWriter, r *http.Request) {
 
 // Home - Handler for Homepage - rather used for backlog information
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value("user").(*models.User)
+	user, ok := authUser(r)
 	authenticated := ok && user != nil
 
 	var payload = struct {
@@ -57,45 +59,229 @@ func (app *application) GetResourcesForProject(w http.ResponseWriter, r *http.Re
 	category := chi.URLParam(r, "category")
 	project := chi.URLParam(r, "project")
 
-	resources, err := app.DB.GetResourcesByCategoryAndProject(category, project)
+	opts, err := app.readListOptions(r)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(resources)
+
+	resources, err := app.DB.GetResourcesByCategoryAndProject(category, project, opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, resources)
 }
 
-// InsertNewBookmark - Handler to insert a new bookmark in the DB
+// InsertNewBookmark - Handler to insert a new bookmark in the DB, on behalf of the authenticated user
 func (app *application) InsertNewBookmark(w http.ResponseWriter, r *http.Request) {
 	var bookmark models.Bookmark
 
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+	if !user.Verified {
+		app.errorJSON(w, errors.New("please confirm your email address before posting bookmarks"), http.StatusForbidden)
+		return
+	}
+
 	err := json.NewDecoder(r.Body).Decode(&bookmark)
 	if err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
+	// Ownership comes from the token, never from the payload
+	bookmark.ID = 0
+	bookmark.UserID = user.ID
+
+	err = sanitizeBookmark(&bookmark)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Insert Sanitized bookmark into database
+	err = app.DB.InsertBookmark(&bookmark)
+	if err != nil {
+		http.Error(w, "Failed to insert bookmark", http.StatusInternalServerError)
+		return
+	}
+	app.notifyNewBookmark(&bookmark)
+
+	_ = app.writeJSON(w, http.StatusCreated, JSONResponse{
+		Error:   false,
+		Message: "Bookmark added successfully",
+		Data:    bookmark,
+	})
+}
+
+// sanitizeBookmark - validates the URL of a bookmark and sanitizes its free text fields
+func sanitizeBookmark(bookmark *models.Bookmark) error {
 	u, err := url.ParseRequestURI(bookmark.Url)
 	if err != nil || u.Scheme == "" || u.Host == "" {
 		log.Println(err)
-		http.Error(w, "Invalid URL provided", http.StatusBadRequest)
-		return
+		return errors.New("Invalid URL provided")
 	}
 
-	// Sanitize the text field 'description' from Bookmark model
+	// Sanitize the text fields 'description' and 'type' from Bookmark model
 	policy := bluemonday.UGCPolicy()
 	bookmark.Description = policy.Sanitize(bookmark.Description)
+	bookmark.Type = policy.Sanitize(bookmark.Type)
+	return nil
+}
 
-	// Insert Sanitized bookmark into database
-	err = app.DB.InsertBookmark(&bookmark)
+// canEditBookmark - only the owner of a bookmark or a user allowed to edit any bookmark (moderators) may modify it
+func canEditBookmark(user *models.User, bookmark *models.Bookmark) bool {
+	return user != nil && (user.ID == bookmark.UserID || user.Can(models.PermEditAnyBookmark))
+}
+
+// bookmarkFromURL - fetch the bookmark designated by the {id} url param, writing the error response on failure
+func (app *application) bookmarkFromURL(w http.ResponseWriter, r *http.Request) (*models.Bookmark, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, "Failed to insert bookmark", http.StatusInternalServerError)
+		app.errorJSON(w, errors.New("invalid bookmark id"))
+		return nil, false
+	}
+
+	bookmark, err := app.DB.GetBookmarkByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such bookmark"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return bookmark, true
+}
+
+// editableBookmarkFromURL - same as bookmarkFromURL, but also checks the user in context is allowed to modify it
+func (app *application) editableBookmarkFromURL(w http.ResponseWriter, r *http.Request) (*models.Bookmark, bool) {
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user, _ := authUser(r)
+	if !canEditBookmark(user, bookmark) {
+		app.errorJSON(w, errors.New("you are not allowed to modify this bookmark"), http.StatusForbidden)
+		return nil, false
+	}
+	return bookmark, true
+}
+
+// GetBookmark - Handler to serve a single bookmark by its id
+func (app *application) GetBookmark(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
 		return
 	}
+	_ = app.writeJSON(w, http.StatusOK, bookmark)
+}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Bookmark added successfully"})
+// UpdateBookmark - Handler to replace the editable fields of a bookmark (PUT)
+func (app *application) UpdateBookmark(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.editableBookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Url         string `json:"url"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+		ProjectID   int    `json:"project_id"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if payload.ProjectID == 0 {
+		app.errorJSON(w, errors.New("project_id is required"))
+		return
+	}
+
+	bookmark.Url = payload.Url
+	bookmark.Type = payload.Type
+	bookmark.Description = payload.Description
+	bookmark.ProjectID = payload.ProjectID
+
+	app.saveBookmark(w, r, bookmark)
+}
+
+// PatchBookmark - Handler to modify only the fields present in the payload (PATCH)
+func (app *application) PatchBookmark(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.editableBookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Url         *string `json:"url"`
+		Type        *string `json:"type"`
+		Description *string `json:"description"`
+		ProjectID   *int    `json:"project_id"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.Url != nil {
+		bookmark.Url = *payload.Url
+	}
+	if payload.Type != nil {
+		bookmark.Type = *payload.Type
+	}
+	if payload.Description != nil {
+		bookmark.Description = *payload.Description
+	}
+	if payload.ProjectID != nil {
+		bookmark.ProjectID = *payload.ProjectID
+	}
+
+	app.saveBookmark(w, r, bookmark)
+}
+
+// saveBookmark - common tail of PUT and PATCH: sanitize, persist and answer with the updated bookmark
+func (app *application) saveBookmark(w http.ResponseWriter, r *http.Request, bookmark *models.Bookmark) {
+	err := sanitizeBookmark(bookmark)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	actor, _ := authUser(r)
+	err = app.DB.UpdateBookmark(bookmark, actor.ID)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to update bookmark"), http.StatusInternalServerError)
+		return
+	}
+	app.auditModeration(r, models.AuditBookmarkUpdate, bookmark)
+	app.notifyOwner(r, models.NotifyBookmarkModerated, bookmark, map[string]interface{}{"action": models.AuditBookmarkUpdate})
+	_ = app.writeJSON(w, http.StatusOK, bookmark)
+}
+
+// DeleteBookmark - Handler to delete a bookmark
+func (app *application) DeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	bookmark, ok := app.editableBookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.DeleteBookmark(bookmark.ID)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to delete bookmark"), http.StatusInternalServerError)
+		return
+	}
+	app.auditModeration(r, models.AuditBookmarkDelete, bookmark)
+	app.notifyOwner(r, models.NotifyBookmarkModerated, bookmark, map[string]interface{}{"action": models.AuditBookmarkDelete})
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetUserInfo - Handler to retrieve user info (used accross the screens in FrontEnd - via useAuth context)
@@ -109,14 +295,12 @@ func (app *application) GetUserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
-	if err != nil || token == "" {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	user, ok := authUser(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Println("userid is\t", claims.UserID)
-
-	userID := strconv.Itoa(claims.UserID)
+	userID := strconv.Itoa(user.ID)
 
 	userInfo, err := app.DB.FetchUserFromDB(userID)
 	if err != nil {
@@ -129,15 +313,19 @@ func (app *application) GetUserInfo(w http.ResponseWriter, r *http.Request) {
 
 // GetContributors - Handler to retrieve all contributors
 func (app *application) GetContributors(w http.ResponseWriter, r *http.Request) {
-	var contributors []*models.User
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
-	contributors, err := app.DB.GetContributors()
+	contributors, err := app.DB.GetContributors(opts)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.listingError(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, contributors)
+	_ = app.writePage(w, r, contributors)
 }
 
 /*
@@ -147,11 +335,7 @@ DOUBLE FUNCTION WHICH ARE DOING THE SAME - REFACTOR !!!
 */
 // ListUsers - Handler to list all users
 func (app *application) ListUsers(w http.ResponseWriter, r *http.Request) {
-	var users []*models.User
-
-	users, _ = app.DB.GetContributors()
-
-	app.writeJSON(w, http.StatusAccepted, users)
+	app.GetContributors(w, r)
 }
 
 // ListBookmarksByUser - Handler to fetch the bookmarks according to a selected User
@@ -163,11 +347,26 @@ func (app *application) ListBookmarksByUser(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	bookmarks, err := app.DB.GetBookmarksByUser(userID)
+	opts, err := app.readListOptions(r)
 	if err != nil {
-		http.Error(w, "error fetching bookmarks", http.StatusInternalServerError)
+		app.errorJSON(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, bookmarks)
+	bookmarks, err := app.DB.GetBookmarksByUser(userID, opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+
+	_ = app.writePage(w, r, bookmarks)
+}
+
+// listingError - a bad cursor or sort order is the client's fault, anything else is ours
+func (app *application) listingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrInvalidCursor) || errors.Is(err, models.ErrUnsupportedSort) {
+		app.errorJSON(w, err)
+		return
+	}
+	app.errorJSON(w, err, http.StatusInternalServerError)
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bookmarkRepo - in-memory bookmarks on top of the users of routesRepo, recording the moderation side effects
+type bookmarkRepo struct {
+	*routesRepo
+	bookmarks     map[int]*models.Bookmark
+	audit         []*models.AuditEntry
+	notifications []*models.Notification
+}
+
+func (m *bookmarkRepo) GetBookmarkByID(id int) (*models.Bookmark, error) {
+	b, ok := m.bookmarks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *b
+	return &copied, nil
+}
+
+func (m *bookmarkRepo) InsertBookmark(bkm *models.Bookmark) error {
+	bkm.ID = len(m.bookmarks) + 1
+	m.bookmarks[bkm.ID] = bkm
+	return nil
+}
+
+func (m *bookmarkRepo) UpdateBookmark(bkm *models.Bookmark, actorID int) error {
+	if _, ok := m.bookmarks[bkm.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	bkm.UpdatedAt = time.Now()
+	m.bookmarks[bkm.ID] = bkm
+	return nil
+}
+
+func (m *bookmarkRepo) DeleteBookmark(id int) error {
+	if _, ok := m.bookmarks[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.bookmarks, id)
+	return nil
+}
+
+func (m *bookmarkRepo) InsertAuditEntry(e *models.AuditEntry) error {
+	m.audit = append(m.audit, e)
+	return nil
+}
+
+func (m *bookmarkRepo) InsertNotification(n *models.Notification) error {
+	m.notifications = append(m.notifications, n)
+	return nil
+}
+
+func (m *bookmarkRepo) NotifyFollowers(n *models.Notification, projectID int) ([]*models.Notification, error) {
+	return nil, nil
+}
+
+// Users of the bookmark tests
+const (
+	bookmarkOwner = iota + 1
+	bookmarkOther
+	bookmarkModerator
+	bookmarkUnverified
+)
+
+// newBookmarkApp - the routes of the application, with bookmark 1 of bookmarkOwner; returns the access tokens by user
+func newBookmarkApp(t *testing.T) (*bookmarkRepo, http.Handler, map[int]string) {
+	repo := &bookmarkRepo{
+		routesRepo: &routesRepo{&apiTokenRepo{users: map[int]*models.User{
+			bookmarkOwner:      {ID: bookmarkOwner, Verified: true},
+			bookmarkOther:      {ID: bookmarkOther, Verified: true},
+			bookmarkModerator:  {ID: bookmarkModerator, Verified: true, Roles: []string{models.RoleModerator}},
+			bookmarkUnverified: {ID: bookmarkUnverified},
+		}}},
+		bookmarks: map[int]*models.Bookmark{
+			1: {ID: 1, Url: "https://beej.us/guide/bgnet", Type: "article", Description: "Beej sockets guide", UserID: bookmarkOwner, ProjectID: 8},
+		},
+	}
+	app := &application{
+		DB:     repo,
+		events: newHub(),
+		auth:   Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, CookieName: "refresh_token"},
+	}
+
+	tokens := map[int]string{}
+	for id := range repo.users {
+		pair, err := app.auth.GenerateTokenPair(id)
+		require.NoError(t, err)
+		tokens[id] = "Bearer " + pair.Token
+	}
+	return repo, app.routes(), tokens
+}
+
+// TestEditBookmark - the owner and the moderators edit or delete a bookmark, nobody else
+func TestEditBookmark(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		user    int
+		body    string
+		status  int
+		audited bool
+	}{
+		{"owner put", http.MethodPut, "/bookmarks/id/1", bookmarkOwner, `{"url":"https://beej.us/guide/bgipc","type":"article","description":"IPC","project_id":8}`, http.StatusOK, false},
+		{"owner patch", http.MethodPatch, "/bookmarks/id/1", bookmarkOwner, `{"description":"Beej IPC guide"}`, http.StatusOK, false},
+		{"owner delete", http.MethodDelete, "/bookmarks/id/1", bookmarkOwner, ``, http.StatusNoContent, false},
+		{"other put", http.MethodPut, "/bookmarks/id/1", bookmarkOther, `{"url":"https://example.com","project_id":8}`, http.StatusForbidden, false},
+		{"other patch", http.MethodPatch, "/bookmarks/id/1", bookmarkOther, `{"description":"spam"}`, http.StatusForbidden, false},
+		{"other delete", http.MethodDelete, "/bookmarks/id/1", bookmarkOther, ``, http.StatusForbidden, false},
+		{"moderator patch", http.MethodPatch, "/bookmarks/id/1", bookmarkModerator, `{"description":"tidied up"}`, http.StatusOK, true},
+		{"moderator delete", http.MethodDelete, "/bookmarks/id/1", bookmarkModerator, ``, http.StatusNoContent, true},
+		{"unknown bookmark", http.MethodPatch, "/bookmarks/id/42", bookmarkOwner, `{"description":"nothing"}`, http.StatusNotFound, false},
+		{"unknown bookmark moderator", http.MethodDelete, "/bookmarks/id/42", bookmarkModerator, ``, http.StatusNotFound, false},
+		{"invalid url", http.MethodPatch, "/bookmarks/id/1", bookmarkOwner, `{"url":"not a url"}`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mux, tokens := newBookmarkApp(t)
+			before := *repo.bookmarks[1]
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tokens[tt.user])
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status >= http.StatusBadRequest {
+				assert.Equal(t, before, *repo.bookmarks[1], "a refused change leaves the bookmark alone")
+			}
+			if tt.audited {
+				require.Len(t, repo.audit, 1, "a moderation is audited")
+				assert.Equal(t, bookmarkModerator, repo.audit[0].ActorID)
+				require.Len(t, repo.notifications, 1, "and its owner notified")
+				assert.Equal(t, bookmarkOwner, repo.notifications[0].UserID)
+			} else {
+				assert.Empty(t, repo.audit)
+			}
+		})
+	}
+}
+
+// TestPatchBookmark - only the fields of the payload change, the text is sanitized
+func TestPatchBookmark(t *testing.T) {
+	repo, mux, tokens := newBookmarkApp(t)
+
+	req := httptest.NewRequest(http.MethodPatch, "/bookmarks/id/1", strings.NewReader(`{"description":"<b>IPC</b><script>alert(1)</script>"}`))
+	req.Header.Set("Authorization", tokens[bookmarkOwner])
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	b := repo.bookmarks[1]
+	assert.Equal(t, "<b>IPC</b>", b.Description)
+	assert.Equal(t, "https://beej.us/guide/bgnet", b.Url)
+	assert.Equal(t, 8, b.ProjectID)
+	assert.False(t, b.UpdatedAt.IsZero())
+}
+
+// TestInsertNewBookmark - verified users post bookmarks in their own name, whatever the payload says
+func TestInsertNewBookmark(t *testing.T) {
+	insert := func(mux http.Handler, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/contributors/insert-bookmark", strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("unverified user", func(t *testing.T) {
+		repo, mux, tokens := newBookmarkApp(t)
+		rec := insert(mux, tokens[bookmarkUnverified], `{"url":"https://go.dev/doc","type":"doc","project_id":8}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Len(t, repo.bookmarks, 1, "nothing is inserted")
+	})
+
+	t.Run("owner from the principal", func(t *testing.T) {
+		repo, mux, tokens := newBookmarkApp(t)
+		rec := insert(mux, tokens[bookmarkOther], `{"id":1,"url":"https://go.dev/doc","type":"doc","project_id":8,"user_id":`+strconv.Itoa(bookmarkOwner)+`}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		require.Len(t, repo.bookmarks, 2)
+		assert.Equal(t, bookmarkOwner, repo.bookmarks[1].UserID, "the existing bookmark is left alone")
+		assert.Equal(t, "https://beej.us/guide/bgnet", repo.bookmarks[1].Url)
+		assert.Equal(t, bookmarkOther, repo.bookmarks[2].UserID, "the user_id of the payload is ignored")
+		assert.Equal(t, "https://go.dev/doc", repo.bookmarks[2].Url)
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		_, mux, _ := newBookmarkApp(t)
+		rec := insert(mux, "", `{"url":"https://go.dev/doc","project_id":8}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
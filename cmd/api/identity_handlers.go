@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/markbates/goth"
+)
+
+// oauthLinkValidity - time given to the user to go through the provider's consent page when linking an account
+const oauthLinkValidity = 5 * time.Minute
+
+// oauthLinkCookie - carries the link token from LinkIdentity to HandleCallback
+// Only set in answer to the authenticated request of the user, so a link started by someone else cannot be slipped to them
+const oauthLinkCookie = "oauth_link"
+
+// setLinkCookie - store the link token in the browser, expire it when token is empty
+// The callback comes back from the provider's site: the strict refresh cookie is not sent along, this lax one is
+func setLinkCookie(w http.ResponseWriter, token string) {
+	maxAge := int(oauthLinkValidity.Seconds())
+	if token == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthLinkCookie,
+		Value:    token,
+		Path:     "/auth",
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+// identityFromGoth - identity of the provider account, linked to userID
+func identityFromGoth(userID int, gUser goth.User) *models.Identity {
+	return &models.Identity{
+		UserID:         userID,
+		Provider:       gUser.Provider,
+		ProviderUserID: gUser.UserID,
+		Email:          gUser.Email,
+	}
+}
+
+// errEmailUnconfirmed - the email of a provider account belongs to a user who never confirmed it
+var errEmailUnconfirmed = errors.New("an account awaiting the confirmation of this email already exists: " +
+	"log in with a login link or reset its password, then link this provider from your account")
+
+// identityUser - lookup-or-create of the user behind a provider account
+// An unknown provider account is linked to the verified user with the same email if any - provided the provider
+// vouches for that email - a new user is registered otherwise; errEmailUnconfirmed when the user with that email
+// is not verified
+func (app *application) identityUser(gUser goth.User) (*models.User, error) {
+	user, err := app.DB.GetUserByIdentity(gUser.Provider, gUser.UserID)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	verified := providerVerifiedEmail(gUser)
+	if verified {
+		existing, err := app.DB.GetUserByEmail(gUser.Email)
+		switch {
+		case err == nil && existing.Verified:
+			err = app.DB.LinkIdentity(identityFromGoth(existing.ID, gUser))
+			if err != nil {
+				return nil, err
+			}
+			return app.DB.GetUserByID(existing.ID)
+		case err == nil:
+			// nobody proved owning that account: its owner has to log in first, then link the provider
+			return nil, errEmailUnconfirmed
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+	}
+
+	user = &models.User{
+		UserName:  gUser.NickName,
+		AvatarURL: gUser.AvatarURL,
+		Verified:  verified,
+	}
+	if user.UserName == "" {
+		user.UserName = gUser.Name
+	}
+	if user.UserName == "" {
+		user.UserName = gUser.Provider + "-" + gUser.UserID
+	}
+	// emails are mandatory and unique, providers do not always share a confirmed one
+	if verified {
+		user.Email = gUser.Email
+	} else {
+		user.Email = fmt.Sprintf("%s-%s@users.noreply.invalid", gUser.Provider, gUser.UserID)
+	}
+	if user.AvatarURL == "" {
+		user.AvatarURL = fmt.Sprintf("https://api.dicebear.com/8.x/pixel-art/svg?seed=%s", url.QueryEscape(user.UserName))
+	}
+
+	err = app.DB.CreateUserWithIdentity(user, identityFromGoth(0, gUser))
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// pendingLink - user who asked to link the provider account being authenticated, 0 if none
+// The link cookie is single use: it is expired right away
+func (app *application) pendingLink(w http.ResponseWriter, r *http.Request) int {
+	cookie, err := r.Cookie(oauthLinkCookie)
+	if err != nil {
+		return 0
+	}
+	setLinkCookie(w, "")
+
+	userID, err := app.auth.ParsePurposeToken(purposeOauthLink, cookie.Value)
+	if err != nil {
+		return 0
+	}
+	return userID
+}
+
+// ListIdentities - Handler listing the provider accounts linked to the current user
+func (app *application) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := app.DB.GetIdentitiesByUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, identities)
+}
+
+// LinkIdentity - Handler starting the link of a provider account to the current user
+// The browser is to be sent to the returned url, which goes through the provider's consent page. The link token
+// never appears in that url: it stays in the cookie set here, bound to the browser of the user
+func (app *application) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	provider := chi.URLParam(r, "provider")
+	if _, err := goth.GetProvider(provider); err != nil {
+		app.errorJSON(w, fmt.Errorf("unknown provider %q", provider), http.StatusNotFound)
+		return
+	}
+
+	token, err := app.auth.GeneratePurposeToken(purposeOauthLink, user.ID, oauthLinkValidity)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	setLinkCookie(w, token)
+
+	_ = app.writeJSON(w, http.StatusOK, JSONResponse{
+		Error:   false,
+		Message: "continue to the provider to link your account",
+		Data: map[string]string{
+			"url": fmt.Sprintf("/auth/%s?link=1", url.PathEscape(provider)),
+		},
+	})
+}
+
+// UnlinkIdentity - Handler unlinking a provider account from the current user
+func (app *application) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid identity id"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.DeleteIdentity(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			app.errorJSON(w, errors.New("no such linked account"), http.StatusNotFound)
+		case errors.Is(err, models.ErrLastLoginMethod):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/faux"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityRepo - in-memory users and identities, enough for the Oauth callback
+type identityRepo struct {
+	repository.DatabaseRepo
+	users      map[int]*models.User
+	identities map[string]int // provider/provider user id -> user id
+	codes      map[string]int // authorization code hash -> user id
+	emailErr   error          // failure of the email lookups
+}
+
+func newIdentityRepo(users ...*models.User) *identityRepo {
+	repo := &identityRepo{users: map[int]*models.User{}, identities: map[string]int{}, codes: map[string]int{}}
+	for _, u := range users {
+		repo.users[u.ID] = u
+	}
+	return repo
+}
+
+func (m *identityRepo) GetUserByIdentity(provider, providerUserID string) (*models.User, error) {
+	id, ok := m.identities[provider+"/"+providerUserID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return m.users[id], nil
+}
+
+func (m *identityRepo) GetUserByEmail(email string) (models.User, error) {
+	if m.emailErr != nil {
+		return models.User{}, m.emailErr
+	}
+	for _, u := range m.users {
+		if u.Email == email {
+			return *u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *identityRepo) GetUserByID(userID int) (*models.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (m *identityRepo) LinkIdentity(identity *models.Identity) error {
+	key := identity.Provider + "/" + identity.ProviderUserID
+	if owner, ok := m.identities[key]; ok && owner != identity.UserID {
+		return models.ErrIdentityTaken
+	}
+	m.identities[key] = identity.UserID
+	return nil
+}
+
+func (m *identityRepo) CreateUserWithIdentity(u *models.User, identity *models.Identity) error {
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return errors.New(`duplicate key value violates unique constraint "users_email_key"`)
+		}
+	}
+	u.ID = len(m.users) + 1
+	m.users[u.ID] = u
+	identity.UserID = u.ID
+	return m.LinkIdentity(identity)
+}
+
+func (m *identityRepo) CreateAuthCode(userID int, codeHash string, expiresAt time.Time) error {
+	m.codes[codeHash] = userID
+	return nil
+}
+
+func (m *identityRepo) ConsumeAuthCode(codeHash string) (int, error) {
+	userID, ok := m.codes[codeHash]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	delete(m.codes, codeHash)
+	return userID, nil
+}
+
+func (m *identityRepo) CreateSession(s *models.Session) error { return nil }
+func (m *identityRepo) StoreTokenPairs(t *models.Token) error { return nil }
+
+func newIdentityApp(t *testing.T, repo *identityRepo) *application {
+	gothic.Store = sessions.NewCookieStore([]byte("test-session-secret"))
+	goth.UseProviders(&faux.Provider{})
+	verifiedEmailProviders["faux"] = true
+	t.Cleanup(func() { delete(verifiedEmailProviders, "faux") })
+
+	return &application{
+		DB: repo,
+		auth: Auth{
+			Issuer:        "test",
+			Audience:      "test",
+			Keys:          testKeys,
+			Secret:        "test-secret",
+			TokenExpiry:   time.Minute,
+			RefreshExpiry: time.Hour,
+			CookieName:    "refresh_token",
+		},
+	}
+}
+
+// fauxCallback - play the return from the faux provider, which authenticated the account sess
+func fauxCallback(t *testing.T, app *application, sess faux.Session, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	sess.AuthURL = "http://example.com/auth?state=state"
+	stored := httptest.NewRecorder()
+	err := gothic.StoreInSession("faux", sess.Marshal(), httptest.NewRequest(http.MethodGet, "/", nil), stored)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/faux/callback?state=state", nil)
+	for _, c := range append(stored.Result().Cookies(), cookies...) {
+		req.AddCookie(c)
+	}
+
+	mux := chi.NewRouter()
+	mux.Get("/auth/{provider}/callback", app.HandleCallback)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestHandleCallbackRepeatLogin - the first login registers the user, the next ones find it back
+func TestHandleCallbackRepeatLogin(t *testing.T) {
+	repo := newIdentityRepo()
+	app := newIdentityApp(t, repo)
+
+	sess := faux.Session{ID: "42", Name: "homer", Email: "homer@example.com"}
+	for i := 0; i < 2; i++ {
+		rec := fauxCallback(t, app, sess)
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "/dashboard?code=")
+	}
+
+	require.Len(t, repo.users, 1)
+	assert.Equal(t, 1, repo.identities["faux/42"])
+	assert.Equal(t, "homer", repo.users[1].UserName)
+	assert.True(t, repo.users[1].Verified)
+}
+
+// TestHandleCallbackMatchesEmail - a provider account with the email of a verified user logs that user in
+func TestHandleCallbackMatchesEmail(t *testing.T) {
+	repo := newIdentityRepo(&models.User{ID: 7, UserName: "marge", Email: "marge@example.com", Verified: true})
+	app := newIdentityApp(t, repo)
+
+	rec := fauxCallback(t, app, faux.Session{ID: "99", Name: "marge", Email: "marge@example.com"})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Len(t, repo.users, 1)
+	assert.Equal(t, 7, repo.identities["faux/99"])
+}
+
+// TestHandleCallbackUnconfirmedEmail - a provider account with the email of an unverified user neither logs it in
+// nor registers anyone: the owner of the email has to log in and link the provider
+func TestHandleCallbackUnconfirmedEmail(t *testing.T) {
+	squatter := &models.User{ID: 7, UserName: "snake", Email: "marge@example.com", Password: "$2a$12$squatter"}
+	repo := newIdentityRepo(squatter)
+	app := newIdentityApp(t, repo)
+
+	rec := fauxCallback(t, app, faux.Session{ID: "99", Name: "marge", Email: "marge@example.com"})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "link this provider")
+	assert.Len(t, repo.users, 1)
+	assert.Empty(t, repo.identities)
+	assert.Empty(t, repo.codes, "nobody is logged in")
+
+	repo.emailErr = errors.New("connection refused")
+	rec = fauxCallback(t, app, faux.Session{ID: "100", Name: "homer", Email: "homer@example.com"})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code, "a failed lookup is not taken for an unknown email")
+	assert.Len(t, repo.users, 1)
+}
+
+// TestHandleCallbackLink - with a link cookie, the provider account is linked to the requesting user
+func TestHandleCallbackLink(t *testing.T) {
+	repo := newIdentityRepo(
+		&models.User{ID: 1, UserName: "bart", Email: "bart@example.com"},
+		&models.User{ID: 2, UserName: "lisa", Email: "lisa@example.com"},
+	)
+	app := newIdentityApp(t, repo)
+
+	linkCookie := func(userID int) *http.Cookie {
+		token, err := app.auth.GeneratePurposeToken(purposeOauthLink, userID, time.Minute)
+		require.NoError(t, err)
+		return &http.Cookie{Name: oauthLinkCookie, Value: token}
+	}
+
+	rec := fauxCallback(t, app, faux.Session{ID: "5"}, linkCookie(1))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "linked=faux")
+	assert.Equal(t, 1, repo.identities["faux/5"])
+	assert.Len(t, repo.users, 2, "linking must not register anyone")
+
+	// already linked to bart
+	rec = fauxCallback(t, app, faux.Session{ID: "5"}, linkCookie(2))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, 1, repo.identities["faux/5"])
+}
+
+// TestLinkIdentityCookie - the link token is only handed to the browser of the user, never through the url
+func TestLinkIdentityCookie(t *testing.T) {
+	repo := newIdentityRepo(&models.User{ID: 1, UserName: "bart", Email: "bart@example.com"})
+	app := newIdentityApp(t, repo)
+	mux := chi.NewRouter()
+	mux.Post("/identities/{provider}", app.LinkIdentity)
+	mux.Get("/auth/{provider}", app.HandleAuth)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, withUser(httptest.NewRequest(http.MethodPost, "/identities/faux", nil), repo.users[1]))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res struct {
+		Data map[string]string `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, "/auth/faux?link=1", res.Data["url"])
+
+	var link *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oauthLinkCookie {
+			link = c
+		}
+	}
+	require.NotNil(t, link)
+	userID, err := app.auth.ParsePurposeToken(purposeOauthLink, link.Value)
+	require.NoError(t, err)
+	assert.Equal(t, 1, userID)
+
+	// a link url opened without the cookie of the user who started it, e.g. sent by someone else
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/faux?link=1", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// a plain login expires the link cookie of an abandoned link
+	req := httptest.NewRequest(http.MethodGet, "/auth/faux", nil)
+	req.AddCookie(link)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	require.NotEmpty(t, cookies)
+	assert.Equal(t, oauthLinkCookie, cookies[0].Name)
+	assert.Negative(t, cookies[0].MaxAge)
+}
+
+// TestPurposeToken - purpose tokens are signed with a key of their own per purpose
+func TestPurposeToken(t *testing.T) {
+	auth := Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret"}
+
+	token, err := auth.GeneratePurposeToken(purposeOauthLink, 3, time.Minute)
+	require.NoError(t, err)
+
+	userID, err := auth.ParsePurposeToken(purposeOauthLink, token)
+	require.NoError(t, err)
+	assert.Equal(t, 3, userID)
+
+	_, err = auth.ParsePurposeToken(purposeLoginChallenge, token)
+	assert.Error(t, err, "a token is only usable for its own purpose")
+	_, err = auth.ParseToken(token)
+	assert.Error(t, err, "a purpose token is not an access token")
+}
+
+// TestConfigureProviders - only the providers with credentials are enabled, with a default callback
+func TestConfigureProviders(t *testing.T) {
+	env := map[string]string{
+		"GITLAB_CLIENT": "id",
+		"GITLAB_SECRET": "secret",
+		"GOOGLE_CLIENT": "id", // no secret: disabled
+	}
+	providers, err := configureProviders("https://api.example.com/", func(key string) string { return env[key] })
+	require.NoError(t, err)
+
+	assert.Equal(t, []oauthProvider{{Name: "gitlab", Label: "GitLab", URL: "/auth/gitlab"}}, providers)
+	p, err := goth.GetProvider("gitlab")
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/auth/gitlab/callback", p.(*gitlab.Provider).CallbackURL)
+
+	env["OIDC_CLIENT"], env["OIDC_SECRET"] = "id", "secret"
+	_, err = configureProviders("https://api.example.com/", func(key string) string { return env[key] })
+	assert.Error(t, err, "the OpenID Connect provider needs its discovery url")
+}
+
+// TestProviderVerifiedEmail - emails are trusted when the provider vouches for them
+func TestProviderVerifiedEmail(t *testing.T) {
+	assert.True(t, providerVerifiedEmail(goth.User{Provider: "github", Email: "a@example.com"}))
+	assert.False(t, providerVerifiedEmail(goth.User{Provider: "github"}))
+	assert.True(t, providerVerifiedEmail(goth.User{Provider: "openid-connect", Email: "a@example.com",
+		RawData: map[string]interface{}{"email_verified": true}}))
+	assert.False(t, providerVerifiedEmail(goth.User{Provider: "openid-connect", Email: "a@example.com",
+		RawData: map[string]interface{}{"email_verified": false}}))
+	assert.False(t, providerVerifiedEmail(goth.User{Provider: "google", Email: "a@example.com"}))
+}
+
+// TestExchangeAuthCode - the code of an Oauth login is traded once for the tokens, which never appear in the redirect
+func TestExchangeAuthCode(t *testing.T) {
+	repo := newIdentityRepo()
+	app := newIdentityApp(t, repo)
+	app.FrontendURL = "https://bookmarks.example.com"
+
+	rec := fauxCallback(t, app, faux.Session{ID: "42", Name: "homer", Email: "homer@example.com"})
+	require.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "bookmarks.example.com", location.Host)
+	assert.NotContains(t, location.RawQuery, "token")
+	for _, c := range rec.Result().Cookies() {
+		assert.NotEqual(t, "refresh_token", c.Name, "no session before the exchange")
+	}
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	exchange := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/exchange", strings.NewReader(`{"code":"`+code+`"}`))
+		rec := httptest.NewRecorder()
+		app.ExchangeAuthCode(rec, req)
+		return rec
+	}
+
+	rec = exchange(code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		User  models.User `json:"user"`
+		Token string      `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, 1, body.User.ID)
+	assert.NotEmpty(t, body.Token)
+	require.Len(t, rec.Result().Cookies(), 1)
+	assert.Equal(t, "refresh_token", rec.Result().Cookies()[0].Name)
+
+	assert.Equal(t, http.StatusUnauthorized, exchange(code).Code, "a code is single use")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultJWTSecret - secret of the development setups, refused outside dev
+const defaultJWTSecret = "verysecretstuff"
+
+// envDev - the environment in which the development defaults are accepted, only ever set explicitly
+// envProduction - the environment when none is given: a forgotten setting must not relax anything
+const (
+	envDev        = "dev"
+	envProduction = "production"
+)
+
+// signingKey - private key signing tokens, named in their header by its kid
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+// KeySet - keys verifying the access and refresh tokens, one of them signing the new ones
+// Rotating a key: add the new one, make it the signing one, and keep the previous ones until the tokens they
+// signed have expired (the refresh token lifetime) - live tokens are never invalidated by a rotation
+type KeySet struct {
+	signing string
+	keys    map[string]*signingKey
+}
+
+// newSigningKey - key signing with RS256 (rsa, 2048 bits at least) or EdDSA (ed25519)
+func newSigningKey(kid string, key interface{}) (*signingKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %s: rsa keys need 2048 bits at least", kid)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, signer: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, signer: k}, nil
+	}
+	return nil, fmt.Errorf("key %s: unsupported key type %T, use rsa or ed25519", kid, key)
+}
+
+// newKeySet - key set signing with the key named signing
+func newKeySet(signing string, keys ...*signingKey) (*KeySet, error) {
+	ks := &KeySet{signing: signing, keys: make(map[string]*signingKey, len(keys))}
+	for _, k := range keys {
+		ks.keys[k.kid] = k
+	}
+	if _, ok := ks.keys[signing]; !ok {
+		return nil, fmt.Errorf("no key with kid %q to sign with", signing)
+	}
+	return ks, nil
+}
+
+// loadKeySet - key set of the PEM private keys (PKCS#8, or PKCS#1 for rsa) of dir, named by their file name
+// without the .pem extension. Without signing, the last kid in lexical order signs - name keys by date
+func loadKeySet(dir, signing string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .pem key in %s", dir)
+	}
+	sort.Strings(files)
+
+	var keys []*signingKey
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("key %s: no PEM data", kid)
+		}
+
+		var key interface{}
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		default:
+			err = fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+
+		k, err := newSigningKey(kid, key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if signing == "" {
+		signing = keys[len(keys)-1].kid
+	}
+	return newKeySet(signing, keys...)
+}
+
+// generateKeySet - key set of a single ed25519 key living as long as the process, for development
+func generateKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k, err := newSigningKey("dev-"+generateRandomString(8), private)
+	if err != nil {
+		return nil, err
+	}
+	return newKeySet(k.kid, k)
+}
+
+// sign - token of claims, signed by the signing key
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	k := ks.keys[ks.signing]
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.signer)
+}
+
+// keyFunc - public key verifying a token, chosen by the kid of its header
+// The algorithm of the token must be the one of the key, so that no token can pick how it is verified
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.signer.Public(), nil
+}
+
+// methods - algorithms of the keys of the set
+func (ks *KeySet) methods() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, k := range ks.keys {
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// jwk - public key in the JSON Web Key format (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// rsa
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// ed25519 (RFC 8037)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS - public keys of the set, sorted by kid
+func (ks *KeySet) JWKS() []jwk {
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := []jwk{}
+	for _, k := range ks.keys {
+		key := jwk{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.signer.Public().(type) {
+		case *rsa.PublicKey:
+			key.Kty = "RSA"
+			key.N = b64(pub.N.Bytes())
+			key.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			key.Kty = "OKP"
+			key.Crv = "Ed25519"
+			key.X = b64(pub)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+// configureKeys - keys signing the tokens, from the keys directory
+// Outside dev, the default secret and the lack of keys are refused; in dev an ephemeral key is generated,
+// invalidating the tokens on every restart
+func (app *application) configureKeys() (*KeySet, error) {
+	if app.Env != envDev && app.JWTSecret == defaultJWTSecret {
+		return nil, errors.New("refusing to start with the default jwt secret outside dev, set JWT_SECRET")
+	}
+	if app.JWTKeysDir != "" {
+		return loadKeySet(app.JWTKeysDir, app.JWTSigningKid)
+	}
+	if app.Env != envDev {
+		return nil, errors.New("no signing keys outside dev, set JWT_KEYS_DIR")
+	}
+	log.Println("warning: no JWT_KEYS_DIR, signing the tokens with an ephemeral key")
+	return generateKeySet()
+}
+
+// JWKS - Handler serving the public keys verifying our tokens, for the other services
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, map[string]interface{}{"keys": app.auth.Keys.JWKS()})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeys - keys signing the tokens of the tests
+var testKeys = func() *KeySet {
+	ks, err := generateKeySet()
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}()
+
+// writeKey - store key in dir as <kid>.pem
+func writeKey(t *testing.T, dir, kid string, key interface{}) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+}
+
+// TestKeyRotation - the tokens signed before a rotation stay valid, the new ones are signed by the new key
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeKey(t, dir, "2026-01", rsaKey)
+
+	before, err := loadKeySet(dir, "")
+	require.NoError(t, err)
+	auth := Auth{Issuer: "test", Audience: "test", Keys: before, TokenExpiry: time.Minute, RefreshExpiry: time.Hour}
+	old, err := auth.GenerateTokenPair(1)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	writeKey(t, dir, "2026-10", edKey)
+	auth.Keys, err = loadKeySet(dir, "")
+	require.NoError(t, err)
+
+	claims, err := auth.VerifyToken(old.Token)
+	require.NoError(t, err, "tokens of the previous key are still accepted")
+	assert.Equal(t, 1, claims.UserID)
+
+	fresh, err := auth.GenerateTokenPair(2)
+	require.NoError(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(fresh.Token, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", token.Header["kid"])
+	assert.Equal(t, "EdDSA", token.Header["alg"])
+
+	// once the previous key is retired, its tokens are refused
+	auth.Keys, err = newKeySet("2026-10", auth.Keys.keys["2026-10"])
+	require.NoError(t, err)
+	_, err = auth.VerifyToken(old.Token)
+	assert.Error(t, err)
+
+	_, err = loadKeySet(dir, "2025-12")
+	assert.Error(t, err, "the signing kid must exist")
+}
+
+// TestVerifyTokenClaims - the signature algorithm, the issuer and the audience are checked
+func TestVerifyTokenClaims(t *testing.T) {
+	auth := Auth{Issuer: "test", Audience: "bookmarks", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute}
+
+	other := auth
+	other.Audience = "another-service"
+	tokens, err := other.GenerateTokenPair(1)
+	require.NoError(t, err)
+	_, err = auth.VerifyToken(tokens.Token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	other = auth
+	other.Issuer = "someone-else"
+	tokens, err = other.GenerateTokenPair(1)
+	require.NoError(t, err)
+	_, err = auth.VerifyToken(tokens.Token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+
+	// a token signed with the secret, claiming the kid of a key, is refused
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{Issuer: "test", Audience: jwt.ClaimStrings{"bookmarks"}, ID: "jti"}, UserID: 1}
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = testKeys.signing
+	forged, err := hmac.SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	_, err = auth.VerifyToken(forged)
+	assert.Error(t, err)
+}
+
+// TestJWKS - the published keys verify our tokens
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeKey(t, dir, "rsa", rsaKey)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	writeKey(t, dir, "ed", edKey)
+
+	app := &application{auth: Auth{Issuer: "test", Audience: "test", TokenExpiry: time.Minute}}
+	app.auth.Keys, err = loadKeySet(dir, "rsa")
+	require.NoError(t, err)
+	tokens, err := app.auth.GenerateTokenPair(1)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	app.JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, jwk{Kty: "OKP", Kid: "ed", Use: "sig", Alg: "EdDSA", Crv: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey))}, set.Keys[0])
+
+	// verify as another service would, with the published rsa key only
+	published := set.Keys[1]
+	n, _ := base64.RawURLEncoding.DecodeString(published.N)
+	e, _ := base64.RawURLEncoding.DecodeString(published.E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	_, err = jwt.Parse(tokens.Token, func(*jwt.Token) (interface{}, error) { return pub, nil }, jwt.WithValidMethods([]string{published.Alg}))
+	assert.NoError(t, err)
+}
+
+// TestConfigureKeys - the development defaults are refused outside dev
+func TestConfigureKeys(t *testing.T) {
+	app := &application{Env: "production", JWTSecret: defaultJWTSecret}
+	_, err := app.configureKeys()
+	assert.Error(t, err)
+
+	app.JWTSecret = "a real secret"
+	_, err = app.configureKeys()
+	assert.Error(t, err, "keys are required outside dev")
+
+	// no environment given: as strict as production
+	app = &application{JWTSecret: defaultJWTSecret}
+	_, err = app.configureKeys()
+	assert.Error(t, err, "the default secret is refused when the environment is unset")
+	app.JWTSecret = "a real secret"
+	_, err = app.configureKeys()
+	assert.Error(t, err, "keys are required when the environment is unset")
+
+	app = &application{Env: envDev, JWTSecret: defaultJWTSecret}
+	ks, err := app.configureKeys()
+	assert.NoError(t, err)
+	assert.Len(t, ks.JWKS(), 1)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Login throttling - failed password logins are counted per account and per client, each one past a few
+blocking the next attempts for twice as long as the previous one. Enough failures on an account lock it for
+a while, and its owner is told by email.
+Accounts are counted by email, registered or not, and the answers are the same either way: they never tell
+whether an account exists.
+*/
+
+const (
+	// loginLockoutThreshold - failures of an account locking it, loginLockout - for how long
+	loginLockoutThreshold = 10
+	loginLockout          = 15 * time.Minute
+)
+
+// errInvalidCredentials - the one answer to an unknown email or a wrong password
+var errInvalidCredentials = errors.New("invalid email or password")
+
+// newLoginLimiters - failure counters per account and per client (shared by many users behind a NAT, hence looser)
+func newLoginLimiters() (account, client *backoffLimiter) {
+	account = newBackoffLimiter(3, time.Second, 5*time.Minute, loginLockoutThreshold, loginLockout)
+	client = newBackoffLimiter(20, time.Second, 5*time.Minute, 100, time.Hour)
+	return account, client
+}
+
+// dummyPasswordHash - compared against for unknown emails, so that they take as long to answer as registered ones
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not the password of anyone"), 12)
+	return hash
+})
+
+// loginAccountKey - key of the failures of an account
+func loginAccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBlocked - how long the logins to email from the client of r have to wait, 0 when they can go on
+func (app *application) loginBlocked(r *http.Request, email string) time.Duration {
+	wait := app.loginClientLimiter.Blocked(clientIP(r))
+	if accountWait := app.loginAccountLimiter.Blocked(loginAccountKey(email)); accountWait > wait {
+		wait = accountWait
+	}
+	return wait
+}
+
+// loginFailed - count a failed login to email from the client of r; user is nil for an unknown email
+// When the failure locks the account, the lockout is stored and its owner emailed
+func (app *application) loginFailed(r *http.Request, email string, user *models.User) {
+	app.loginClientLimiter.Fail(clientIP(r))
+	locked := app.loginAccountLimiter.Fail(loginAccountKey(email))
+	if !locked || user == nil {
+		return
+	}
+
+	err := app.DB.LockUser(user.ID, time.Now().Add(loginLockout))
+	if err != nil {
+		log.Printf("failed to lock user %d: %v\n", user.ID, err)
+	}
+	err = app.sendLockoutEmail(user, loginLockout)
+	if err != nil {
+		log.Printf("failed to queue lockout notice to user %d: %v\n", user.ID, err)
+	}
+}
+
+// tooManyLogins - answer a login attempt coming before the end of its backoff or lockout
+func (app *application) tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	_ = app.errorCodeJSON(w, errCodeTooManyRequests, errors.New("too many failed attempts, please try again later"), http.StatusTooManyRequests)
+}
+
+// UnlockUser - Admin handler lifting the lockout of an account after too many failed logins
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"))
+		return
+	}
+
+	user, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.DB.UnlockUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.loginAccountLimiter.Reset(loginAccountKey(user.Email))
+	app.audit(r, models.AuditUserUnlock, "user", user.ID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lockoutRepo - a single registered user, recording its lockouts
+type lockoutRepo struct {
+	repository.DatabaseRepo
+	user     models.User
+	locked   []int
+	unlocked []int
+	emails   []*models.OutboxEmail
+}
+
+func (m *lockoutRepo) GetUserByEmail(email string) (models.User, error) {
+	if email != m.user.Email {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.user, nil
+}
+
+func (m *lockoutRepo) GetUserByID(userID int) (*models.User, error) {
+	if userID != m.user.ID {
+		return nil, sql.ErrNoRows
+	}
+	u := m.user
+	return &u, nil
+}
+
+func (m *lockoutRepo) LockUser(userID int, until time.Time) error {
+	m.locked = append(m.locked, userID)
+	m.user.LockedUntil = until
+	return nil
+}
+
+func (m *lockoutRepo) UnlockUser(userID int) error {
+	m.unlocked = append(m.unlocked, userID)
+	m.user.LockedUntil = time.Time{}
+	return nil
+}
+
+func (m *lockoutRepo) EnqueueEmail(e *models.OutboxEmail) error {
+	m.emails = append(m.emails, e)
+	return nil
+}
+
+func (m *lockoutRepo) InsertAuditEntry(e *models.AuditEntry) error { return nil }
+
+// TestLoginLockout - failed logins back off then lock the account, the same way for unknown emails
+func TestLoginLockout(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	repo := &lockoutRepo{user: models.User{ID: 5, Email: "ned@example.com", Password: string(hash), Verified: true}}
+	app := &application{DB: repo, templates: testTemplates}
+	app.loginAccountLimiter, _ = newLoginLimiters()
+	// a client of its own per account here
+	app.loginClientLimiter = newBackoffLimiter(50, time.Second, time.Second, 100, time.Hour)
+
+	login := func(email, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+		rec := httptest.NewRecorder()
+		app.ClassicLogin(rec, req)
+		return rec
+	}
+	// skip the backoff, as if the client waited
+	wait := func(email string) {
+		app.loginAccountLimiter.failures[loginAccountKey(email)].blockedUntil = time.Time{}
+	}
+
+	wrong := login("ned@example.com", "wrong password")
+	unknown := login("nobody@example.com", "wrong password")
+	assert.Equal(t, http.StatusUnauthorized, wrong.Code)
+	assert.Equal(t, wrong.Body.String(), unknown.Body.String(), "unknown emails and wrong passwords look the same")
+
+	for _, email := range []string{"ned@example.com", "nobody@example.com"} {
+		for i := 2; i <= 3; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login(email, "wrong password").Code)
+		}
+		assert.Equal(t, http.StatusUnauthorized, login(email, "wrong password").Code)
+		rec := login(email, "right password")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code, "backing off, even with the right password")
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+		for i := 5; i <= loginLockoutThreshold; i++ {
+			wait(email)
+			assert.Equal(t, http.StatusUnauthorized, login(email, "wrong password").Code)
+		}
+		rec = login(email, "right password")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "900", rec.Header().Get("Retry-After"))
+	}
+	assert.Equal(t, []int{5}, repo.locked, "only registered accounts are stored as locked")
+	if assert.Len(t, repo.emails, 1, "the owner is told") {
+		assert.Equal(t, "ned@example.com", repo.emails[0].Recipient)
+	}
+
+	// the stored lockout holds after a restart
+	app.loginAccountLimiter, app.loginClientLimiter = newLoginLimiters()
+	assert.Equal(t, http.StatusTooManyRequests, login("ned@example.com", "right password").Code)
+
+	// until an admin lifts it
+	mux := chi.NewRouter()
+	mux.Delete("/admin/users/{userID}/lockout", app.UnlockUser)
+	req := httptest.NewRequest(http.MethodDelete, "/admin/users/5/lockout", nil)
+	req = req.WithContext(withPrincipal(req.Context(), &Principal{User: &models.User{ID: 1, IsAdmin: true}}))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []int{5}, repo.unlocked)
+	assert.Zero(t, app.loginBlocked(httptest.NewRequest(http.MethodPost, "/login", nil), "ned@example.com"))
+}
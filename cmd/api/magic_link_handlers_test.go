@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// magicLink - stored login link
+type magicLink struct {
+	userID    int
+	expiresAt time.Time
+}
+
+// magicLinkRepo - a single user, the login links and the emails queued for them
+type magicLinkRepo struct {
+	repository.DatabaseRepo
+	user   models.User
+	links  map[string]magicLink // token hash -> link
+	emails []*models.OutboxEmail
+}
+
+func (m *magicLinkRepo) GetUserByEmail(email string) (models.User, error) {
+	if !strings.EqualFold(email, m.user.Email) {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.user, nil
+}
+
+func (m *magicLinkRepo) GetUserByID(userID int) (*models.User, error) {
+	u := m.user
+	return &u, nil
+}
+
+func (m *magicLinkRepo) CreateMagicLink(userID int, tokenHash string, expiresAt time.Time) error {
+	m.links[tokenHash] = magicLink{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *magicLinkRepo) ConsumeMagicLink(tokenHash string) (int, error) {
+	link, ok := m.links[tokenHash]
+	if !ok || time.Now().After(link.expiresAt) {
+		return 0, sql.ErrNoRows
+	}
+	delete(m.links, tokenHash)
+	return link.userID, nil
+}
+
+func (m *magicLinkRepo) VerifyUser(userID int) error {
+	m.user.Verified = true
+	return nil
+}
+
+func (m *magicLinkRepo) EnqueueEmail(e *models.OutboxEmail) error {
+	m.emails = append(m.emails, e)
+	return nil
+}
+
+func (m *magicLinkRepo) CreateSession(s *models.Session) error { return nil }
+func (m *magicLinkRepo) StoreTokenPairs(t *models.Token) error { return nil }
+
+// magicLinkToken - token of the login link in the text of email
+var magicLinkToken = regexp.MustCompile(`/login/magic\?token=([^\s"&<]+)`)
+
+func newMagicLinkApp(repo *magicLinkRepo) *application {
+	return &application{
+		DB:               repo,
+		templates:        testTemplates,
+		FrontendURL:      "https://app.example.com",
+		auth:             Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, CookieName: "refresh_token"},
+		magicLinkLimiter: newRateLimiter(5, time.Hour),
+	}
+}
+
+// TestRequestMagicLink - unknown emails get the very same answer, known ones get a link by email
+func TestRequestMagicLink(t *testing.T) {
+	repo := &magicLinkRepo{
+		user:  models.User{ID: 3, Email: "lisa@example.com", Verified: true},
+		links: map[string]magicLink{},
+	}
+	app := newMagicLinkApp(repo)
+
+	request := func(email string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.RequestMagicLink(rec, httptest.NewRequest(http.MethodPost, "/login/magic", strings.NewReader(`{"email":"`+email+`"}`)))
+		return rec
+	}
+
+	known := request("lisa@example.com")
+	unknown := request("nobody@example.com")
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String(), "the answer must not tell whether the email is registered")
+
+	require.Len(t, repo.emails, 1)
+	assert.Equal(t, "lisa@example.com", repo.emails[0].Recipient)
+	assert.Regexp(t, magicLinkToken, repo.emails[0].TextBody)
+	assert.Len(t, repo.links, 1)
+
+	rec := request("")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestVerifyMagicLink - a link logs in once, verifying the account; reused or expired links are refused
+func TestVerifyMagicLink(t *testing.T) {
+	repo := &magicLinkRepo{
+		user:  models.User{ID: 3, Email: "lisa@example.com"},
+		links: map[string]magicLink{},
+	}
+	app := newMagicLinkApp(repo)
+
+	rec := httptest.NewRecorder()
+	app.RequestMagicLink(rec, httptest.NewRequest(http.MethodPost, "/login/magic", strings.NewReader(`{"email":"lisa@example.com"}`)))
+	require.Len(t, repo.emails, 1)
+	m := magicLinkToken.FindStringSubmatch(repo.emails[0].TextBody)
+	require.Len(t, m, 2)
+	token, err := url.QueryUnescape(m[1])
+	require.NoError(t, err)
+
+	verify := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.VerifyMagicLink(rec, httptest.NewRequest(http.MethodPost, "/login/magic/verify", strings.NewReader(`{"token":"`+token+`"}`)))
+		return rec
+	}
+
+	rec = verify(token)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res struct {
+		User  models.User `json:"user"`
+		Token string      `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, 3, res.User.ID)
+	claims, err := app.auth.ParseToken(res.Token)
+	require.NoError(t, err)
+	assert.Equal(t, 3, claims.UserID)
+	var refresh *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "refresh_token" {
+			refresh = c
+		}
+	}
+	require.NotNil(t, refresh, "the refresh cookie must be set")
+	assert.NotEmpty(t, refresh.Value)
+	assert.True(t, repo.user.Verified, "following the link proves the email")
+
+	rec = verify(token)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "a link is single use")
+
+	expired := generateRandomString(48)
+	repo.links[hashToken(expired)] = magicLink{userID: 3, expiresAt: time.Now().Add(-time.Minute)}
+	rec = verify(expired)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -1,41 +1,78 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"bookmarks/internal/mailer"
+	"bookmarks/internal/models"
+	"net/url"
 	"time"
-
-	mail "github.com/xhit/go-simple-mail/v2"
 )
 
-// sendConfirmationEmail - Function which open a smtp server to send an activation email to the new registered user
-func (app *application) sendConfirmationEmail(toEmail, emailToken string) error {
-	server := mail.NewSMTPClient()
-
-	server.Host = app.mailConfig.host
-	server.Username = app.mailConfig.username
-	server.Password = app.mailConfig.password
-	server.Port = app.mailConfig.port
-
-	server.KeepAlive = false
-	server.Encryption = mail.EncryptionTLS
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	smtpClient, err := server.Connect()
+// sendEmail - queue the email name, rendered in the language of user, in the outbox; the outbox worker delivers it
+// Never waits for the smtp server: a slow or down server only delays the email
+func (app *application) sendEmail(user *models.User, name string, data map[string]interface{}) error {
+	e, err := app.renderEmail(user, name, data)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(app.mailConfig.from).
-		AddTo(toEmail).
-		SetSubject("Confirm you email please").
-		SetBody(mail.TextHTML, fmt.Sprintf("Click the following link to confirm your email please <a href=\"http://localhost:8080/confirm-email?token=%s\">Confirm my email address</a>", emailToken))
-
-	err = email.Send(smtpClient)
+	err = app.DB.EnqueueEmail(e)
 	if err != nil {
 		return err
 	}
+	app.wakeOutbox()
 	return nil
 }
+
+// renderEmail - the email name to user, in their language, ready for the outbox
+func (app *application) renderEmail(user *models.User, name string, data map[string]interface{}) (*models.OutboxEmail, error) {
+	msg, err := app.templates.Render(user.Language, name, data)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OutboxEmail{Recipient: user.Email, Subject: msg.Subject, HTMLBody: msg.HTML, TextBody: msg.Text}, nil
+}
+
+// tokenLink - link to path of base, carrying token
+func tokenLink(base, path, token string) string {
+	return base + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendConfirmationEmail - send the link confirming the email address of a newly registered user
+func (app *application) sendConfirmationEmail(user *models.User, emailToken string) error {
+	return app.sendEmail(user, "confirmation", map[string]interface{}{
+		"Link":  tokenLink(app.APIURL, "/confirm-email", emailToken),
+		"Hours": int(emailTokenValidity.Hours()),
+	})
+}
+
+// sendPasswordResetEmail - send the single-use link to choose a new password
+func (app *application) sendPasswordResetEmail(user *models.User, resetToken string, validity time.Duration) error {
+	return app.sendEmail(user, "password_reset", map[string]interface{}{
+		"Link":    tokenLink(app.FrontendURL, "/reset-password", resetToken),
+		"Minutes": int(validity.Minutes()),
+	})
+}
+
+// sendMagicLinkEmail - send the single-use passwordless login link
+func (app *application) sendMagicLinkEmail(user *models.User, loginToken string, validity time.Duration) error {
+	return app.sendEmail(user, "magic_link", map[string]interface{}{
+		"Link":    tokenLink(app.FrontendURL, "/login/magic", loginToken),
+		"Minutes": int(validity.Minutes()),
+	})
+}
+
+// sendLockoutEmail - tell the owner of an account that too many failed logins locked it
+func (app *application) sendLockoutEmail(user *models.User, lockout time.Duration) error {
+	return app.sendEmail(user, "lockout", map[string]interface{}{
+		"Minutes":   int(lockout.Minutes()),
+		"ResetLink": app.FrontendURL + "/forgot-password",
+	})
+}
+
+// requestLanguage - language of the emails for a new user: the one asked for, else the one of the browser
+func requestLanguage(asked, acceptLanguage string) string {
+	if asked != "" {
+		return mailer.SupportedLanguage(asked)
+	}
+	return mailer.AcceptLanguage(acceptLanguage)
+}
@@ -1,18 +1,19 @@
 package main
 
 import (
+	"bookmarks/internal/mailer"
 	"bookmarks/internal/repository"
 	"bookmarks/internal/repository/dbrepo"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/markbates/goth"
-	"github.com/markbates/goth/providers/github"
 )
 
 // port on which the application listen (dev mode)
@@ -30,37 +31,89 @@ type MailConfig struct {
 // application - structure to pack the embedded variables in the application 'receiver'
 // Useful +++ because majority of Http Handler takes the application struct as a receiver method
 type application struct {
-	mailConfig   MailConfig
-	DSN          string
-	Domain       string
-	DB           repository.DatabaseRepo
-	auth         Auth
-	JWTSecret    string
-	JWTIssuer    string
-	JWTAudience  string
-	CookieDomain string
+	mailConfig MailConfig
+	DSN        string
+	Domain     string
+	DB         repository.DatabaseRepo
+	Search     repository.SearchRepo
+	auth       Auth
+	Env        string
+	JWTSecret  string
+	// JWTKeysDir - directory of the keys signing the tokens, JWTSigningKid - the one signing the new tokens
+	JWTKeysDir    string
+	JWTSigningKid string
+	JWTIssuer     string
+	JWTAudience   string
+	CookieDomain  string
+	FrontendURL   string
+	// APIURL - public url of this api, for the links pointing back to it
+	APIURL string
+
+	// Mailer - delivers the emails of the outbox, templates - render them, outboxWake - wakes the outbox worker up
+	Mailer     mailer.Mailer
+	templates  *mailer.Templates
+	outboxWake chan struct{}
+
+	// events - streams of the notifications, NotifyFanout - how they reach the other instances (local or postgres)
+	events       *hub
+	NotifyFanout string
+
+	// revocations - cache in front of the revoked tokens table
+	revocations *revocationCache
+
+	// providers - the Oauth providers enabled in the configuration
+	providers []oauthProvider
+
+	// resendLimiter - throttles the confirmation email resend endpoint, per client
+	resendLimiter *rateLimiter
+	// twoFactorLimiter - throttles the second factor attempts, per user
+	twoFactorLimiter *rateLimiter
+	// magicLinkLimiter - throttles the login links requests, per client and per email
+	magicLinkLimiter *rateLimiter
+	// forgotPasswordLimiter - throttles the password reset requests, per client and per email
+	forgotPasswordLimiter *rateLimiter
+	// loginAccountLimiter, loginClientLimiter - back off the failed password logins, per account and per client
+	loginAccountLimiter *backoffLimiter
+	loginClientLimiter  *backoffLimiter
+
+	// TokenExpiry - lifetime of access tokens, RefreshExpiry - lifetime of a login without calling /refresh
+	TokenExpiry   time.Duration
+	RefreshExpiry time.Duration
 }
 
-// init - Runs even before the main, used to load the environment variables
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("error: no .env file found. Shutting down")
+// envOr - value of the environment variable key, fallback when unset
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return fallback
 }
 
 // main - entry point of the application
 func main() {
 	var app application
 
+	// Load the environment variables, read by the flag defaults below
+	if err := godotenv.Load(); err != nil {
+		log.Fatal("error: no .env file found. Shutting down")
+	}
+
 	smtp_username := os.Getenv("SMTP_USERNAME")
 	smtp_password := os.Getenv("SMTP_PASSWORD")
 	smtp_from := os.Getenv("SMTP_FROM")
 
 	// Cmd line reading
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=12345 dbname=bookmarkers sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "verysecretstuff", "signing secret for jwt")
+	flag.StringVar(&app.Env, "env", envOr("APP_ENV", envProduction), "environment: dev (opt-in) accepts the development defaults")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", envOr("JWT_SECRET", defaultJWTSecret), "secret deriving the keys of the purpose tokens")
+	flag.StringVar(&app.JWTKeysDir, "jwt-keys", os.Getenv("JWT_KEYS_DIR"), "directory of the PEM keys signing the tokens (rsa or ed25519), named <kid>.pem")
+	flag.StringVar(&app.JWTSigningKid, "jwt-signing-kid", os.Getenv("JWT_SIGNING_KID"), "kid of the key signing the new tokens, the last one by default")
 	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "example.com", "signing issuer")
 	flag.StringVar(&app.JWTAudience, "jwt-audience", "example.com", "jwt audience")
+	flag.DurationVar(&app.TokenExpiry, "jwt-expiry", 15*time.Minute, "lifetime of the access token")
+	flag.DurationVar(&app.RefreshExpiry, "refresh-expiry", 7*24*time.Hour, "lifetime of the refresh token (and of the login)")
+	flag.StringVar(&app.APIURL, "api-url", envOr("API_URL", fmt.Sprintf("http://localhost:%d", port)), "public url of the api, for the links in the emails")
+	flag.StringVar(&app.FrontendURL, "frontend-url", envOr("FRONTEND_URL", "http://localhost:5173"), "origin of the frontend, where the Oauth logins are handed off")
 	// flag.StringVar(&app.CookieDomain, "domain", "localhost", "Cookie domain")
 	// Adding smtp mail configuration
 	flag.StringVar(&app.mailConfig.host, "smtp host", "sandbox.smtp.mailtrap.io", "smtp host")
@@ -68,7 +121,17 @@ func main() {
 	flag.StringVar(&app.mailConfig.username, "smtp username", smtp_username, "smtp user")
 	flag.StringVar(&app.mailConfig.password, "smtp password", smtp_password, "smtp password")
 	flag.StringVar(&app.mailConfig.from, "smtp from", smtp_from, "smtp from")
+	mailerKind := flag.String("mailer", envOr("MAILER", "smtp"), "how emails are delivered: smtp, or capture (kept, never sent)")
+	captureDir := flag.String("mail-capture-dir", os.Getenv("MAIL_CAPTURE_DIR"), "directory where the capture mailer writes the emails")
+	flag.StringVar(&app.NotifyFanout, "notify-fanout", envOr("NOTIFY_FANOUT", fanoutLocal), "how notifications reach the other instances: local (a single instance), or postgres (LISTEN/NOTIFY)")
 	flag.Parse()
+	app.FrontendURL = strings.TrimSuffix(app.FrontendURL, "/")
+	app.APIURL = strings.TrimSuffix(app.APIURL, "/")
+
+	keys, err := app.configureKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Connect to DB
 	conn, err := app.connectToDB()
@@ -79,21 +142,57 @@ func main() {
 	// populate releavant field of application struct
 	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
 	defer app.DB.Connection().Close()
+	app.Search = app.DB
 
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
 		Audience:      app.JWTAudience,
+		Keys:          keys,
 		Secret:        app.JWTSecret,
-		TokenExpiry:   time.Hour * 24,
-		RefreshExpiry: time.Hour * 24,
+		TokenExpiry:   app.TokenExpiry,
+		RefreshExpiry: app.RefreshExpiry,
 		CookiePath:    "/",
 		CookieName:    "refresh_token",
 		CookieDomain:  "localhost",
 	}
+	app.revocations = newRevocationCache(app.DB.IsTokenRevoked, 30*time.Second, app.RefreshExpiry)
+	app.auth.Revocations = app.revocations
+	go app.revocations.sweepEvery(time.Minute)
+
+	app.providers, err = configureProviders(app.APIURL, os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.resendLimiter = newRateLimiter(5, time.Hour)
+	app.twoFactorLimiter = newRateLimiter(5, loginChallengeValidity)
+	app.magicLinkLimiter = newRateLimiter(5, time.Hour)
+	app.forgotPasswordLimiter = newRateLimiter(5, time.Hour)
+	app.loginAccountLimiter, app.loginClientLimiter = newLoginLimiters()
+
+	app.Mailer, err = newMailer(*mailerKind, app.mailConfig, *captureDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.templates, err = mailer.LoadTemplates()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.outboxWake = make(chan struct{}, 1)
+
+	app.events = newHub()
+	switch app.NotifyFanout {
+	case fanoutLocal:
+	case fanoutPostgres:
+		go app.listenNotifications(context.Background())
+	default:
+		log.Fatalf("unknown notify fan-out %q, use local or postgres", app.NotifyFanout)
+	}
 
-	goth.UseProviders(
-		github.New(os.Getenv("GITHUB_CLIENT"), os.Getenv("GITHUB_SECRET"), os.Getenv("GITHUB_CALLBACK")),
-	)
+	go app.sweepExpiredSessions(time.Hour)
+	go app.runOutbox(time.Minute)
+	go app.sweepSentEmails(time.Hour)
+	go app.runDigests(time.Hour)
 
 	log.Println("Starting application on port", port)
 
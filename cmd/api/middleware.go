@@ -1,18 +1,21 @@
 package main
 
 import (
+	"bookmarks/internal/models"
 	"context"
-	"log"
+	"errors"
 	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // enableCORS - middleware to allow cross-origin-resource-sharing according to our custom rules
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173") // URL de votre frontend
+		w.Header().Set("Access-Control-Allow-Origin", app.FrontendURL)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -23,115 +26,172 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-// verifyTokenFromCookie - middleware to verify the token authenticity/validity embed in the request
-func (app *application) verifyTokenFromCookie(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := r.Cookie("refresh_token")
-		if err != nil {
-			http.Error(w, "no token found", http.StatusUnauthorized)
-			return
-		}
+// contextKey - type of the keys under which the middlewares store values in the request context
+// A type of its own, so that no other package can collide with them
+type contextKey string
 
-		// token := cookie.Value
-		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+const principalKey contextKey = "principal"
 
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// Principal - who a request is made by, and with which credential
+type Principal struct {
+	User *models.User
+	// Claims - jwt of the request: the access token, or the refresh token of the cookie; nil with an api token
+	Claims *Claims
+	// APIToken - personal access token of the request, nil for a session
+	APIToken *models.APIToken
 }
 
-// authRequired - middleware that check that authentication is ok - add the userID to context (for availability to next handlers)
-func (app *application) authRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			http.Error(w, "unauthorized: Invalid token", http.StatusUnauthorized)
-			return
-		}
+// withPrincipal - context of a request made by p
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
 
-		// Add userID to Context
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// principalFrom - principal of an authenticated request
+func principalFrom(r *http.Request) (*Principal, bool) {
+	p, ok := r.Context().Value(principalKey).(*Principal)
+	return p, ok && p != nil && p.User != nil
 }
 
-// middleware to check and protect the admin route
-func (app *application) adminRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var claims *Claims
-		var err error
+// authUser - user of an authenticated request
+func authUser(r *http.Request) (*models.User, bool) {
+	p, ok := principalFrom(r)
+	if !ok {
+		return nil, false
+	}
+	return p.User, true
+}
 
-		// Try to get the token from the cookie
-		cookie, err := r.Cookie("refresh_token")
-		if err == nil {
-			_, claims, err = app.auth.GetTokenFromCookieAndVerify(cookie.Value)
-		}
-		// If there's no valid cookie token, try the Authorization header
+// authClaims - jwt of a request authenticated by a session
+func authClaims(r *http.Request) (*Claims, bool) {
+	p, ok := principalFrom(r)
+	if !ok || p.Claims == nil {
+		return nil, false
+	}
+	return p.Claims, true
+}
+
+// authAPIToken - personal access token of a request authenticated by one
+func authAPIToken(r *http.Request) (*models.APIToken, bool) {
+	p, ok := principalFrom(r)
+	if !ok || p.APIToken == nil {
+		return nil, false
+	}
+	return p.APIToken, true
+}
+
+// errNoCredentials - the request carries neither an Authorization header nor a refresh cookie
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate - principal of the request, from the first credential it carries in this order:
+//  1. a personal access token in the Authorization header, within the scope the request needs
+//  2. an access token in the Authorization header - never a refresh token
+//  3. the refresh token cookie - also tried when the access token of the header is refused
+//
+// The status tells invalid credentials (401) from a personal access token lacking the scope (403)
+func (app *application) authenticate(w http.ResponseWriter, r *http.Request) (*Principal, int, error) {
+	w.Header().Add("Vary", "Authorization")
+
+	if raw := bearerAPIToken(r); raw != "" {
+		user, token, status, err := app.authenticateAPIToken(raw, requestScope(r))
 		if err != nil {
-			_, claims, err = app.auth.GetTokenFromHeaderAndVerify(w, r)
+			return nil, status, err
 		}
-		// If neither method succeeded, respond with Unauthorized
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		return &Principal{User: user, APIToken: token}, http.StatusOK, nil
+	}
+
+	err := errNoCredentials
+	var claims *Claims
+	if raw := bearerToken(r); raw != "" {
+		claims, err = app.auth.VerifyTokenType(raw, tokenTypeAccess)
+	} else if r.Header.Get("Authorization") != "" {
+		err = errors.New("invalid authorization header")
+	}
+	if err != nil {
+		if cookie, cookieErr := r.Cookie(app.auth.CookieName); cookieErr == nil {
+			var cookieClaims *Claims
+			cookieClaims, cookieErr = app.auth.VerifyTokenType(cookie.Value, tokenTypeRefresh)
+			if cookieErr == nil || errors.Is(err, errNoCredentials) {
+				claims, err = cookieClaims, cookieErr
+			}
 		}
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	user, err := app.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unknown user")
+	}
+	return &Principal{User: user, Claims: claims}, http.StatusOK, nil
+}
+
+// authError - answer a request whose credentials were refused
+// An expired access token gets its own code, telling the frontend to refresh it
+func (app *application) authError(w http.ResponseWriter, err error, status int) {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		_ = app.errorCodeJSON(w, errCodeTokenExpired, errors.New("expired token"), status)
+		return
+	}
+	if status == http.StatusUnauthorized {
+		err = errors.New("unauthorized")
+	}
+	_ = app.errorJSON(w, err, status)
+}
 
-		user, err := app.DB.GetUserByID(claims.UserID)
-		log.Printf("user retrieved from middleware => %+v\n", user)
-		if user.IsAdmin == false {
-			http.Error(w, "unauthorized to see this page", http.StatusUnauthorized)
+// optionalAuth - middleware authenticating the request when it carries credentials, anonymous otherwise
+// Credentials that are present but refused are still answered with an error, so the client knows to refresh them
+func (app *application) optionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, status, err := app.authenticate(w, r)
+		if errors.Is(err, errNoCredentials) {
+			next.ServeHTTP(w, r)
 			return
 		}
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			app.authError(w, err, status)
 			return
 		}
-		log.Println("Hello from middleware admin with user => \t", user)
-
-		// Store claims in the context
-		ctx := context.WithValue(r.Context(), "user", user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
 	})
 }
 
-/*
-=== DOUBLE CODE ALERT ===
-*/
-func (app *application) verifyToken(next http.Handler) http.Handler {
+// requireAuth - middleware letting through the authenticated requests only
+func (app *application) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var claims *Claims
-		var err error
-
-		// Try to get the token from the cookie
-		cookie, err := r.Cookie("refresh_token")
-		if err == nil {
-			_, claims, err = app.auth.GetTokenFromCookieAndVerify(cookie.Value)
-		}
-
-		// If there's no valid cookie token, try the Authorization header
+		p, status, err := app.authenticate(w, r)
 		if err != nil {
-			_, claims, err = app.auth.GetTokenFromHeaderAndVerify(w, r)
-		}
-
-		// If neither method succeeded, respond with Unauthorized
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			app.authError(w, err, status)
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
 
-		user, err := app.DB.GetUserByID(claims.UserID)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		log.Println("Hello from middleware with user => \t", user)
+// adminRequired - middleware protecting the admin area: an authenticated user allowed to manage users
+func (app *application) adminRequired(next http.Handler) http.Handler {
+	return app.requireAuth(app.requirePermission(models.PermManageUsers)(next))
+}
 
-		// Store claims in the context
-		ctx := context.WithValue(r.Context(), "user", user)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// requirePermission - middleware letting through the users whose roles grant permission
+// To be chained after requireAuth; personal access tokens also need the admin scope
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := principalFrom(r)
+			if !ok {
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+			if p.APIToken != nil && !p.APIToken.HasScope(models.ScopeAdmin) {
+				app.errorJSON(w, errors.New("api token lacks the admin scope"), http.StatusForbidden)
+				return
+			}
+			if !p.User.Can(permission) {
+				app.errorJSON(w, errors.New("unauthorized to see this page"), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
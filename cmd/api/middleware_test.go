@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// routesRepo - just enough for one route of each group to answer once past its middlewares
+type routesRepo struct {
+	*apiTokenRepo
+}
+
+func (m *routesRepo) GetRatingsByUser(userID int, opts models.ListOptions) (*models.Page, error) {
+	return &models.Page{Items: []*models.Rating{}}, nil
+}
+
+func (m *routesRepo) GetSessionsByUser(userID int) ([]*models.Session, error) { return nil, nil }
+
+func (m *routesRepo) GetTokenByJTI(jti string) (*models.Token, error) { return nil, sql.ErrNoRows }
+
+func (m *routesRepo) GetAuditLog(opts models.ListOptions) (*models.Page, error) {
+	return &models.Page{}, nil
+}
+
+func (m *routesRepo) GrantRole(userID int, role string, grantedBy int) error { return nil }
+
+func (m *routesRepo) InsertCategory(name string) (int, error) { return 1, nil }
+
+func (m *routesRepo) InsertAuditEntry(e *models.AuditEntry) error { return nil }
+
+// Bookmark 1, of the first user, stays as is whatever the requests do to it
+
+func (m *routesRepo) GetBookmarkByID(id int) (*models.Bookmark, error) {
+	if id != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return &models.Bookmark{ID: 1, Url: "https://beej.us/guide/bgnet", UserID: 1, ProjectID: 8}, nil
+}
+
+func (m *routesRepo) UpdateBookmark(bkm *models.Bookmark, actorID int) error { return nil }
+
+func (m *routesRepo) DeleteBookmark(id int) error { return nil }
+
+func (m *routesRepo) UpsertRating(userID, bookmarkID, rating int) (*models.Rating, error) {
+	return &models.Rating{UserID: userID, BookmarkID: bookmarkID, Rating: rating}, nil
+}
+
+func (m *routesRepo) DeleteRating(userID, bookmarkID int) error { return nil }
+
+func (m *routesRepo) GetRatingSummary(bookmarkID int) (*models.RatingSummary, error) {
+	return &models.RatingSummary{BookmarkID: bookmarkID}, nil
+}
+
+func (m *routesRepo) InsertComment(c *models.Comment) error { return nil }
+
+func (m *routesRepo) GetComments(bookmarkID int, opts models.ListOptions) (*models.Page, error) {
+	return &models.Page{Items: []*models.Comment{}}, nil
+}
+
+func (m *routesRepo) InsertNotification(n *models.Notification) error { return nil }
+
+func (m *routesRepo) GetFeed(userID int, opts models.ListOptions) (*models.Page, error) {
+	return &models.Page{Items: []*models.Activity{}}, nil
+}
+
+func (m *routesRepo) GetNotifications(userID int, unreadOnly bool, opts models.ListOptions) (*models.Page, error) {
+	return &models.Page{Items: []*models.Notification{}}, nil
+}
+
+func (m *routesRepo) MarkNotificationsRead(userID int, ids []int, read bool) (int64, error) {
+	return int64(len(ids)), nil
+}
+
+// TestAuthenticator - the credentials accepted by each route group, and the status of the refused ones
+func TestAuthenticator(t *testing.T) {
+	const (
+		member = iota + 1
+		curator
+		admin
+	)
+	repo := &routesRepo{&apiTokenRepo{
+		tokens: map[string]*models.APIToken{
+			hashToken("bkm_reader"):  {ID: 1, UserID: member, Scopes: []string{models.ScopeBookmarksRead}},
+			hashToken("bkm_curator"): {ID: 2, UserID: curator, Scopes: []string{models.ScopeAdmin}},
+			hashToken("bkm_writer"):  {ID: 3, UserID: admin, Scopes: []string{models.ScopeBookmarksWrite}},
+			hashToken("bkm_admin"):   {ID: 4, UserID: admin, Scopes: []string{models.ScopeAdmin}},
+		},
+		users: map[int]*models.User{
+			member:  {ID: member},
+			curator: {ID: curator, Roles: []string{models.RoleCurator}},
+			admin:   {ID: admin, IsAdmin: true, Roles: []string{models.RoleAdmin}},
+		},
+	}}
+	app := &application{
+		DB:     repo,
+		events: newHub(),
+		auth:   Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, CookieName: "refresh_token"},
+	}
+	mux := app.routes()
+
+	tokens := map[int]TokenPairs{}
+	for id := range repo.users {
+		pair, err := app.auth.GenerateTokenPair(id)
+		assert.NoError(t, err)
+		tokens[id] = pair
+	}
+	expiredAuth := app.auth
+	expiredAuth.TokenExpiry = -time.Minute
+	expired, err := expiredAuth.GenerateTokenPair(member)
+	assert.NoError(t, err)
+
+	jwt := func(id int) string { return "Bearer " + tokens[id].Token }
+	cookie := func(id int) string { return tokens[id].RefreshToken }
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		cookie string
+		status int
+	}{
+		// optional authentication
+		{"home anonymous", http.MethodGet, "/", "", "", http.StatusOK},
+		{"home authenticated", http.MethodGet, "/", jwt(member), "", http.StatusOK},
+		{"home expired token", http.MethodGet, "/", "Bearer " + expired.Token, "", http.StatusUnauthorized},
+		{"home malformed header", http.MethodGet, "/", "Basic abc", "", http.StatusUnauthorized},
+		{"home refresh token as bearer", http.MethodGet, "/", "Bearer " + tokens[member].RefreshToken, "", http.StatusUnauthorized},
+
+		// authentication required
+		{"dashboard anonymous", http.MethodGet, "/dashboard/my-ratings", "", "", http.StatusUnauthorized},
+		{"dashboard access token", http.MethodGet, "/dashboard/my-ratings", jwt(member), "", http.StatusOK},
+		{"dashboard cookie", http.MethodGet, "/dashboard/my-ratings", "", cookie(member), http.StatusOK},
+		{"dashboard refresh token as bearer", http.MethodGet, "/dashboard/my-ratings", "Bearer " + tokens[member].RefreshToken, "", http.StatusUnauthorized},
+		{"dashboard expired token, valid cookie", http.MethodGet, "/dashboard/my-ratings", "Bearer " + expired.Token, cookie(member), http.StatusOK},
+		{"dashboard access token as cookie", http.MethodGet, "/dashboard/my-ratings", "", tokens[member].Token, http.StatusUnauthorized},
+		{"dashboard garbage cookie", http.MethodGet, "/dashboard/my-ratings", "", "garbage", http.StatusUnauthorized},
+		{"dashboard api token", http.MethodGet, "/dashboard/my-ratings", "Bearer bkm_reader", "", http.StatusOK},
+		{"dashboard unknown api token", http.MethodGet, "/dashboard/my-ratings", "Bearer bkm_unknown", cookie(member), http.StatusUnauthorized},
+
+		// sessions only
+		{"account session", http.MethodGet, "/account/sessions", jwt(member), "", http.StatusOK},
+		{"account api token", http.MethodGet, "/account/sessions", "Bearer bkm_admin", "", http.StatusForbidden},
+		{"account anonymous", http.MethodGet, "/account/sessions", "", "", http.StatusUnauthorized},
+		{"user info api token", http.MethodGet, "/user-info", "Bearer bkm_admin", "", http.StatusForbidden},
+
+		// admin area
+		{"admin member", http.MethodGet, "/admin/audit-log", jwt(member), "", http.StatusForbidden},
+		{"admin curator", http.MethodGet, "/admin/audit-log", jwt(curator), "", http.StatusForbidden},
+		{"admin admin", http.MethodGet, "/admin/audit-log", jwt(admin), "", http.StatusOK},
+		{"admin api token", http.MethodGet, "/admin/audit-log", "Bearer bkm_admin", "", http.StatusOK},
+		{"admin api token without admin scope", http.MethodGet, "/admin/audit-log", "Bearer bkm_writer", "", http.StatusForbidden},
+		{"admin anonymous", http.MethodGet, "/admin/audit-log", "", "", http.StatusUnauthorized},
+		{"roles admin", http.MethodPut, "/admin/users/1/roles/curator", jwt(admin), "", http.StatusOK},
+		{"roles curator", http.MethodPut, "/admin/users/1/roles/curator", jwt(curator), "", http.StatusForbidden},
+
+		// bookmark edition - the owner of the bookmark (member) or a moderator
+		{"bookmark put owner", http.MethodPut, "/bookmarks/id/1", jwt(member), "", http.StatusOK},
+		{"bookmark put anonymous", http.MethodPut, "/bookmarks/id/1", "", "", http.StatusUnauthorized},
+		{"bookmark patch owner", http.MethodPatch, "/bookmarks/id/1", jwt(member), "", http.StatusOK},
+		{"bookmark patch owner cookie", http.MethodPatch, "/bookmarks/id/1", "", cookie(member), http.StatusOK},
+		{"bookmark patch curator", http.MethodPatch, "/bookmarks/id/1", jwt(curator), "", http.StatusForbidden},
+		{"bookmark patch admin", http.MethodPatch, "/bookmarks/id/1", jwt(admin), "", http.StatusOK},
+		{"bookmark patch refresh token as bearer", http.MethodPatch, "/bookmarks/id/1", "Bearer " + tokens[member].RefreshToken, "", http.StatusUnauthorized},
+		{"bookmark delete owner", http.MethodDelete, "/bookmarks/id/1", jwt(member), "", http.StatusNoContent},
+		{"bookmark delete api token", http.MethodDelete, "/bookmarks/id/1", "Bearer bkm_writer", "", http.StatusNoContent},
+		{"bookmark delete api token without write scope", http.MethodDelete, "/bookmarks/id/1", "Bearer bkm_reader", "", http.StatusForbidden},
+		{"bookmark delete anonymous", http.MethodDelete, "/bookmarks/id/1", "", "", http.StatusUnauthorized},
+
+		// ratings and comments - any user
+		{"rating another user", http.MethodPut, "/bookmarks/id/1/rating", jwt(curator), "", http.StatusOK},
+		{"rating api token without write scope", http.MethodPut, "/bookmarks/id/1/rating", "Bearer bkm_reader", "", http.StatusForbidden},
+		{"rating anonymous", http.MethodPut, "/bookmarks/id/1/rating", "", "", http.StatusUnauthorized},
+		{"unrate member", http.MethodDelete, "/bookmarks/id/1/rating", jwt(member), "", http.StatusOK},
+		{"unrate anonymous", http.MethodDelete, "/bookmarks/id/1/rating", "", "", http.StatusUnauthorized},
+		{"comments anonymous", http.MethodGet, "/bookmarks/id/1/comments", "", "", http.StatusOK},
+		{"comment another user", http.MethodPost, "/bookmarks/id/1/comments", jwt(curator), "", http.StatusCreated},
+		{"comment api token", http.MethodPost, "/bookmarks/id/1/comments", "Bearer bkm_writer", "", http.StatusCreated},
+		{"comment api token without write scope", http.MethodPost, "/bookmarks/id/1/comments", "Bearer bkm_reader", "", http.StatusForbidden},
+		{"comment anonymous", http.MethodPost, "/bookmarks/id/1/comments", "", "", http.StatusUnauthorized},
+
+		// feed, notifications and their stream
+		{"feed member", http.MethodGet, "/feed", jwt(member), "", http.StatusOK},
+		{"feed api token", http.MethodGet, "/feed", "Bearer bkm_reader", "", http.StatusOK},
+		{"feed anonymous", http.MethodGet, "/feed", "", "", http.StatusUnauthorized},
+		{"events member", http.MethodGet, "/events", jwt(member), "", http.StatusOK},
+		{"events cookie", http.MethodGet, "/events", "", cookie(member), http.StatusOK},
+		{"events expired token", http.MethodGet, "/events", "Bearer " + expired.Token, "", http.StatusUnauthorized},
+		{"events anonymous", http.MethodGet, "/events", "", "", http.StatusUnauthorized},
+		{"notifications member", http.MethodGet, "/notifications", jwt(member), "", http.StatusOK},
+		{"notifications anonymous", http.MethodGet, "/notifications", "", "", http.StatusUnauthorized},
+		{"mark notifications member", http.MethodPatch, "/notifications", jwt(member), "", http.StatusOK},
+		{"mark notifications api token without write scope", http.MethodPatch, "/notifications", "Bearer bkm_reader", "", http.StatusForbidden},
+
+		// catalog
+		{"catalog curator", http.MethodPost, "/catalog/categories", jwt(curator), "", http.StatusCreated},
+		{"catalog curator api token", http.MethodPost, "/catalog/categories", "Bearer bkm_curator", "", http.StatusCreated},
+		{"catalog member", http.MethodPost, "/catalog/categories", jwt(member), "", http.StatusForbidden},
+		{"catalog admin", http.MethodPost, "/catalog/categories", jwt(admin), "", http.StatusCreated},
+	}
+	// payload of the requests once past the middlewares, by path
+	payloads := map[string]string{
+		"/catalog/categories":      `{"category":"Go"}`,
+		"/bookmarks/id/1":          `{"url":"https://beej.us/guide/bgnet","description":"Beej sockets guide","project_id":8}`,
+		"/bookmarks/id/1/rating":   `{"rating":4}`,
+		"/bookmarks/id/1/comments": `{"body":"thanks"}`,
+		"/notifications":           `{"ids":[1]}`,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(payloads[tt.path]))
+			if tt.path == "/events" {
+				// the stream ends right away once past the middlewares
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "refresh_token", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+// TestAuthenticatorExpiredCode - an expired access token is told apart, so that the frontend refreshes it
+func TestAuthenticatorExpiredCode(t *testing.T) {
+	app := &application{auth: Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: -time.Minute}}
+	tokens, err := app.auth.GenerateTokenPair(1)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	rec := httptest.NewRecorder()
+	app.requireAuth(http.NotFoundHandler()).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), errCodeTokenExpired)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// notifyNewBookmark - notify the followers of the project of a bookmark that it was added
+func (app *application) notifyNewBookmark(bookmark *models.Bookmark) {
+	notifications, err := app.DB.NotifyFollowers(&models.Notification{
+		Kind:       models.NotifyBookmarkNew,
+		ActorID:    bookmark.UserID,
+		BookmarkID: bookmark.ID,
+		Details:    map[string]interface{}{"url": bookmark.Url, "project_id": bookmark.ProjectID},
+	}, bookmark.ProjectID)
+	if err != nil {
+		log.Printf("notifications: failed to notify the followers of project %d: %v\n", bookmark.ProjectID, err)
+		return
+	}
+	app.publishNotifications(notifications...)
+}
+
+// notifyOwner - notify the owner of a bookmark of what someone else did on it; failures are logged, it is done
+func (app *application) notifyOwner(r *http.Request, kind string, bookmark *models.Bookmark, details map[string]interface{}) {
+	actor, ok := authUser(r)
+	if !ok || actor == nil || actor.ID == bookmark.UserID {
+		return
+	}
+	details["url"] = bookmark.Url
+
+	n := &models.Notification{
+		UserID:     bookmark.UserID,
+		Kind:       kind,
+		ActorID:    actor.ID,
+		BookmarkID: bookmark.ID,
+		Details:    details,
+	}
+	err := app.DB.InsertNotification(n)
+	if err != nil {
+		log.Printf("notifications: failed to notify %s to user %d: %v\n", kind, bookmark.UserID, err)
+		return
+	}
+	app.publishNotifications(n)
+}
+
+// GetNotifications - Handler listing the notifications of the current user, newest first (?unread=true for the
+// unread ones only)
+func (app *application) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.DB.GetNotifications(user.ID, r.URL.Query().Get("unread") == "true", opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, page)
+}
+
+// MarkNotifications - Handler marking notifications of the current user as read, or unread with "read": false;
+// all of them when no ids are given
+func (app *application) MarkNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		IDs  []int `json:"ids"`
+		Read *bool `json:"read"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	read := payload.Read == nil || *payload.Read
+
+	updated, err := app.DB.MarkNotificationsRead(user.ID, payload.IDs, read)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, map[string]interface{}{"updated": updated})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bookmarks/internal/mailer"
+	"bookmarks/internal/models"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// outboxBatch - emails claimed at once, outboxSendTimeout - time given to the delivery of each
+	outboxBatch       = 20
+	outboxSendTimeout = 30 * time.Second
+	// outboxLease - time the claimed emails are held before they are due again: longer than a whole batch
+	// of deliveries, so that no other worker picks one up while this one may still send it
+	outboxLease = outboxBatch*outboxSendTimeout + 2*time.Minute
+	// outboxMaxAttempts - failed deliveries after which an email is dead
+	outboxMaxAttempts = 8
+	// outboxRetryBase, outboxRetryMax - delay after the first failure, doubling with every other one up to the max
+	outboxRetryBase = 30 * time.Second
+	outboxRetryMax  = 6 * time.Hour
+	// outboxSentRetention - time the sent emails are kept, for the record, before being purged
+	outboxSentRetention = 24 * time.Hour
+)
+
+// newMailer - the mailer of the configuration: smtp, or capture (kept in memory, and written to captureDir if set)
+func newMailer(kind string, config MailConfig, captureDir string) (mailer.Mailer, error) {
+	switch kind {
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Host:     config.host,
+			Port:     config.port,
+			Username: config.username,
+			Password: config.password,
+			From:     config.from,
+		}, nil
+	case "capture":
+		return &mailer.CaptureMailer{Dir: captureDir}, nil
+	}
+	return nil, errors.New("unknown mailer " + strconv.Quote(kind) + ", use smtp or capture")
+}
+
+// outboxRetryDelay - delay before the next delivery of an email which failed attempts times
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := outboxRetryBase
+	for i := 1; i < attempts && delay < outboxRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > outboxRetryMax {
+		delay = outboxRetryMax
+	}
+	return delay
+}
+
+// wakeOutbox - tell the outbox worker an email is waiting, without waiting for it
+func (app *application) wakeOutbox() {
+	select {
+	case app.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox - background job delivering the emails of the outbox, every interval or when woken up
+func (app *application) runOutbox(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for app.drainOutbox() == outboxBatch {
+			// a full batch, there may be more
+		}
+		select {
+		case <-ticker.C:
+		case <-app.outboxWake:
+		}
+	}
+}
+
+// drainOutbox - deliver one batch of due emails, returns how many were claimed
+// A failed delivery is retried later with an exponential backoff; after outboxMaxAttempts the email is dead.
+// Nothing is sent past the lease: the emails left are due again once it is over
+func (app *application) drainOutbox() int {
+	// microseconds, as stored by postgres: the lease identifies the claim when the emails are marked
+	lease := time.Now().Add(outboxLease).Truncate(time.Microsecond)
+	emails, err := app.DB.ClaimDueEmails(outboxBatch, lease)
+	if err != nil {
+		log.Println("outbox:", err)
+		return 0
+	}
+
+	for _, e := range emails {
+		if time.Until(lease) < outboxSendTimeout {
+			log.Printf("outbox: lease running out, leaving %d emails for later\n", len(emails))
+			break
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+		err := app.Mailer.Send(ctx, mailer.Message{To: e.Recipient, Subject: e.Subject, HTML: e.HTMLBody, Text: e.TextBody, UnsubscribeURL: e.UnsubscribeURL})
+		cancel()
+
+		if err == nil {
+			err = app.DB.MarkEmailSent(e.ID, lease)
+			if err != nil {
+				log.Printf("outbox: email %d sent, but not marked so: %v\n", e.ID, err)
+			}
+			continue
+		}
+
+		attempts := e.Attempts + 1
+		dead := attempts >= outboxMaxAttempts
+		if dead {
+			log.Printf("outbox: giving up on email %d after %d attempts: %v\n", e.ID, attempts, err)
+		}
+		markErr := app.DB.MarkEmailFailed(e.ID, lease, err.Error(), time.Now().Add(outboxRetryDelay(attempts)), dead)
+		if markErr != nil {
+			log.Println("outbox:", markErr)
+		}
+	}
+	return len(emails)
+}
+
+// sweepSentEmails - background job purging the sent emails once past their retention, every interval
+func (app *application) sweepSentEmails(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.DB.PurgeSentEmails(time.Now().Add(-outboxSentRetention))
+		if err != nil {
+			log.Println("outbox sweeper:", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("outbox sweeper: purged %d sent emails\n", n)
+		}
+	}
+}
+
+// ListDeadEmails - Admin handler listing the emails given up on
+// Their bodies are gone with the tokens they carried, which have expired by then anyway: the users ask for a new link
+func (app *application) ListDeadEmails(w http.ResponseWriter, r *http.Request) {
+	emails, err := app.DB.GetDeadEmails(100)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if emails == nil {
+		emails = []*models.OutboxEmail{}
+	}
+	_ = app.writeJSON(w, http.StatusOK, emails)
+}
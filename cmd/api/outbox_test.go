@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bookmarks/internal/mailer"
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTemplates - the templates of the emails sent by the tests
+var testTemplates = func() *mailer.Templates {
+	t, err := mailer.LoadTemplates()
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// outboxRepo - an in-memory outbox, and just enough of the users to register one
+type outboxRepo struct {
+	repository.DatabaseRepo
+	emails []*models.OutboxEmail
+}
+
+func (m *outboxRepo) EnqueueEmail(e *models.OutboxEmail) error {
+	e.ID = len(m.emails) + 1
+	e.Status = models.EmailPending
+	e.NextAttemptAt = time.Now()
+	m.emails = append(m.emails, e)
+	return nil
+}
+
+func (m *outboxRepo) ClaimDueEmails(limit int, leaseUntil time.Time) ([]*models.OutboxEmail, error) {
+	var due []*models.OutboxEmail
+	for _, e := range m.emails {
+		if e.Status == models.EmailPending && !e.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			e.NextAttemptAt = leaseUntil
+			due = append(due, e)
+		}
+	}
+	return due, nil
+}
+
+func (m *outboxRepo) MarkEmailSent(id int, leaseUntil time.Time) error {
+	e := m.emails[id-1]
+	if e.Status != models.EmailPending || !e.NextAttemptAt.Equal(leaseUntil) {
+		return sql.ErrNoRows
+	}
+	e.Status = models.EmailSent
+	e.Attempts++
+	e.HTMLBody, e.TextBody, e.UnsubscribeURL = "", "", ""
+	return nil
+}
+
+func (m *outboxRepo) MarkEmailFailed(id int, leaseUntil time.Time, lastError string, nextAttempt time.Time, dead bool) error {
+	e := m.emails[id-1]
+	if e.Status != models.EmailPending || !e.NextAttemptAt.Equal(leaseUntil) {
+		return sql.ErrNoRows
+	}
+	e.Attempts++
+	e.LastError = lastError
+	e.NextAttemptAt = nextAttempt
+	if dead {
+		e.Status = models.EmailDead
+		e.HTMLBody, e.TextBody, e.UnsubscribeURL = "", "", ""
+	}
+	return nil
+}
+
+func (m *outboxRepo) CheckEmailConflict(email string) (bool, error) { return false, nil }
+
+func (m *outboxRepo) InsertNewUser(username, email, password, emailToken, defaultAvatar, language string, emailTokenExpiry time.Time) (int, error) {
+	return 12, nil
+}
+
+// downMailer - an smtp server which is down
+type downMailer struct{ calls int }
+
+func (m *downMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.calls++
+	return errors.New("connection refused")
+}
+
+// TestRegisterDoesNotWaitForSMTP - the confirmation email is queued, the registration succeeds with the server down
+func TestRegisterDoesNotWaitForSMTP(t *testing.T) {
+	repo := &outboxRepo{}
+	down := &downMailer{}
+	app := &application{DB: repo, Mailer: down, templates: testTemplates, FrontendURL: "http://front.test", APIURL: "http://api.test"}
+
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"username":"ned","email":"ned@example.com","password":"winter is coming"}`))
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	app.RegisterNewUser(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Zero(t, down.calls, "nothing is sent during the request")
+	require.Len(t, repo.emails, 1)
+	assert.Equal(t, "ned@example.com", repo.emails[0].Recipient)
+	assert.Contains(t, repo.emails[0].HTMLBody, `lang="fr"`, "in the language of the browser")
+	assert.Contains(t, repo.emails[0].TextBody, "http://api.test/confirm-email?token=")
+}
+
+// TestDrainOutbox - failed deliveries are retried with a growing delay, then given up on
+func TestDrainOutbox(t *testing.T) {
+	repo := &outboxRepo{}
+	down := &downMailer{}
+	app := &application{DB: repo, Mailer: down, templates: testTemplates, FrontendURL: "http://front.test", APIURL: "http://api.test"}
+	ned := &models.User{Email: "ned@example.com", Language: "en"}
+	require.NoError(t, app.sendLockoutEmail(ned, time.Minute))
+
+	var delays []time.Duration
+	for i := 0; i < outboxMaxAttempts; i++ {
+		assert.Equal(t, 1, app.drainOutbox())
+		delays = append(delays, time.Until(repo.emails[0].NextAttemptAt).Round(time.Second))
+		repo.emails[0].NextAttemptAt = time.Now() // as if the delay was over
+	}
+	assert.Equal(t, []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}, delays[:4])
+	assert.Equal(t, models.EmailDead, repo.emails[0].Status)
+	assert.Equal(t, "connection refused", repo.emails[0].LastError)
+	assert.Empty(t, repo.emails[0].HTMLBody, "a dead email keeps no body")
+	assert.Zero(t, app.drainOutbox(), "dead emails are left alone")
+	assert.Equal(t, outboxMaxAttempts, down.calls)
+
+	// back up: the queued emails are delivered
+	capture := &mailer.CaptureMailer{Dir: t.TempDir()}
+	app.Mailer = capture
+	arya := &models.User{Email: "arya@example.com", Language: "en"}
+	require.NoError(t, app.sendLockoutEmail(arya, time.Minute))
+	repo.emails[1].UnsubscribeURL = "http://api.test/digest/unsubscribe?token=t" // as a digest
+	queued := *repo.emails[1]
+	assert.Equal(t, 1, app.drainOutbox())
+	assert.Equal(t, models.EmailSent, repo.emails[1].Status)
+	assert.Empty(t, repo.emails[1].HTMLBody, "a sent email keeps no body")
+	assert.Empty(t, repo.emails[1].TextBody)
+	sent := capture.Messages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "arya@example.com", sent[0].To)
+	assert.Equal(t, queued.HTMLBody, sent[0].HTML)
+	assert.Equal(t, queued.TextBody, sent[0].Text, "with the text alternative")
+	assert.Equal(t, queued.UnsubscribeURL, sent[0].UnsubscribeURL, "and the one-click unsubscribe link")
+	assert.Empty(t, repo.emails[1].UnsubscribeURL)
+}
+
+// reclaimingMailer - delivers, while another worker claims the email again
+type reclaimingMailer struct{ repo *outboxRepo }
+
+func (m *reclaimingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.repo.emails[0].NextAttemptAt = time.Now().Add(outboxLease)
+	return nil
+}
+
+// TestDrainOutboxLease - the lease outlasts a whole batch, and an email claimed again since is not marked
+func TestDrainOutboxLease(t *testing.T) {
+	assert.Greater(t, outboxLease, outboxBatch*outboxSendTimeout)
+
+	repo := &outboxRepo{}
+	app := &application{DB: repo, Mailer: &reclaimingMailer{repo: repo}, templates: testTemplates, FrontendURL: "http://front.test", APIURL: "http://api.test"}
+	require.NoError(t, app.sendLockoutEmail(&models.User{Email: "ned@example.com", Language: "en"}, time.Minute))
+
+	assert.Equal(t, 1, app.drainOutbox())
+	assert.Equal(t, models.EmailPending, repo.emails[0].Status, "the other worker holds it now")
+	assert.Zero(t, repo.emails[0].Attempts)
+	assert.NotEmpty(t, repo.emails[0].HTMLBody)
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	assert.Equal(t, outboxRetryBase, outboxRetryDelay(1))
+	assert.Equal(t, 4*outboxRetryBase, outboxRetryDelay(3))
+	assert.Equal(t, outboxRetryMax, outboxRetryDelay(100))
+}
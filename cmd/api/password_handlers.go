@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// passwordResetValidity - lifetime of a password reset link
+const passwordResetValidity = time.Hour
+
+// minPasswordLength - shortest password accepted when choosing a new one
+const minPasswordLength = 8
+
+// ForgotPassword - Handler emailing a password reset link
+// The answer is the same whether the email is registered or not, and the email is sent in the background
+// so that the response time does not tell either; a client and an email each get a few links per hour
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !app.forgotPasswordLimiter.Allow(clientIP(r)) {
+		app.errorCodeJSON(w, errCodeTooManyRequests, errors.New("too many requests, please try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	var payload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &payload)
+	email := strings.TrimSpace(payload.Email)
+	if err != nil || email == "" {
+		app.errorJSON(w, errors.New("email is required"))
+		return
+	}
+
+	response := JSONResponse{
+		Error:   false,
+		Message: "if this email is registered, a link to reset the password has been sent to it",
+	}
+
+	if !app.forgotPasswordLimiter.Allow("email:" + strings.ToLower(email)) {
+		_ = app.writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("forgot password:", err)
+		}
+		_ = app.writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	token := generateRandomString(48)
+	err = app.DB.CreatePasswordReset(user.ID, hashToken(token), time.Now().Add(passwordResetValidity))
+	if err != nil {
+		log.Println("forgot password:", err)
+		_ = app.writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	err = app.sendPasswordResetEmail(&user, token, passwordResetValidity)
+	if err != nil {
+		log.Printf("failed to queue password reset email to user %d: %v\n", user.ID, err)
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, response)
+}
+
+// ResetPassword - Handler setting a new password from a reset token, which unlocks the account, then logging the user
+// out everywhere
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if payload.Token == "" {
+		app.errorJSON(w, errors.New("token is required"))
+		return
+	}
+	if len(payload.Password) < minPasswordLength {
+		app.errorJSON(w, errors.New("password must be at least 8 characters long"))
+		return
+	}
+
+	userID, email, err := app.DB.ResetPassword(hashToken(payload.Token), payload.Password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("invalid or expired reset link, please ask for a new one"))
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// whoever knew the old password must not stay logged in
+	jtis, err := app.DB.DeleteTokensPairOnLogOut(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.markRevoked(jtis...)
+	// the account is unlocked along with the new password, so are the logins to it
+	app.loginAccountLimiter.Reset(loginAccountKey(email))
+
+	_ = app.writeJSON(w, http.StatusOK, JSONResponse{
+		Error:   false,
+		Message: "password updated, please log in with your new password",
+	})
+}
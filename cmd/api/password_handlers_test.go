@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passwordResetRepo - a single user with its reset links and logins, and the emails queued for them
+type passwordResetRepo struct {
+	repository.DatabaseRepo
+	user     models.User
+	password string
+	resets   map[string]int // token hash -> user id
+	jtis     []string       // jwt ids of the logins of the user
+	emails   []*models.OutboxEmail
+}
+
+func (m *passwordResetRepo) GetUserByEmail(email string) (models.User, error) {
+	if !strings.EqualFold(email, m.user.Email) {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.user, nil
+}
+
+func (m *passwordResetRepo) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	m.resets[tokenHash] = userID
+	return nil
+}
+
+func (m *passwordResetRepo) ResetPassword(tokenHash, newPassword string) (int, string, error) {
+	userID, ok := m.resets[tokenHash]
+	if !ok {
+		return 0, "", sql.ErrNoRows
+	}
+	delete(m.resets, tokenHash)
+	m.password = newPassword
+	return userID, m.user.Email, nil
+}
+
+func (m *passwordResetRepo) DeleteTokensPairOnLogOut(userID int) ([]string, error) {
+	jtis := m.jtis
+	m.jtis = nil
+	return jtis, nil
+}
+
+func (m *passwordResetRepo) EnqueueEmail(e *models.OutboxEmail) error {
+	m.emails = append(m.emails, e)
+	return nil
+}
+
+// passwordResetToken - token of the reset link in the text of email
+var passwordResetToken = regexp.MustCompile(`/reset-password\?token=([^\s"&<]+)`)
+
+func newPasswordResetApp(repo *passwordResetRepo) *application {
+	return &application{
+		DB:                    repo,
+		templates:             testTemplates,
+		FrontendURL:           "https://app.example.com",
+		revocations:           newRevocationCache(func(jti string) (bool, error) { return false, nil }, time.Minute, time.Hour),
+		forgotPasswordLimiter: newRateLimiter(5, time.Hour),
+		loginAccountLimiter:   newBackoffLimiter(0, time.Minute, time.Hour, 3, time.Hour),
+	}
+}
+
+// forgotPassword - ask for a reset link of email, from the client at ip
+func forgotPassword(app *application, email, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/forgot-password", strings.NewReader(`{"email":"`+email+`"}`))
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	app.ForgotPassword(rec, req)
+	return rec
+}
+
+// TestForgotPassword - unknown emails get the very same answer, known ones get a link; clients and emails are throttled
+func TestForgotPassword(t *testing.T) {
+	repo := &passwordResetRepo{
+		user:   models.User{ID: 3, Email: "lisa@example.com", Verified: true},
+		resets: map[string]int{},
+	}
+	app := newPasswordResetApp(repo)
+
+	known := forgotPassword(app, "lisa@example.com", "192.0.2.1")
+	unknown := forgotPassword(app, "nobody@example.com", "192.0.2.1")
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String(), "the answer must not tell whether the email is registered")
+
+	require.Len(t, repo.emails, 1)
+	assert.Equal(t, "lisa@example.com", repo.emails[0].Recipient)
+	assert.Regexp(t, passwordResetToken, repo.emails[0].TextBody)
+	assert.Len(t, repo.resets, 1)
+
+	// an email gets a few links per hour, whatever the client asking
+	for i := 0; i < 6; i++ {
+		rec := forgotPassword(app, "LISA@example.com", "198.51.100."+strconv.Itoa(i+1))
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	}
+	assert.Len(t, repo.emails, 5, "the answer stays the same, no more link is sent")
+
+	// a client gets a few requests per hour, whatever the email
+	for i := 0; i < 3; i++ {
+		forgotPassword(app, "nobody@example.com", "192.0.2.1")
+	}
+	rec := forgotPassword(app, "nobody@example.com", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), errCodeTooManyRequests)
+
+	rec = forgotPassword(app, "", "203.0.113.1")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestResetPassword - a reset link sets the password once, unlocks the account and logs the user out everywhere
+func TestResetPassword(t *testing.T) {
+	repo := &passwordResetRepo{
+		user:   models.User{ID: 3, Email: "lisa@example.com", Verified: true},
+		resets: map[string]int{},
+		jtis:   []string{"access-1", "refresh-1"},
+	}
+	app := newPasswordResetApp(repo)
+
+	forgotPassword(app, "lisa@example.com", "192.0.2.1")
+	require.Len(t, repo.emails, 1)
+	m := passwordResetToken.FindStringSubmatch(repo.emails[0].TextBody)
+	require.Len(t, m, 2)
+	token, err := url.QueryUnescape(m[1])
+	require.NoError(t, err)
+
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/reset-password", strings.NewReader(`{"token":"`+token+`","password":"`+password+`"}`)))
+		return rec
+	}
+
+	// locked out by failed logins
+	for i := 0; i < 3; i++ {
+		app.loginAccountLimiter.Fail(loginAccountKey("lisa@example.com"))
+	}
+	require.NotZero(t, app.loginAccountLimiter.Blocked(loginAccountKey("lisa@example.com")))
+
+	rec := reset(token, "short")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, repo.password)
+
+	rec = reset(token, "correct horse battery")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "correct horse battery", repo.password)
+	assert.Zero(t, app.loginAccountLimiter.Blocked(loginAccountKey("Lisa@example.com")), "the account is unlocked")
+	assert.Empty(t, repo.jtis, "the logins of the user are deleted")
+	for _, jti := range []string{"access-1", "refresh-1"} {
+		revoked, err := app.revocations.IsRevoked(jti)
+		assert.NoError(t, err)
+		assert.True(t, revoked, "the tokens of %s are revoked at once", jti)
+	}
+
+	rec = reset(token, "another password")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "a link is single use")
+	assert.Equal(t, "correct horse battery", repo.password)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bookmarks/internal/mailer"
+	"bookmarks/internal/models"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// UpdatePreferences - Handler changing the preferences of the current user: the language of the emails, and how
+// often the digest of the followed projects is sent
+func (app *application) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Language        *string `json:"language"`
+		DigestFrequency *string `json:"digest_frequency"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.DigestFrequency != nil && !models.ValidDigestFrequency(*payload.DigestFrequency) {
+		app.errorJSON(w, errors.New("digest_frequency must be daily, weekly or off"))
+		return
+	}
+
+	if payload.Language != nil {
+		language := strings.ToLower(*payload.Language)
+		if mailer.SupportedLanguage(language) != language {
+			app.errorJSON(w, errors.New("supported languages are "+strings.Join(mailer.Languages, ", ")))
+			return
+		}
+		err = app.DB.UpdateLanguage(user.ID, language)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		user.Language = language
+	}
+	if payload.DigestFrequency != nil {
+		err = app.DB.UpdateDigestFrequency(user.ID, *payload.DigestFrequency)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		user.DigestFrequency = *payload.DigestFrequency
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, map[string]interface{}{"language": user.Language, "digest_frequency": user.DigestFrequency})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// oauthProvider - a login provider enabled in the configuration, as shown to the frontend
+type oauthProvider struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// configureProviders - register with goth the Oauth providers whose client id and secret are set
+// Each provider <P> (GITHUB, GITLAB, GOOGLE, OIDC) is configured by <P>_CLIENT, <P>_SECRET and optionally
+// <P>_CALLBACK (defaults to <apiURL>/auth/<name>/callback, apiURL being the public url of the api the emails
+// link to as well); the OpenID Connect one also needs OIDC_DISCOVERY_URL, and OIDC_LABEL names its login button
+func configureProviders(apiURL string, getenv func(string) string) ([]oauthProvider, error) {
+	apiURL = strings.TrimSuffix(apiURL, "/")
+
+	// credentials of the provider configured under prefix, with its callback url - ok is false when not configured
+	credentials := func(prefix, name string) (client, secret, callback string, ok bool) {
+		client, secret, callback = getenv(prefix+"_CLIENT"), getenv(prefix+"_SECRET"), getenv(prefix+"_CALLBACK")
+		if callback == "" {
+			callback = fmt.Sprintf("%s/auth/%s/callback", apiURL, name)
+		}
+		return client, secret, callback, client != "" && secret != ""
+	}
+
+	var providers []goth.Provider
+	var enabled []oauthProvider
+	add := func(p goth.Provider, label string) {
+		providers = append(providers, p)
+		enabled = append(enabled, oauthProvider{Name: p.Name(), Label: label, URL: "/auth/" + p.Name()})
+	}
+
+	if client, secret, callback, ok := credentials("GITHUB", "github"); ok {
+		add(github.New(client, secret, callback, "user:email"), "GitHub")
+	}
+	if client, secret, callback, ok := credentials("GITLAB", "gitlab"); ok {
+		add(gitlab.New(client, secret, callback, "read_user"), "GitLab")
+	}
+	if client, secret, callback, ok := credentials("GOOGLE", "google"); ok {
+		add(google.New(client, secret, callback, "email", "profile"), "Google")
+	}
+	if client, secret, callback, ok := credentials("OIDC", "openid-connect"); ok {
+		discovery := getenv("OIDC_DISCOVERY_URL")
+		if discovery == "" {
+			return nil, fmt.Errorf("OIDC_DISCOVERY_URL is required to enable the OpenID Connect provider")
+		}
+		// fetches the discovery document
+		p, err := openidConnect.New(client, secret, callback, discovery, "openid", "email", "profile")
+		if err != nil {
+			return nil, fmt.Errorf("openid connect provider: %w", err)
+		}
+		label := getenv("OIDC_LABEL")
+		if label == "" {
+			label = "Single sign-on"
+		}
+		add(p, label)
+	}
+
+	goth.ClearProviders()
+	goth.UseProviders(providers...)
+	return enabled, nil
+}
+
+// verifiedEmailProviders - providers which only ever expose confirmed emails (GitHub and GitLab primary emails)
+var verifiedEmailProviders = map[string]bool{"github": true, "gitlab": true}
+
+// providerVerifiedEmail - whether the provider vouches for the email of the account
+// Google and OpenID Connect providers tell it in a claim
+func providerVerifiedEmail(gUser goth.User) bool {
+	if gUser.Email == "" {
+		return false
+	}
+	if verifiedEmailProviders[gUser.Provider] {
+		return true
+	}
+	for _, claim := range []string{"email_verified", "verified_email"} {
+		if verified, ok := gUser.RawData[claim].(bool); ok {
+			return verified
+		}
+	}
+	return false
+}
+
+// ListProviders - Handler listing the enabled login providers, for the frontend to render its login buttons
+func (app *application) ListProviders(w http.ResponseWriter, r *http.Request) {
+	providers := app.providers
+	if providers == nil {
+		providers = []oauthProvider{}
+	}
+	_ = app.writeJSON(w, http.StatusOK, providers)
+}
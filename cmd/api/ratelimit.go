@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter - in-memory fixed window counter: at most limit hits per key within window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string]*rateWindow),
+	}
+}
+
+// Allow - count a hit for key, telling whether it is within the limit
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.hits[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.sweep(now)
+		l.hits[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// sweep - forget the windows which are over (caller holds the lock)
+func (l *rateLimiter) sweep(now time.Time) {
+	for k, w := range l.hits {
+		if now.Sub(w.start) >= l.window {
+			delete(l.hits, k)
+		}
+	}
+}
+
+// backoffLimiter - in-memory failure counter with exponential backoff: past free failures, each failure blocks
+// the key for twice as long as the previous one (up to maxBackoff), and every lockAfter failures lock it for lockout
+// Failures are forgotten once the key went a lockout without failing
+type backoffLimiter struct {
+	mu         sync.Mutex
+	free       int
+	base       time.Duration
+	maxBackoff time.Duration
+	lockAfter  int
+	lockout    time.Duration
+	failures   map[string]*backoffEntry
+}
+
+type backoffEntry struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+func newBackoffLimiter(free int, base, maxBackoff time.Duration, lockAfter int, lockout time.Duration) *backoffLimiter {
+	return &backoffLimiter{
+		free:       free,
+		base:       base,
+		maxBackoff: maxBackoff,
+		lockAfter:  lockAfter,
+		lockout:    lockout,
+		failures:   make(map[string]*backoffEntry),
+	}
+}
+
+// Blocked - how long key remains blocked, 0 when it is not
+func (l *backoffLimiter) Blocked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(e.blockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail - count a failure for key, telling whether it locked the key
+func (l *backoffLimiter) Fail(key string) (locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	e, ok := l.failures[key]
+	if !ok || now.Sub(e.lastFailure) >= l.lockout {
+		l.sweep(now)
+		e = &backoffEntry{}
+		l.failures[key] = e
+	}
+	e.count++
+	e.lastFailure = now
+
+	switch {
+	case e.count%l.lockAfter == 0:
+		e.blockedUntil = now.Add(l.lockout)
+		return true
+	case e.count > l.free:
+		backoff := l.maxBackoff
+		if shift := e.count - l.free - 1; shift < 32 && l.base<<shift < l.maxBackoff {
+			backoff = l.base << shift
+		}
+		e.blockedUntil = now.Add(backoff)
+	}
+	return false
+}
+
+// Reset - forget the failures of key
+func (l *backoffLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// sweep - forget the keys which went a lockout without failing (caller holds the lock)
+func (l *backoffLimiter) sweep(now time.Time) {
+	for k, e := range l.failures {
+		if now.Sub(e.lastFailure) >= l.lockout && !now.Before(e.blockedUntil) {
+			delete(l.failures, k)
+		}
+	}
+}
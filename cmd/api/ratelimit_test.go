@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("first hits within the limit should be allowed")
+	}
+	if l.Allow("a") {
+		t.Error("third hit within the window should be refused")
+	}
+	if !l.Allow("b") {
+		t.Error("keys should be counted separately")
+	}
+
+	// window is over
+	l.hits["a"].start = time.Now().Add(-2 * time.Minute)
+	if !l.Allow("a") {
+		t.Error("hit after the window should be allowed")
+	}
+}
+
+func TestBackoffLimiter(t *testing.T) {
+	l := newBackoffLimiter(2, time.Second, 4*time.Second, 5, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if l.Fail("a") || l.Blocked("a") != 0 {
+			t.Fatal("free failures should not block")
+		}
+	}
+
+	// 1s, 2s, then capped to 4s
+	for _, want := range []time.Duration{time.Second, 2 * time.Second} {
+		if l.Fail("a") {
+			t.Fatal("should not be locked yet")
+		}
+		if got := l.Blocked("a"); got <= want-100*time.Millisecond || got > want {
+			t.Errorf("blocked for %s, want %s", got, want)
+		}
+	}
+	if !l.Fail("a") {
+		t.Fatal("fifth failure should lock")
+	}
+	if got := l.Blocked("a"); got < 59*time.Minute {
+		t.Errorf("locked for %s only", got)
+	}
+	if l.Blocked("b") != 0 {
+		t.Error("keys should be counted separately")
+	}
+
+	l.Reset("a")
+	if l.Blocked("a") != 0 {
+		t.Error("reset key should not be blocked")
+	}
+
+	// failures are forgotten after a lockout without failing
+	l.Fail("c")
+	l.Fail("c")
+	l.failures["c"].lastFailure = time.Now().Add(-2 * time.Hour)
+	l.Fail("c")
+	if l.failures["c"].count != 1 || l.Blocked("c") != 0 {
+		t.Error("old failures should be forgotten")
+	}
+}
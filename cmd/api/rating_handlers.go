@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+// RateBookmark - Handler to rate a bookmark, or change the rating already given (upsert)
+func (app *application) RateBookmark(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Rating int `json:"rating"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if payload.Rating < 1 || payload.Rating > 5 {
+		app.errorJSON(w, errors.New("rating must be between 1 and 5"))
+		return
+	}
+
+	rating, err := app.DB.UpsertRating(user.ID, bookmark.ID, payload.Rating)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to save rating"), http.StatusInternalServerError)
+		return
+	}
+	app.notifyOwner(r, models.NotifyBookmarkRated, bookmark, map[string]interface{}{"rating": payload.Rating})
+
+	summary, err := app.DB.GetRatingSummary(bookmark.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, struct {
+		Rating  *models.Rating        `json:"rating"`
+		Summary *models.RatingSummary `json:"summary"`
+	}{
+		Rating:  rating,
+		Summary: summary,
+	})
+}
+
+// UnrateBookmark - Handler to remove the rating the user gave to a bookmark
+func (app *application) UnrateBookmark(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	bookmark, ok := app.bookmarkFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.DeleteRating(user.ID, bookmark.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("you did not rate this bookmark"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	summary, err := app.DB.GetRatingSummary(bookmark.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, summary)
+}
+
+// GetMyRatings - Handler to list the ratings given by the authenticated user
+func (app *application) GetMyRatings(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ratings, err := app.DB.GetRatingsByUser(user.ID, opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, ratings)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationChecker - tells whether a token, identified by its jwt id, has been revoked
+type RevocationChecker interface {
+	IsRevoked(jti string) (bool, error)
+}
+
+// revocationCache - in-process cache in front of the revoked_tokens table, so that verifying a token
+// does not cost a database round-trip on every request
+// A revoked id stays revoked, so it is cached until the token would have expired anyway; a valid id is
+// only trusted for ttl, which bounds how long a token revoked by another instance keeps working here
+type revocationCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	keep    time.Duration
+	lookup  func(jti string) (bool, error)
+	entries map[string]revocationEntry
+}
+
+type revocationEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+// newRevocationCache - lookup is the source of truth, ttl the time a "not revoked" answer is trusted,
+// and keep the time a "revoked" answer is kept (the longest lifetime of a token)
+func newRevocationCache(lookup func(jti string) (bool, error), ttl, keep time.Duration) *revocationCache {
+	return &revocationCache{
+		ttl:     ttl,
+		keep:    keep,
+		lookup:  lookup,
+		entries: make(map[string]revocationEntry),
+	}
+}
+
+// IsRevoked - cached answer when fresh enough, lookup otherwise
+func (c *revocationCache) IsRevoked(jti string) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[jti]
+	c.mu.Unlock()
+	if ok && now.Before(e.until) {
+		return e.revoked, nil
+	}
+
+	revoked, err := c.lookup(jti)
+	if err != nil {
+		return false, err
+	}
+
+	until := now.Add(c.ttl)
+	if revoked {
+		until = now.Add(c.keep)
+	}
+	c.set(jti, revocationEntry{revoked: revoked, until: until})
+	return revoked, nil
+}
+
+// MarkRevoked - record revocations made by this instance, effective immediately
+func (c *revocationCache) MarkRevoked(jtis ...string) {
+	until := time.Now().Add(c.keep)
+	for _, jti := range jtis {
+		c.set(jti, revocationEntry{revoked: true, until: until})
+	}
+}
+
+func (c *revocationCache) set(jti string, e revocationEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[jti] = e
+}
+
+// sweep - forget the entries which are no longer trusted, they would be looked up again anyway
+func (c *revocationCache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range c.entries {
+		if now.After(v.until) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// sweepEvery - sweep the cache every interval, so that it only holds the tokens seen within the ttl
+// and those revoked within keep
+func (c *revocationCache) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		c.sweep(now)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRevocationCache - valid ids are only trusted for the ttl, revoked ones are kept
+func TestRevocationCache(t *testing.T) {
+	revoked := map[string]bool{}
+	lookups := 0
+	cache := newRevocationCache(func(jti string) (bool, error) {
+		lookups++
+		return revoked[jti], nil
+	}, 50*time.Millisecond, time.Hour)
+
+	ok, err := cache.IsRevoked("a")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// revoked by another instance: still trusted until the ttl elapses
+	revoked["a"] = true
+	ok, _ = cache.IsRevoked("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, lookups, "second check must be served by the cache")
+
+	time.Sleep(60 * time.Millisecond)
+	ok, _ = cache.IsRevoked("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, lookups)
+
+	// revoked by this instance: effective immediately, without lookup
+	cache.MarkRevoked("b")
+	ok, _ = cache.IsRevoked("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, lookups)
+
+	// the sweep forgets the stale entries, keeping the revocations until their tokens expire
+	cache.IsRevoked("c")
+	cache.sweep(time.Now().Add(time.Minute))
+	assert.Len(t, cache.entries, 2)
+	assert.NotContains(t, cache.entries, "c")
+	cache.sweep(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, cache.entries)
+}
+
+// TestCheckRevocation - tokens without jwt id or revoked are refused by the verify functions
+func TestCheckRevocation(t *testing.T) {
+	cache := newRevocationCache(func(jti string) (bool, error) { return false, nil }, time.Minute, time.Hour)
+	auth := Auth{Issuer: "example.com", Audience: "example.com", Keys: testKeys, Secret: "test", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, Revocations: cache}
+
+	tokens, err := auth.GenerateTokenPair(75)
+	assert.NoError(t, err)
+
+	_, claims, err := auth.GetTokenFromCookieAndVerify(tokens.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, tokens.RefreshJTI, claims.ID)
+
+	cache.MarkRevoked(tokens.RefreshJTI)
+	_, _, err = auth.GetTokenFromCookieAndVerify(tokens.RefreshToken)
+	assert.EqualError(t, err, "token has been revoked")
+
+	// the refresh endpoint still gets to see a revoked token, to detect its reuse
+	_, err = auth.ParseToken(tokens.RefreshToken)
+	assert.NoError(t, err)
+}
+
+// logoutRepo - tokens of the user are deleted, the token of the request cannot be denylisted
+type logoutRepo struct {
+	repository.DatabaseRepo
+	revokeErr error
+}
+
+func (m *logoutRepo) DeleteTokensPairOnLogOut(userID int) ([]string, error) {
+	return []string{"refresh-jti"}, nil
+}
+
+func (m *logoutRepo) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	return m.revokeErr
+}
+
+// TestLogoutEverywhere - a failure to denylist the token of the request is reported, not swallowed
+func TestLogoutEverywhere(t *testing.T) {
+	repo := &logoutRepo{}
+	app := &application{DB: repo}
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "access-jti", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}, UserID: 75}
+	logout := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/logout/all", nil)
+		r = r.WithContext(withPrincipal(r.Context(), &Principal{User: &models.User{ID: 75}, Claims: claims}))
+		rec := httptest.NewRecorder()
+		app.LogoutEverywhere(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, logout())
+
+	repo.revokeErr = errors.New("connection reset")
+	assert.Equal(t, http.StatusInternalServerError, logout())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// audit - record a privileged action of the user in context; failures are logged, the action is already done
+func (app *application) audit(r *http.Request, action, targetType string, targetID int, details map[string]interface{}) {
+	actor, ok := authUser(r)
+	if !ok || actor == nil {
+		return
+	}
+	err := app.DB.InsertAuditEntry(&models.AuditEntry{
+		ActorID:    actor.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("audit log: failed to record %s of %s %d by user %d: %v\n", action, targetType, targetID, actor.ID, err)
+	}
+}
+
+// auditModeration - record the change of a bookmark by someone else than its owner
+func (app *application) auditModeration(r *http.Request, action string, bookmark *models.Bookmark) {
+	actor, ok := authUser(r)
+	if !ok || actor == nil || actor.ID == bookmark.UserID {
+		return
+	}
+	app.audit(r, action, "bookmark", bookmark.ID, map[string]interface{}{"owner_id": bookmark.UserID})
+}
+
+// roleFromURL - user id and role of the /users/{userID}/roles/{role} url, writing the error response on failure
+func (app *application) roleFromURL(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"))
+		return 0, "", false
+	}
+	role := strings.ToLower(chi.URLParam(r, "role"))
+	if !models.ValidRole(role) {
+		app.errorJSON(w, models.ErrUnknownRole)
+		return 0, "", false
+	}
+	if role == models.RoleMember {
+		app.errorJSON(w, errors.New("every user is a member"))
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+// GrantRole - Admin handler granting a role to a user
+func (app *application) GrantRole(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := authUser(r)
+
+	target, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.DB.GrantRole(userID, role, actor.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.audit(r, models.AuditRoleGrant, "user", userID, map[string]interface{}{"role": role})
+
+	target, err = app.DB.GetUserByID(target.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, target)
+}
+
+// RevokeRole - Admin handler taking a role back from a user
+// Admins cannot revoke their own admin role, so that there is always one left
+func (app *application) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := authUser(r)
+	if userID == actor.ID && role == models.RoleAdmin {
+		app.errorJSON(w, errors.New("you cannot revoke your own admin role"), http.StatusConflict)
+		return
+	}
+
+	err := app.DB.RevokeRole(userID, role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("the user does not hold this role"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	app.audit(r, models.AuditRoleRevoke, "user", userID, map[string]interface{}{"role": role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLog - Admin handler listing the audit log, newest first
+func (app *application) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.DB.GetAuditLog(opts)
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+	_ = app.writePage(w, r, page)
+}
+
+// CreateCategory - Curator handler creating a category
+func (app *application) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Category string `json:"category"`
+	}
+	err := app.readJSON(w, r, &payload)
+	name := strings.TrimSpace(payload.Category)
+	if err != nil || name == "" {
+		app.errorJSON(w, errors.New("category is required"))
+		return
+	}
+
+	id, err := app.DB.InsertCategory(name)
+	if err != nil {
+		if errors.Is(err, models.ErrAlreadyExists) {
+			app.errorJSON(w, errors.New("this category already exists"), http.StatusConflict)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	app.audit(r, models.AuditCategoryCreate, "category", id, map[string]interface{}{"category": name})
+
+	_ = app.writeJSON(w, http.StatusCreated, JSONResponse{
+		Error:   false,
+		Message: "category created",
+		Data:    map[string]interface{}{"id": id, "category": name},
+	})
+}
+
+// CreateProject - Curator handler creating a project in a category
+func (app *application) CreateProject(w http.ResponseWriter, r *http.Request) {
+	var project models.Project
+	err := app.readJSON(w, r, &project)
+	project.Name = strings.TrimSpace(project.Name)
+	if err != nil || project.Name == "" || project.Category == "" {
+		app.errorJSON(w, errors.New("name and category are required"))
+		return
+	}
+
+	err = app.DB.InsertProject(&project)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			app.errorJSON(w, errors.New("no such category"), http.StatusNotFound)
+		case errors.Is(err, models.ErrAlreadyExists):
+			app.errorJSON(w, errors.New("this project already exists"), http.StatusConflict)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	app.audit(r, models.AuditProjectCreate, "project", project.ID, map[string]interface{}{"name": project.Name, "category": project.Category})
+
+	_ = app.writeJSON(w, http.StatusCreated, JSONResponse{
+		Error:   false,
+		Message: "project created",
+		Data:    project,
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// auditRepo - records the audit entries and role revocations
+type auditRepo struct {
+	repository.DatabaseRepo
+	entries []*models.AuditEntry
+	revoked []string
+}
+
+func (m *auditRepo) InsertAuditEntry(e *models.AuditEntry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *auditRepo) RevokeRole(userID int, role string) error {
+	m.revoked = append(m.revoked, role)
+	return nil
+}
+
+func withUser(r *http.Request, user *models.User) *http.Request {
+	return r.WithContext(withPrincipal(r.Context(), &Principal{User: user}))
+}
+
+// TestRequirePermission - roles grant their permissions, and nothing else
+func TestRequirePermission(t *testing.T) {
+	app := &application{}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		user       *models.User
+		permission string
+		status     int
+	}{
+		{"anonymous", nil, models.PermEditAnyBookmark, http.StatusUnauthorized},
+		{"member", &models.User{ID: 1}, models.PermEditAnyBookmark, http.StatusForbidden},
+		{"moderator", &models.User{ID: 1, Roles: []string{models.RoleModerator}}, models.PermEditAnyBookmark, http.StatusOK},
+		{"moderator is no curator", &models.User{ID: 1, Roles: []string{models.RoleModerator}}, models.PermManageCatalog, http.StatusForbidden},
+		{"curator", &models.User{ID: 1, Roles: []string{models.RoleCurator}}, models.PermManageCatalog, http.StatusOK},
+		{"several roles", &models.User{ID: 1, Roles: []string{models.RoleCurator, models.RoleModerator}}, models.PermEditAnyBookmark, http.StatusOK},
+		{"admin role", &models.User{ID: 1, Roles: []string{models.RoleAdmin}}, models.PermManageRoles, http.StatusOK},
+		{"legacy admin flag", &models.User{ID: 1, IsAdmin: true}, models.PermManageUsers, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != nil {
+				req = withUser(req, tt.user)
+			}
+			rec := httptest.NewRecorder()
+			app.requirePermission(tt.permission)(ok).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+// TestRevokeRole - revocations are audited, admins cannot demote themselves
+func TestRevokeRole(t *testing.T) {
+	repo := &auditRepo{}
+	app := &application{DB: repo}
+	admin := &models.User{ID: 1, Roles: []string{models.RoleAdmin}}
+
+	mux := chi.NewRouter()
+	mux.Delete("/users/{userID}/roles/{role}", app.RevokeRole)
+	revoke := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, withUser(httptest.NewRequest(http.MethodDelete, path, nil), admin))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, revoke("/users/2/roles/moderator"))
+	assert.Equal(t, http.StatusConflict, revoke("/users/1/roles/admin"))
+	assert.Equal(t, http.StatusBadRequest, revoke("/users/2/roles/wizard"))
+	assert.Equal(t, http.StatusBadRequest, revoke("/users/2/roles/member"))
+
+	assert.Equal(t, []string{models.RoleModerator}, repo.revoked)
+	if assert.Len(t, repo.entries, 1) {
+		e := repo.entries[0]
+		assert.Equal(t, models.AuditRoleRevoke, e.Action)
+		assert.Equal(t, 1, e.ActorID)
+		assert.Equal(t, 2, e.TargetID)
+		assert.Equal(t, models.RoleModerator, e.Details["role"])
+	}
+}
+
+// TestCanEditBookmark - owners and moderators only; moderation of the bookmarks of others is audited
+func TestCanEditBookmark(t *testing.T) {
+	bookmark := &models.Bookmark{ID: 9, UserID: 2}
+	assert.True(t, canEditBookmark(&models.User{ID: 2}, bookmark))
+	assert.False(t, canEditBookmark(&models.User{ID: 3}, bookmark))
+	assert.False(t, canEditBookmark(&models.User{ID: 3, Roles: []string{models.RoleCurator}}, bookmark))
+	assert.True(t, canEditBookmark(&models.User{ID: 3, Roles: []string{models.RoleModerator}}, bookmark))
+
+	repo := &auditRepo{}
+	app := &application{DB: repo}
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	app.auditModeration(withUser(req, &models.User{ID: 2}), models.AuditBookmarkDelete, bookmark)
+	app.auditModeration(withUser(req, &models.User{ID: 3}), models.AuditBookmarkDelete, bookmark)
+	if assert.Len(t, repo.entries, 1, "owners editing their own bookmarks are not audited") {
+		assert.Equal(t, 3, repo.entries[0].ActorID)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bookmarks/internal/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -18,35 +19,92 @@ func (app *application) routes() http.Handler {
 	mux.Get("/", app.checkHealth)
 
 	// Public routes
-	mux.Handle("/", app.verifyToken(http.HandlerFunc(app.Home)))
+	mux.Handle("/", app.optionalAuth(http.HandlerFunc(app.Home)))
 	mux.Get("/bookmarks/{category}", app.GetProjectsByCategory)
 	mux.Get("/bookmarks/{category}/{project}", app.GetResourcesForProject)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+	mux.Get("/auth/providers", app.ListProviders)
+	mux.Post("/auth/exchange", app.ExchangeAuthCode)
 	mux.Get("/auth/{provider}", app.HandleAuth)
 	mux.Get("/auth/{provider}/callback", app.HandleCallback)
 	mux.Post("/register", app.RegisterNewUser)
 	mux.Post("/login", app.ClassicLogin)
+	mux.Post("/login/2fa", app.LoginTwoFactor)
+	mux.Post("/login/magic", app.RequestMagicLink)
+	mux.Post("/login/magic/verify", app.VerifyMagicLink)
+	mux.Post("/refresh", app.RefreshToken)
 	mux.Get("/confirm-email", app.ConfirmEmail)
+	mux.Post("/confirm-email/resend", app.ResendConfirmationEmail)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Post("/password/reset", app.ResetPassword)
 	mux.Get("/contributors", app.GetContributors)
+	mux.Get("/search", app.SearchBookmarks)
+	mux.Get("/digest/unsubscribe", app.ConfirmUnsubscribeDigest)
+	mux.Post("/digest/unsubscribe", app.UnsubscribeDigest)
 
 	// USer information - Feed Dashboard && related screen with user data - Hybrid by now
-	mux.Get("/user-info", app.GetUserInfo)
-	mux.Handle("/logout", app.verifyToken(http.HandlerFunc(app.Logout)))
+	mux.With(app.requireAuth, app.sessionOnly).Get("/user-info", app.GetUserInfo)
+	mux.Handle("/logout", app.requireAuth(http.HandlerFunc(app.Logout)))
+	mux.With(app.requireAuth, app.sessionOnly).Post("/logout/all", app.LogoutEverywhere)
 
-	mux.Post("/contributors/insert-bookmark", app.InsertNewBookmark)
+	mux.With(app.requireAuth).Post("/contributors/insert-bookmark", app.InsertNewBookmark)
+	mux.With(app.requireAuth).Get("/feed", app.GetFeed)
+
+	// Notifications - kept until read, and pushed live over server-sent events
+	mux.With(app.requireAuth).Get("/events", app.Events)
+	mux.With(app.requireAuth).Get("/notifications", app.GetNotifications)
+	mux.With(app.requireAuth).Patch("/notifications", app.MarkNotifications)
 
 	// Posting new resources
 	// mux.Get("/contributors/categories", app.GetCategories)
 	mux.Get("/contributors/{category}", app.GetProjectsByCategory)
 	// mux.Post("/contributors/bookmarks", app.PostNewBookmarkByCategory)
-	mux.Get("/dashboard/{userID}", app.GetDashboardStats)
 
 	fileServer := http.FileServer(http.Dir("./uploads"))
 	mux.Handle("/uploads/*", http.StripPrefix("/uploads", fileServer))
 
 	// protected route section - now we are not kidding anymore
 	mux.Route("/dashboard", func(mux chi.Router) {
-		mux.Use(app.authRequired)
+		mux.Use(app.requireAuth)
 		mux.Post("/upload-avatar", app.UploadAvatar)
+		mux.Get("/my-ratings", app.GetMyRatings)
+		mux.Get("/{userID}", app.GetDashboardStats)
+	})
+
+	// Single bookmark - public read, edition restricted to the owner of the bookmark or an admin
+	mux.Route("/bookmarks/id/{id}", func(mux chi.Router) {
+		mux.Get("/", app.GetBookmark)
+		mux.With(app.requireAuth).Put("/", app.UpdateBookmark)
+		mux.With(app.requireAuth).Patch("/", app.PatchBookmark)
+		mux.With(app.requireAuth).Delete("/", app.DeleteBookmark)
+
+		// Ratings - one per user and bookmark, PUT again to change it
+		mux.With(app.requireAuth).Put("/rating", app.RateBookmark)
+		mux.With(app.requireAuth).Delete("/rating", app.UnrateBookmark)
+
+		// Comments - public read, streamed in the feeds of the followers of their authors
+		mux.Get("/comments", app.GetComments)
+		mux.With(app.requireAuth).Post("/comments", app.CommentBookmark)
+	})
+
+	// Account - sessions on the user's devices
+	mux.Route("/account", func(mux chi.Router) {
+		mux.Use(app.requireAuth, app.sessionOnly)
+		mux.Get("/sessions", app.ListSessions)
+		mux.Delete("/sessions/{id}", app.RevokeSession)
+		mux.Get("/identities", app.ListIdentities)
+		mux.Post("/identities/{provider}", app.LinkIdentity)
+		mux.Delete("/identities/{id}", app.UnlinkIdentity)
+		mux.Post("/2fa/enroll", app.EnrollTwoFactor)
+		mux.Post("/2fa/confirm", app.ConfirmTwoFactor)
+		mux.Get("/api-tokens", app.ListAPITokens)
+		mux.Post("/api-tokens", app.CreateAPIToken)
+		mux.Delete("/api-tokens/{id}", app.RevokeAPIToken)
+		mux.Patch("/preferences", app.UpdatePreferences)
+		mux.Get("/follows", app.ListFollows)
+		mux.Put("/follows/{type}/{id}", app.Follow)
+		mux.Delete("/follows/{type}/{id}", app.Unfollow)
+		mux.Get("/digest/preview", app.PreviewDigest)
 	})
 
 	mux.Route("/admin", func(mux chi.Router) {
@@ -54,6 +112,19 @@ func (app *application) routes() http.Handler {
 		mux.Get("/dashboard-panel", app.AdminDashboard)
 		mux.Get("/list-users", app.ListUsers)
 		mux.Get("/list-users/{userID}/bookmarks", app.ListBookmarksByUser)
+		mux.Delete("/users/{userID}/2fa", app.ResetUserTwoFactor)
+		mux.Delete("/users/{userID}/lockout", app.UnlockUser)
+		mux.Get("/outbox/dead", app.ListDeadEmails)
+		mux.Get("/audit-log", app.GetAuditLog)
+		mux.With(app.requirePermission(models.PermManageRoles)).Put("/users/{userID}/roles/{role}", app.GrantRole)
+		mux.With(app.requirePermission(models.PermManageRoles)).Delete("/users/{userID}/roles/{role}", app.RevokeRole)
+	})
+
+	// Catalog - projects and categories, managed by the curators
+	mux.Route("/catalog", func(mux chi.Router) {
+		mux.Use(app.requireAuth, app.requirePermission(models.PermManageCatalog))
+		mux.Post("/categories", app.CreateCategory)
+		mux.Post("/projects", app.CreateProject)
 	})
 	return mux
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// SearchBookmarks - Handler for the full-text search over bookmarks
+// GET /search?q=&category=&project=&type=&sort=relevance|newest&limit=&offset=&cursor=
+func (app *application) SearchBookmarks(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		app.errorJSON(w, errors.New("query parameter 'q' is required"))
+		return
+	}
+
+	opts, err := app.readListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	results, err := app.Search.SearchBookmarks(models.SearchQuery{
+		Query:    q,
+		Category: r.URL.Query().Get("category"),
+		Project:  r.URL.Query().Get("project"),
+		Type:     opts.Type,
+		Sort:     opts.Sort,
+		Cursor:   opts.Cursor,
+		Limit:    opts.Limit,
+		Offset:   opts.Offset,
+	})
+	if err != nil {
+		app.listingError(w, err)
+		return
+	}
+
+	results.Next = nextPageLink(r, results.NextCursor)
+	_ = app.writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository/dbrepo"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSearchBookmarksHandler - the search pages through its hits with the next link, and refuses bad parameters
+func TestSearchBookmarksHandler(t *testing.T) {
+	idx := &dbrepo.MemorySearchRepo{}
+	idx.Index(models.Bookmark{ID: 1, Url: "https://beej.us/guide/bgnet", Type: "article", Description: "Beej guide to network programming", ProjectName: "sockets"}, "system-linux")
+	idx.Index(models.Bookmark{ID: 2, Url: "https://example.com/sockets", Type: "video", Description: "programming sockets in C", ProjectName: "sockets"}, "system-linux")
+	idx.Index(models.Bookmark{ID: 3, Url: "https://example.com/huffman", Type: "article", Description: "Huffman coding, the programming way", ProjectName: "huffman"}, "system-algorithms")
+	app := &application{Search: idx}
+
+	search := func(target string) (*httptest.ResponseRecorder, models.SearchResults) {
+		rec := httptest.NewRecorder()
+		app.SearchBookmarks(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var res models.SearchResults
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res
+	}
+
+	rec, page := search("/search?q=programming&limit=2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 3, page.Total)
+	assert.Len(t, page.Hits, 2)
+	assert.Len(t, page.Facets.Categories, 2)
+	require.NotEmpty(t, page.Next)
+
+	rec, next := search(page.Next)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	if assert.Len(t, next.Hits, 1) {
+		assert.Equal(t, 1, next.Hits[0].ID)
+	}
+	assert.Empty(t, next.Next)
+
+	rec, _ = search("/search?q=programming&category=system-algorithms")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	for _, target := range []string{"/search", "/search?q=programming&sort=most_rated", "/search?q=programming&cursor=garbage"} {
+		rec, _ = search(target)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
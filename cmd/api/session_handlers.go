@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListSessions - Handler listing the active sessions (devices) of the current user
+func (app *application) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := app.DB.GetSessionsByUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// flag the session the request is made from
+	if current := app.currentSessionID(r); current != "" {
+		for _, s := range sessions {
+			s.Current = s.ID == current
+		}
+	}
+	_ = app.writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession - Handler revoking one session of the current user (logging out that device)
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	session, err := app.DB.GetSessionByID(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such session"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	// someone else's session is reported as missing, not to disclose its existence
+	if session.UserID != user.ID {
+		app.errorJSON(w, errors.New("no such session"), http.StatusNotFound)
+		return
+	}
+
+	jtis, err := app.DB.RevokeTokenFamily(session.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.markRevoked(jtis...)
+
+	if session.ID == app.currentSessionID(r) {
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentSessionID - session of the token the request was authenticated with, if tracked
+func (app *application) currentSessionID(r *http.Request) string {
+	claims, ok := authClaims(r)
+	if !ok || claims == nil {
+		return ""
+	}
+	row, err := app.DB.GetTokenByJTI(claims.ID)
+	if err != nil {
+		return ""
+	}
+	return row.FamilyID
+}
+
+// sweepExpiredSessions - background job purging expired sessions and tokens, every interval
+func (app *application) sweepExpiredSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.DB.PurgeExpiredSessions()
+		if err != nil {
+			log.Println("session sweeper:", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("session sweeper: purged %d expired rows\n", n)
+		}
+	}
+}
+
+// clientIP - address of the client, without port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
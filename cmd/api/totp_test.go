@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTOTPCode - test vectors of RFC 6238 (SHA1), truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(secret, totpStep(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.code, code, "at %d", tt.unix)
+	}
+}
+
+// TestTOTPMatch - codes of the neighbour steps are accepted, older ones are not
+func TestTOTPMatch(t *testing.T) {
+	secret, err := newTOTPSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, _ := totpCode(secret, totpStep(now)-1)
+	step, ok := totpMatch(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now)-1, step)
+
+	code, _ = totpCode(secret, totpStep(now)-3)
+	_, ok = totpMatch(secret, code, now)
+	assert.False(t, ok)
+
+	_, ok = totpMatch(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("Bookmarks", "homer@example.com", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Bookmarks:homer@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=Bookmarks")
+}
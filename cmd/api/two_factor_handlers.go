@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// totpIssuer - name of the application in the authenticator apps
+const totpIssuer = "Bookmarks"
+
+// loginChallengeValidity - time given to enter the second factor once the password is checked
+const loginChallengeValidity = 5 * time.Minute
+
+// recoveryCodeCount - number of recovery codes handed out when enabling two-factor authentication
+const recoveryCodeCount = 10
+
+// normalizeRecoveryCode - recovery codes are shown as xxxxx-xxxxx, typed in any case, with or without the dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// EnrollTwoFactor - Handler starting the two-factor enrolment of the current user
+// Returns the secret and its otpauth uri (for a QR code); nothing is enforced until ConfirmTwoFactor
+func (app *application) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		if errors.Is(err, models.ErrTwoFactorEnabled) {
+			app.errorJSON(w, err, http.StatusConflict)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, JSONResponse{
+		Error:   false,
+		Message: "scan the code with your authenticator app, then confirm with a first code",
+		Data: map[string]string{
+			"secret":      secret,
+			"otpauth_uri": totpURI(totpIssuer, user.Email, secret),
+		},
+	})
+}
+
+// ConfirmTwoFactor - Handler enabling two-factor authentication with a first code of the enrolled secret
+// Returns the recovery codes, which are only ever shown here
+func (app *application) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := authUser(r)
+	if !ok || user == nil {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if tf.Enabled {
+		app.errorJSON(w, models.ErrTwoFactorEnabled, http.StatusConflict)
+		return
+	}
+	if tf.Secret == "" {
+		app.errorJSON(w, errors.New("no enrolment in progress"))
+		return
+	}
+
+	step, ok := totpMatch(tf.Secret, payload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, errors.New("invalid code"))
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := strings.ToLower(generateRandomString(10))
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	err = app.DB.EnableTwoFactor(user.ID, step, hashes)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication enabled, keep these recovery codes somewhere safe",
+		Data: map[string][]string{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// LoginTwoFactor - Handler completing a login with the challenge returned by ClassicLogin and a code
+// The code is either a TOTP code or one of the recovery codes
+func (app *application) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Challenge    string `json:"challenge"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.auth.ParsePurposeToken(purposeLoginChallenge, payload.Challenge)
+	if err != nil {
+		app.errorCodeJSON(w, errCodeTokenExpired, errors.New("login expired, please log in again"), http.StatusUnauthorized)
+		return
+	}
+	// a 6 digits code does not resist guessing for long
+	if !app.twoFactorLimiter.Allow(strconv.Itoa(userID)) {
+		app.errorCodeJSON(w, errCodeTooManyRequests, errors.New("too many attempts, please try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	tf, err := app.DB.GetTwoFactor(userID)
+	if err != nil || !tf.Enabled {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case payload.Code != "":
+		step, ok := totpMatch(tf.Secret, payload.Code, time.Now())
+		if ok {
+			err = app.DB.UseTOTPStep(userID, step)
+		} else {
+			err = sql.ErrNoRows
+		}
+	case payload.RecoveryCode != "":
+		err = app.DB.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(payload.RecoveryCode)))
+	default:
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.loginResponse(w, r, user)
+}
+
+// ResetUserTwoFactor - Admin handler turning off the two-factor authentication of a user who lost access to it
+func (app *application) ResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.DB.ResetTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("no such user"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	app.audit(r, models.AuditTwoFactorReset, "user", userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
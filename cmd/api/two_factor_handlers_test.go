@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bookmarks/internal/models"
+	"bookmarks/internal/repository"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// twoFactorRepo - a single user with two-factor authentication on
+type twoFactorRepo struct {
+	repository.DatabaseRepo
+	user     models.User
+	tf       models.TwoFactor
+	recovery map[string]bool // code hash -> used
+}
+
+func (m *twoFactorRepo) GetUserByEmail(email string) (models.User, error) {
+	if email != m.user.Email {
+		return models.User{}, sql.ErrNoRows
+	}
+	return m.user, nil
+}
+
+func (m *twoFactorRepo) GetUserByID(userID int) (*models.User, error) {
+	u := m.user
+	return &u, nil
+}
+
+func (m *twoFactorRepo) GetTwoFactor(userID int) (*models.TwoFactor, error) {
+	tf := m.tf
+	return &tf, nil
+}
+
+func (m *twoFactorRepo) UseTOTPStep(userID int, step int64) error {
+	if step <= m.tf.LastStep {
+		return sql.ErrNoRows
+	}
+	m.tf.LastStep = step
+	return nil
+}
+
+func (m *twoFactorRepo) UseRecoveryCode(userID int, codeHash string) error {
+	used, ok := m.recovery[codeHash]
+	if !ok || used {
+		return sql.ErrNoRows
+	}
+	m.recovery[codeHash] = true
+	return nil
+}
+
+func (m *twoFactorRepo) CreateSession(s *models.Session) error { return nil }
+func (m *twoFactorRepo) StoreTokenPairs(t *models.Token) error { return nil }
+
+// TestLoginTwoFactor - the password only yields a challenge, completed once by a code or a recovery code
+func TestLoginTwoFactor(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("d'oh-d'oh"), bcrypt.MinCost)
+	require.NoError(t, err)
+	secret, err := newTOTPSecret()
+	require.NoError(t, err)
+
+	repo := &twoFactorRepo{
+		user:     models.User{ID: 4, Email: "homer@example.com", Password: string(hash), Verified: true, TwoFactorEnabled: true},
+		tf:       models.TwoFactor{UserID: 4, Secret: secret, Enabled: true},
+		recovery: map[string]bool{hashToken("abcde12345"): false},
+	}
+	app := &application{
+		DB:               repo,
+		auth:             Auth{Issuer: "test", Audience: "test", Keys: testKeys, Secret: "test-secret", TokenExpiry: time.Minute, RefreshExpiry: time.Hour, CookieName: "refresh_token"},
+		twoFactorLimiter: newRateLimiter(5, time.Minute),
+	}
+	app.loginAccountLimiter, app.loginClientLimiter = newLoginLimiters()
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post(app.ClassicLogin, `{"email":"homer@example.com","password":"d'oh-d'oh"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var challenge struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Challenge         string `json:"challenge"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &challenge))
+	assert.True(t, challenge.TwoFactorRequired)
+	assert.Empty(t, rec.Result().Cookies(), "no session before the second factor")
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	require.NoError(t, err)
+
+	rec = post(app.LoginTwoFactor, `{"challenge":"`+challenge.Challenge+`","code":"000000x"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = post(app.LoginTwoFactor, `{"challenge":"`+challenge.Challenge+`","code":"`+code+`"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, rec.Result().Cookies(), 1)
+
+	rec = post(app.LoginTwoFactor, `{"challenge":"`+challenge.Challenge+`","code":"`+code+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "a code cannot be replayed")
+
+	rec = post(app.LoginTwoFactor, `{"challenge":"`+challenge.Challenge+`","recovery_code":"ABCDE-12345"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = post(app.LoginTwoFactor, `{"challenge":"`+challenge.Challenge+`","recovery_code":"abcde12345"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "a recovery code is single use")
+
+	rec = post(app.LoginTwoFactor, `{"challenge":"forged","code":"`+code+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
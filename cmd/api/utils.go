@@ -1,22 +1,37 @@
 package main
 
 import (
+	"bookmarks/internal/models"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math/big"
 	"net/http"
 	"regexp"
+	"strconv"
+	"time"
 )
 
 // JSONResponse - structure to pack the json response data
 type JSONResponse struct {
 	Error   bool        `json:"error"`
+	Code    string      `json:"code,omitempty"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Machine-readable error codes, for the frontend to act upon
+const (
+	errCodeEmailNotVerified = "email_not_verified"
+	errCodeTokenExpired     = "token_expired"
+	errCodeTokenInvalid     = "token_invalid"
+	errCodeTooManyRequests  = "too_many_requests"
+)
+
 // Character set from which to generate the random string (email validation)
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
@@ -77,6 +92,15 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 	return app.writeJSON(w, statusCode, payload)
 }
 
+// errorCodeJSON - same as errorJSON, along with a machine-readable error code
+func (app *application) errorCodeJSON(w http.ResponseWriter, code string, err error, status int) error {
+	return app.writeJSON(w, status, JSONResponse{
+		Error:   true,
+		Code:    code,
+		Message: err.Error(),
+	})
+}
+
 // generateRandomString - generate a random string for new user wishing to register
 func generateRandomString(length int) string {
 	randomString := make([]byte, length)
@@ -92,8 +116,89 @@ func generateRandomString(length int) string {
 	return string(randomString)
 }
 
+// hashToken - sha256 of a secret token, the only form under which such tokens are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // isValidURL - validates an Url against regular expression
 func isValidURL(url string) bool {
 	re := regexp.MustCompile(`^(https?://)?((([a-z\d]([a-z\d-]*[a-z\d])*)\.?)+[a-z]{2,}|(\d{1,3}\.){3}\d{1,3})(:\d+)?(/[-a-z\d%_.~+]*)*(\?[;&a-z\d%_.~+=-]*)?(#[-a-z\d_]*)?$`)
 	return re.MatchString(url)
 }
+
+// readListOptions - read the pagination, filtering and sorting query parameters shared by the listings
+// ?limit=&offset=&cursor=&sort=newest|most_rated|score&type=&submitter=&from=&to=
+// from/to accept either a date (2024-06-30, 'to' being inclusive) or a RFC3339 timestamp
+func (app *application) readListOptions(r *http.Request) (models.ListOptions, error) {
+	q := r.URL.Query()
+	opts := models.ListOptions{
+		Cursor: q.Get("cursor"),
+		Sort:   q.Get("sort"),
+		Type:   q.Get("type"),
+	}
+
+	var err error
+	if v := q.Get("limit"); v != "" {
+		opts.Limit, err = strconv.Atoi(v)
+		if err != nil || opts.Limit < 1 {
+			return opts, errors.New("limit must be a positive integer")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		opts.Offset, err = strconv.Atoi(v)
+		if err != nil || opts.Offset < 0 {
+			return opts, errors.New("offset must be a positive integer")
+		}
+	}
+	if v := q.Get("submitter"); v != "" {
+		opts.SubmitterID, err = strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("submitter must be a user id")
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		opts.From, _, err = parseDateParam(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'from' date: %s", v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var dateOnly bool
+		opts.To, dateOnly, err = parseDateParam(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'to' date: %s", v)
+		}
+		if dateOnly {
+			opts.To = opts.To.AddDate(0, 0, 1)
+		}
+	}
+	return opts, nil
+}
+
+// parseDateParam - parse a date or a RFC3339 timestamp, telling which one it was
+func parseDateParam(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// writePage - write a page of results, adding the link to the next page when there is one
+func (app *application) writePage(w http.ResponseWriter, r *http.Request, page *models.Page) error {
+	page.Next = nextPageLink(r, page.NextCursor)
+	return app.writeJSON(w, http.StatusOK, page)
+}
+
+// nextPageLink - the request repeated from cursor (the offset being dropped), or nothing when there is no next page
+func nextPageLink(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Del("offset")
+	q.Set("cursor", cursor)
+	return r.URL.Path + "?" + q.Encode()
+}
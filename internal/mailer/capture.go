@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureMailer - keeps the emails instead of delivering them, for development and tests
+// With a Dir, each email is also written there as an html file, to be opened in a browser, along with its text part
+type CaptureMailer struct {
+	Dir string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send - capture msg
+func (m *CaptureMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	n := len(m.messages)
+	m.mu.Unlock()
+
+	if m.Dir == "" {
+		return nil
+	}
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(m.Dir, fmt.Sprintf("%s-%03d-%s", time.Now().Format("20060102-150405"), n, safeFileName(msg.To)))
+	page := fmt.Sprintf("<!-- To: %s -->\n<!-- Subject: %s -->\n", msg.To, msg.Subject)
+	if msg.UnsubscribeURL != "" {
+		page += fmt.Sprintf("<!-- List-Unsubscribe: <%s> -->\n", msg.UnsubscribeURL)
+	}
+	page += msg.HTML + "\n"
+	err = os.WriteFile(name+".html", []byte(page), 0o644)
+	if err != nil || msg.Text == "" {
+		return err
+	}
+	return os.WriteFile(name+".txt", []byte(fmt.Sprintf("To: %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Text)), 0o644)
+}
+
+// Messages - the emails captured so far, oldest first
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// safeFileName - s with anything but letters, digits, dots, dashes and @ replaced
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
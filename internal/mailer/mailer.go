@@ -0,0 +1,19 @@
+// Package mailer - delivery of the emails, through an smtp server or captured locally
+package mailer
+
+import "context"
+
+// Message - an email ready to be delivered, in html with a plain text alternative
+// An email sent to a list carries the UnsubscribeURL taking the recipient off it with a single POST (RFC 8058)
+type Message struct {
+	To             string
+	Subject        string
+	HTML           string
+	Text           string
+	UnsubscribeURL string
+}
+
+// Mailer - delivers emails; an error means the message may be tried again later
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
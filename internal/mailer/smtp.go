@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// SMTPMailer - delivers the emails through an smtp server (STARTTLS), one connection per email
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send - open a connection to the smtp server and send msg, as a multipart email when it has a text part
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	email := mail.NewMSG()
+	email.SetFrom(m.From).
+		AddTo(msg.To).
+		SetSubject(msg.Subject)
+	if msg.Text != "" {
+		email.SetBody(mail.TextPlain, msg.Text).AddAlternative(mail.TextHTML, msg.HTML)
+	} else {
+		email.SetBody(mail.TextHTML, msg.HTML)
+	}
+	if msg.UnsubscribeURL != "" {
+		email.AddHeader("List-Unsubscribe", "<"+msg.UnsubscribeURL+">")
+		email.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	if email.Error != nil {
+		return email.Error
+	}
+
+	server := mail.NewSMTPClient()
+
+	server.Host = m.Host
+	server.Username = m.Username
+	server.Password = m.Password
+	server.Port = m.Port
+
+	server.KeepAlive = false
+	server.Encryption = mail.EncryptionSTARTTLS
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		server.ConnectTimeout = time.Until(deadline)
+		server.SendTimeout = time.Until(deadline)
+	}
+
+	// connected last, once the email is built; Send closes the client (no keep alive) but not on every error
+	smtpClient, err := server.Connect()
+	if err != nil {
+		return err
+	}
+	defer smtpClient.Close()
+	return email.Send(smtpClient)
+}
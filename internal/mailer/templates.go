@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Languages - the languages the emails are written in, the first one being the fallback
+var Languages = []string{"en", "fr"}
+
+// Emails - the templated emails; each one is a pair of templates per language, <lang>/<name>.html and
+// <lang>/<name>.txt, the text one also defining the "subject" template
+var Emails = []string{"confirmation", "password_reset", "magic_link", "lockout", "digest"}
+
+// Templates - the emails, ready to be rendered in any of the Languages
+type Templates struct {
+	layout *htmltemplate.Template
+	html   map[string]*htmltemplate.Template
+	text   map[string]*texttemplate.Template
+}
+
+// LoadTemplates - parse every email template, failing on the first missing or broken one
+func LoadTemplates() (*Templates, error) {
+	layout, err := htmltemplate.ParseFS(templateFS, "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+	t := &Templates{
+		layout: layout,
+		html:   make(map[string]*htmltemplate.Template),
+		text:   make(map[string]*texttemplate.Template),
+	}
+
+	for _, lang := range Languages {
+		for _, name := range Emails {
+			key := path.Join(lang, name)
+			html, err := htmltemplate.ParseFS(templateFS, "templates/"+key+".html")
+			if err != nil {
+				return nil, err
+			}
+			text, err := texttemplate.ParseFS(templateFS, "templates/"+key+".txt")
+			if err != nil {
+				return nil, err
+			}
+			if text.Lookup("subject") == nil {
+				return nil, fmt.Errorf("templates/%s.txt: no subject defined", key)
+			}
+			t.html[key], t.text[key] = html, text
+		}
+	}
+	return t, nil
+}
+
+// Render - the email name in lang (the fallback language when unsupported), for data; To is left for the caller
+func (t *Templates) Render(lang, name string, data interface{}) (Message, error) {
+	key := path.Join(SupportedLanguage(lang), name)
+	html, ok := t.html[key]
+	if !ok {
+		return Message{}, fmt.Errorf("no email template %q", name)
+	}
+	text := t.text[key]
+
+	var subject, textBody, content, page bytes.Buffer
+	err := text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Message{}, err
+	}
+	err = text.Execute(&textBody, data)
+	if err != nil {
+		return Message{}, err
+	}
+	err = html.Execute(&content, data)
+	if err != nil {
+		return Message{}, err
+	}
+	err = t.layout.Execute(&page, struct {
+		Lang    string
+		Subject string
+		Content htmltemplate.HTML
+	}{
+		Lang:    SupportedLanguage(lang),
+		Subject: subject.String(),
+		Content: htmltemplate.HTML(content.String()),
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    page.String(),
+		Text:    strings.TrimSpace(textBody.String()) + "\n",
+	}, nil
+}
+
+// SupportedLanguage - lang when the emails are written in it, the fallback language otherwise
+func SupportedLanguage(lang string) string {
+	for _, l := range Languages {
+		if l == lang {
+			return l
+		}
+	}
+	return Languages[0]
+}
+
+// AcceptLanguage - the first supported language of an Accept-Language header, the fallback language when none
+func AcceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		primary := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		for _, l := range Languages {
+			if l == primary {
+				return l
+			}
+		}
+	}
+	return Languages[0]
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleData - data of every email, as the handlers give it
+var sampleData = map[string]interface{}{
+	"Link":            "https://example.com/link?token=abc",
+	"ResetLink":       "https://example.com/forgot-password",
+	"Hours":           24,
+	"Minutes":         15,
+	"UserName":        "ned",
+	"SettingsLink":    "https://example.com/settings",
+	"UnsubscribeLink": "https://example.com/unsubscribe?token=abc",
+	"Projects": []map[string]interface{}{{
+		"Name":      "Go",
+		"Category":  "Languages",
+		"Bookmarks": []map[string]interface{}{{"Title": "Effective <Go>", "URL": "https://go.dev/doc/effective_go"}},
+	}},
+}
+
+// TestRenderEveryEmail - every email renders in every language, with a subject, a text part and its links
+func TestRenderEveryEmail(t *testing.T) {
+	templates, err := LoadTemplates()
+	require.NoError(t, err)
+
+	for _, lang := range Languages {
+		for _, name := range Emails {
+			msg, err := templates.Render(lang, name, sampleData)
+			require.NoError(t, err, "%s/%s", lang, name)
+
+			assert.NotEmpty(t, msg.Subject, "%s/%s", lang, name)
+			assert.NotContains(t, msg.Subject, "\n")
+			assert.Contains(t, msg.HTML, `<html lang="`+lang+`">`)
+			for _, part := range []string{msg.HTML, msg.Text} {
+				assert.NotContains(t, part, "<no value>", "%s/%s", lang, name)
+				assert.True(t, strings.Contains(part, "https://example.com/"), "%s/%s has no link", lang, name)
+			}
+		}
+	}
+}
+
+func TestRenderLanguages(t *testing.T) {
+	templates, err := LoadTemplates()
+	require.NoError(t, err)
+
+	en, err := templates.Render("en", "confirmation", sampleData)
+	require.NoError(t, err)
+	fr, err := templates.Render("fr", "confirmation", sampleData)
+	require.NoError(t, err)
+	de, err := templates.Render("de", "confirmation", sampleData)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Confirm your email address", en.Subject)
+	assert.Equal(t, "Confirmez votre adresse email", fr.Subject)
+	assert.Equal(t, en, de, "unsupported languages fall back to english")
+
+	digest, err := templates.Render("en", "digest", sampleData)
+	require.NoError(t, err)
+	assert.Contains(t, digest.HTML, "Effective &lt;Go&gt;", "the html part escapes the data")
+	assert.Contains(t, digest.Text, "Effective <Go>")
+
+	_, err = templates.Render("en", "unknown", sampleData)
+	assert.Error(t, err)
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	assert.Equal(t, "fr", AcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8"))
+	assert.Equal(t, "en", AcceptLanguage("de-DE,en-US;q=0.7"))
+	assert.Equal(t, "en", AcceptLanguage(""))
+	assert.Equal(t, "fr", AcceptLanguage("FR"))
+}
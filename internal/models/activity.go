@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Kinds of activity streamed in the feeds
+const (
+	ActivityBookmarkCreate  = "bookmark.create"
+	ActivityBookmarkUpdate  = "bookmark.update"
+	ActivityBookmarkRate    = "bookmark.rate"
+	ActivityBookmarkComment = "bookmark.comment"
+)
+
+// Activity - something a user did on a bookmark, as seen in the feed of their followers
+// The bookmark is described as it is now, not as it was when the activity happened
+type Activity struct {
+	ID          int                    `json:"id"`
+	Kind        string                 `json:"kind"`
+	ActorID     int                    `json:"actor_id"`
+	ActorName   string                 `json:"actor_name"`
+	BookmarkID  int                    `json:"bookmark_id"`
+	BookmarkURL string                 `json:"bookmark_url"`
+	Description string                 `json:"description"`
+	ProjectID   int                    `json:"project_id"`
+	ProjectName string                 `json:"project_name"`
+	Category    string                 `json:"category"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
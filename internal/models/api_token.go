@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Scopes a personal access token can be granted
+const (
+	ScopeBookmarksRead  = "bookmarks:read"
+	ScopeBookmarksWrite = "bookmarks:write"
+	ScopeAdmin          = "admin"
+)
+
+// Scopes - every known scope
+var Scopes = []string{ScopeBookmarksRead, ScopeBookmarksWrite, ScopeAdmin}
+
+// APIToken - a personal access token; the token itself is only known at its creation, Prefix tells them apart
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope - whether the token was granted scope; write access implies read access, admin implies everything
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin || (s == ScopeBookmarksWrite && scope == ScopeBookmarksRead) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Audited actions
+const (
+	AuditRoleGrant      = "role.grant"
+	AuditRoleRevoke     = "role.revoke"
+	AuditBookmarkUpdate = "bookmark.update" // of a bookmark of someone else
+	AuditBookmarkDelete = "bookmark.delete" // of a bookmark of someone else
+	AuditCategoryCreate = "category.create"
+	AuditProjectCreate  = "project.create"
+	AuditTwoFactorReset = "user.2fa_reset"
+	AuditUserUnlock     = "user.unlock"
+)
+
+// AuditEntry - a privileged action, who did it and on what
+type AuditEntry struct {
+	ID         int                    `json:"id"`
+	ActorID    int                    `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   int                    `json:"target_id"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Comment - what a user wrote about a bookmark
+type Comment struct {
+	ID         int       `json:"id"`
+	BookmarkID int       `json:"bookmark_id"`
+	UserID     int       `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+}
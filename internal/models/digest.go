@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// How often the digest of the followed projects is sent
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+	DigestOff    = "off"
+)
+
+// DigestPeriods - time covered by a digest, per frequency
+var DigestPeriods = map[string]time.Duration{
+	DigestDaily:  24 * time.Hour,
+	DigestWeekly: 7 * 24 * time.Hour,
+}
+
+// ValidDigestFrequency - whether f is a digest frequency
+func ValidDigestFrequency(f string) bool {
+	_, ok := DigestPeriods[f]
+	return ok || f == DigestOff
+}
+
+// DigestRecipient - user due for a digest of the bookmarks added since Since
+type DigestRecipient struct {
+	User  User
+	Since time.Time
+}
+
+// DigestProject - a followed project and its new bookmarks, top-rated first
+type DigestProject struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	Category  string      `json:"category"`
+	Bookmarks []*Bookmark `json:"bookmarks"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// What a user can follow
+const (
+	FollowProject  = "project"
+	FollowCategory = "category"
+	FollowUser     = "user"
+)
+
+// ValidFollowType - whether t is something a user can follow
+func ValidFollowType(t string) bool {
+	return t == FollowProject || t == FollowCategory || t == FollowUser
+}
+
+// Follow - a project, category or contributor followed by a user
+type Follow struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Identity - an account of an Oauth provider linked to a user
+type Identity struct {
+	ID             int       `json:"id"`
+	UserID         int       `json:"user_id"`
+	Provider       string    `json:"provider"`
+	ProviderUserID string    `json:"provider_user_id"`
+	Email          string    `json:"email,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ErrIdentityTaken - the provider account is already linked to another user
+var ErrIdentityTaken = errors.New("this account is already linked to another user")
+
+// ErrLastLoginMethod - unlinking would leave the user without any way to log in
+var ErrLastLoginMethod = errors.New("cannot unlink the only way to log in to this account, set a password first")
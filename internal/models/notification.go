@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Kinds of notification
+const (
+	NotifyBookmarkNew       = "bookmark.new"       // a bookmark was added to a followed project
+	NotifyBookmarkRated     = "bookmark.rated"     // someone rated one of my bookmarks
+	NotifyBookmarkCommented = "bookmark.commented" // someone commented one of my bookmarks
+	NotifyBookmarkModerated = "bookmark.moderated" // a moderator edited or deleted one of my bookmarks
+)
+
+// Notification - something that happened to a user, pushed live and kept until read
+// BookmarkID is kept as a plain id: the bookmark may be gone (deleted by a moderator)
+type Notification struct {
+	ID         int                    `json:"id"`
+	UserID     int                    `json:"user_id"`
+	Kind       string                 `json:"kind"`
+	ActorID    int                    `json:"actor_id"`
+	BookmarkID int                    `json:"bookmark_id"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Read       bool                   `json:"read"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
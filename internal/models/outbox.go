@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Statuses of the emails of the outbox
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead" // given up on after too many failed attempts
+)
+
+// OutboxEmail - an email waiting in the outbox for the worker to deliver it
+type OutboxEmail struct {
+	ID             int       `json:"id"`
+	Recipient      string    `json:"recipient"`
+	Subject        string    `json:"subject"`
+	HTMLBody       string    `json:"-"`
+	TextBody       string    `json:"-"`
+	UnsubscribeURL string    `json:"-"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Sort orders available on the listings
+const (
+	SortScore     = "score"      // best average rating first
+	SortNewest    = "newest"     // most recently submitted first
+	SortMostRated = "most_rated" // highest number of ratings first
+	SortRelevance = "relevance"  // best match of the search first
+)
+
+// Bounds of the page size
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// PageLimit - apply the default and maximum page size to a requested limit
+func PageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return limit
+}
+
+// ListOptions - pagination, filtering and sorting parameters shared by the listing queries
+// When Cursor is set, keyset pagination is used and Offset is ignored
+type ListOptions struct {
+	Limit       int
+	Offset      int
+	Cursor      string
+	Sort        string
+	Type        string
+	SubmitterID int
+	From        time.Time
+	To          time.Time
+}
+
+// Page - response envelope of a paginated listing
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Next       string      `json:"next,omitempty"`
+}
+
+// Cursor - position of the last item of a page, in terms of the sort keys of the listing
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// ErrInvalidCursor - the cursor could not be decoded, or was issued for another sort order
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// ErrUnsupportedSort - the listing cannot be sorted that way
+var ErrUnsupportedSort = errors.New("unsupported sort order")
+
+// Encode - opaque, url-safe representation of the cursor
+func (c Cursor) Encode() string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecodeCursor - reverse of Cursor.Encode
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
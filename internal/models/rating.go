@@ -4,9 +4,17 @@ import "time"
 
 type Rating struct {
 	ID         int       `json:"id"`
-	UserID     int       `json:"id"`
+	UserID     int       `json:"user_id"`
 	BookmarkID int       `json:"bookmark_id"`
 	Rating     int       `json:"rating"`
+	Bookmark   *Bookmark `json:"bookmark,omitempty"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 }
+
+// RatingSummary - aggregated ratings of a single bookmark
+type RatingSummary struct {
+	BookmarkID int     `json:"bookmark_id"`
+	Average    float64 `json:"average"`
+	Count      int     `json:"count"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "errors"
+
+// Roles a user can be granted - every user is a member
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleCurator   = "curator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by the handlers and the requirePermission middleware
+const (
+	PermEditAnyBookmark = "bookmarks:edit_any" // edit or delete the bookmarks of others
+	PermManageCatalog   = "catalog:manage"     // create projects and categories
+	PermManageUsers     = "users:manage"       // admin area: users, their bookmarks and 2fa, audit log
+	PermManageRoles     = "roles:manage"       // grant and revoke roles
+)
+
+// RolePermissions - what each role allows
+var RolePermissions = map[string][]string{
+	RoleMember:    nil,
+	RoleModerator: {PermEditAnyBookmark},
+	RoleCurator:   {PermManageCatalog},
+	RoleAdmin:     {PermEditAnyBookmark, PermManageCatalog, PermManageUsers, PermManageRoles},
+}
+
+// ErrUnknownRole - no such role
+var ErrUnknownRole = errors.New("unknown role")
+
+// ValidRole - whether role is one of the roles above
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// Can - whether one of the roles of the user grants permission
+// IsAdmin is kept in sync with the admin role
+func (u *User) Can(permission string) bool {
+	if u == nil {
+		return false
+	}
+	roles := u.Roles
+	if u.IsAdmin {
+		roles = append([]string{RoleAdmin}, roles...)
+	}
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrAlreadyExists - a category or project of that name already exists
+var ErrAlreadyExists = errors.New("already exists")
@@ -0,0 +1,43 @@
+package models
+
+// SearchQuery - parameters of a full-text search over the bookmarks
+// Sort is relevance (the default) or newest; when Cursor is set, keyset pagination is used and Offset is ignored
+type SearchQuery struct {
+	Query    string
+	Category string
+	Project  string
+	Type     string
+	Sort     string
+	Cursor   string
+	Limit    int
+	Offset   int
+}
+
+// SearchHit - a bookmark matching a search, with its relevance and highlighted snippet
+type SearchHit struct {
+	Bookmark
+	Category string  `json:"category"`
+	Rank     float64 `json:"rank"`
+	Snippet  string  `json:"snippet"`
+}
+
+// Facet - number of matches falling in a category or a project
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchResults - a page of hits, plus the facets computed over every match of the query
+type SearchResults struct {
+	Query  string       `json:"query"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+	Hits   []*SearchHit `json:"hits"`
+	Facets struct {
+		Categories []Facet `json:"categories"`
+		Projects   []Facet `json:"projects"`
+	} `json:"facets"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
+}
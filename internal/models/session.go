@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session - a login on a device, living as long as its refresh tokens are rotated
+// LastSeenAt is refreshed on every token refresh, so it is accurate to the access token lifetime
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Token - a row of the tokens table: one issued token pair
+// Rows sharing a FamilyID are the successive rotations of the same login
+type Token struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	FamilyID     string     `json:"family_id"`
+	AccessToken  string     `json:"-"`
+	RefreshToken string     `json:"-"`
+	AccessJTI    string     `json:"-"`
+	RefreshJTI   string     `json:"-"`
+	ExpiryDate   time.Time  `json:"expiry_date"`
+	RotatedAt    *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// ErrRefreshTokenReused - the refresh token presented has already been rotated
+var ErrRefreshTokenReused = errors.New("refresh token already used")
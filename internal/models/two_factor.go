@@ -0,0 +1,14 @@
+package models
+
+import "errors"
+
+// TwoFactor - TOTP settings of a user; Secret is set from the enrolment on, Enabled once confirmed with a code
+type TwoFactor struct {
+	UserID   int
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// ErrTwoFactorEnabled - the user already uses two-factor authentication
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
@@ -16,4 +16,21 @@ type User struct {
 	IsAdmin    bool      `json:"is_admin"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
+
+	// EmailTokenExpiry - until when the email confirmation token can be used, EmailTokenSentAt - when it was last emailed
+	EmailTokenExpiry time.Time `json:"-"`
+	EmailTokenSentAt time.Time `json:"-"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+
+	// Language - of the emails sent to the user
+	Language string `json:"language"`
+	// DigestFrequency - how often the digest of the followed projects is sent (daily, weekly or off)
+	DigestFrequency string `json:"digest_frequency"`
+
+	// LockedUntil - the account refuses password logins until then, after too many failures
+	LockedUntil time.Time `json:"-"`
+
+	// Roles - granted roles, besides member (see Can)
+	Roles []string `json:"roles"`
 }
@@ -0,0 +1,256 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemorySearchRepo - in-memory implementation of repository.SearchRepo
+// No stemming: a query term matches any word it is a prefix of ("socket" matches "sockets")
+// Handy for tests, or to run the search without Postgres
+type MemorySearchRepo struct {
+	mu   sync.RWMutex
+	docs []memoryDoc
+}
+
+// memoryDoc - an indexed bookmark and its words, by field
+type memoryDoc struct {
+	bookmark models.Bookmark
+	category string
+	fields   []memoryField
+}
+
+type memoryField struct {
+	words  []string
+	weight float64
+}
+
+// Same weights as the postgres search_vector (A=1, B=0.4, C=0.2, D=0.1)
+const (
+	weightDescription = 1.0
+	weightTypeProject = 0.4
+	weightCategory    = 0.2
+	weightURL         = 0.1
+)
+
+// Index - add (or replace) a bookmark in the index; the project name is read from bkm.ProjectName
+func (m *MemorySearchRepo) Index(bkm models.Bookmark, category string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc := memoryDoc{
+		bookmark: bkm,
+		category: category,
+		fields: []memoryField{
+			{searchWords(bkm.Description), weightDescription},
+			{searchWords(bkm.Type), weightTypeProject},
+			{searchWords(bkm.ProjectName), weightTypeProject},
+			{searchWords(category), weightCategory},
+			{searchWords(bkm.Url), weightURL},
+		},
+	}
+
+	for i := range m.docs {
+		if m.docs[i].bookmark.ID == bkm.ID {
+			m.docs[i] = doc
+			return
+		}
+	}
+	m.docs = append(m.docs, doc)
+}
+
+// Remove - drop a bookmark from the index
+func (m *MemorySearchRepo) Remove(bookmarkID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.docs {
+		if m.docs[i].bookmark.ID == bookmarkID {
+			m.docs = append(m.docs[:i], m.docs[i+1:]...)
+			return
+		}
+	}
+}
+
+// SearchBookmarks - every term of the query must match (AND semantics, like websearch_to_tsquery)
+func (m *MemorySearchRepo) SearchBookmarks(sq models.SearchQuery) (*models.SearchResults, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, _, err := searchSort(sq)
+	if err != nil {
+		return nil, err
+	}
+	res := &models.SearchResults{
+		Query:  sq.Query,
+		Limit:  models.PageLimit(sq.Limit),
+		Offset: max(sq.Offset, 0),
+		Hits:   []*models.SearchHit{},
+	}
+	if sq.Cursor != "" {
+		res.Offset = 0
+	}
+
+	terms := searchWords(sq.Query)
+	if len(terms) == 0 {
+		return res, nil
+	}
+
+	categories := map[string]int{}
+	projects := map[string]int{}
+	var hits []*models.SearchHit
+
+	for _, doc := range m.docs {
+		rank, ok := doc.rank(terms)
+		if !ok {
+			continue
+		}
+		categories[doc.category]++
+		projects[doc.bookmark.ProjectName]++
+
+		if !matchesSearchFilters(sq, doc.category, doc.bookmark.ProjectName, doc.bookmark.Type) {
+			continue
+		}
+		snippetSource := doc.bookmark.Description
+		if snippetSource == "" {
+			snippetSource = doc.bookmark.Url
+		}
+		hits = append(hits, &models.SearchHit{
+			Bookmark: doc.bookmark,
+			Category: doc.category,
+			Rank:     rank,
+			Snippet:  highlight(snippetSource, terms),
+		})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return memoryHitBefore(order, hits[i], hits[j])
+	})
+
+	res.Total = len(hits)
+	res.Facets.Categories = sortedFacets(categories)
+	res.Facets.Projects = sortedFacets(projects)
+
+	if sq.Cursor != "" {
+		after, err := memoryCursorHit(order, sq.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for i < len(hits) && !memoryHitBefore(order, after, hits[i]) {
+			i++
+		}
+		hits = hits[i:]
+	}
+
+	if res.Offset < len(hits) {
+		res.Hits = hits[res.Offset:min(res.Offset+res.Limit, len(hits))]
+	}
+	if res.Offset+res.Limit < len(hits) {
+		res.NextCursor = searchCursor(order, res.Hits[len(res.Hits)-1])
+	}
+	return res, nil
+}
+
+// memoryHitBefore - whether a comes before b in the sort order
+func memoryHitBefore(order string, a, b *models.SearchHit) bool {
+	if order == models.SortNewest && !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	if order == models.SortRelevance && a.Rank != b.Rank {
+		return a.Rank > b.Rank
+	}
+	return a.ID > b.ID
+}
+
+// memoryCursorHit - the position of a cursor, as a hit holding its sort keys
+func memoryCursorHit(order, cursor string) (*models.SearchHit, error) {
+	c, err := models.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if c.Sort != order || len(c.Values) != 2 {
+		return nil, models.ErrInvalidCursor
+	}
+
+	var h models.SearchHit
+	if order == models.SortNewest {
+		h.CreatedAt, err = time.Parse(cursorTimeLayout, c.Values[0])
+	} else {
+		h.Rank, err = strconv.ParseFloat(c.Values[0], 64)
+	}
+	if err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	h.ID, err = strconv.Atoi(c.Values[1])
+	if err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	return &h, nil
+}
+
+// rank - weighted number of occurrences of the terms, and whether every term occurs at least once
+func (d *memoryDoc) rank(terms []string) (float64, bool) {
+	var rank float64
+	for _, term := range terms {
+		found := false
+		for _, f := range d.fields {
+			for _, w := range f.words {
+				if strings.HasPrefix(w, term) {
+					rank += f.weight
+					found = true
+				}
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return rank, true
+}
+
+// searchWords - lower-cased alphanumeric words of a text
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight - wrap the words matching a term in <mark></mark>, the way ts_headline does (text is already sanitized)
+func highlight(text string, terms []string) string {
+	var sb strings.Builder
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := word.String()
+		lower := strings.ToLower(w)
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				sb.WriteString("<mark>" + w + "</mark>")
+				word.Reset()
+				return
+			}
+		}
+		sb.WriteString(w)
+		word.Reset()
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		sb.WriteRune(r)
+	}
+	flush()
+	return sb.String()
+}
@@ -0,0 +1,78 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSearchIndex - small index spread over two categories
+func newTestSearchIndex() *MemorySearchRepo {
+	idx := &MemorySearchRepo{}
+	idx.Index(models.Bookmark{ID: 1, Url: "https://beej.us/guide/bgnet", Type: "article", Description: "Beej guide to network programming with sockets", ProjectName: "sockets"}, "system-linux")
+	idx.Index(models.Bookmark{ID: 2, Url: "https://www.youtube.com/watch?v=s3o5tixMFho", Type: "video", Description: "video tutorial on programming sockets in C", ProjectName: "sockets"}, "system-linux")
+	idx.Index(models.Bookmark{ID: 3, Url: "https://www.youtube.com/watch?v=wLXIWKUWpSs", Type: "video", Description: "Assembly explained easy", ProjectName: "libasm"}, "system-linux")
+	idx.Index(models.Bookmark{ID: 4, Url: "https://en.wikipedia.org/wiki/Huffman_coding", Type: "article", Description: "Huffman coding, the programming way", ProjectName: "huffman_coding"}, "system-algorithms")
+	return idx
+}
+
+func TestMemorySearchRanksAndFacets(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	res, err := idx.SearchBookmarks(models.SearchQuery{Query: "programming"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, []models.Facet{{Value: "system-linux", Count: 2}, {Value: "system-algorithms", Count: 1}}, res.Facets.Categories)
+	assert.Equal(t, []models.Facet{{Value: "sockets", Count: 2}, {Value: "huffman_coding", Count: 1}}, res.Facets.Projects)
+	// same rank for every hit, ties are broken by the most recent id
+	assert.Equal(t, 4, res.Hits[0].ID)
+	assert.Equal(t, "Huffman coding, the <mark>programming</mark> way", res.Hits[0].Snippet)
+}
+
+func TestMemorySearchEveryTermMustMatch(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	res, err := idx.SearchBookmarks(models.SearchQuery{Query: "socket video"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Total)
+	assert.Equal(t, 2, res.Hits[0].ID)
+	assert.Equal(t, "sockets", res.Hits[0].ProjectName)
+}
+
+func TestMemorySearchFiltersKeepFacets(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	res, err := idx.SearchBookmarks(models.SearchQuery{Query: "programming", Category: "system-algorithms"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Total)
+	assert.Len(t, res.Hits, 1)
+	assert.Len(t, res.Facets.Categories, 2, "facets are computed before the category filter")
+
+	idx.Remove(4)
+	res, _ = idx.SearchBookmarks(models.SearchQuery{Query: "programming", Category: "system-algorithms"})
+	assert.Equal(t, 0, res.Total)
+	assert.Empty(t, res.Hits)
+}
+
+func TestMemorySearchCursor(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	first, err := idx.SearchBookmarks(models.SearchQuery{Query: "programming", Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, first.Hits, 2)
+	assert.NotEmpty(t, first.NextCursor)
+
+	next, err := idx.SearchBookmarks(models.SearchQuery{Query: "programming", Limit: 2, Cursor: first.NextCursor})
+	assert.NoError(t, err)
+	if assert.Len(t, next.Hits, 1) {
+		assert.Equal(t, 1, next.Hits[0].ID)
+	}
+	assert.Empty(t, next.NextCursor)
+
+	_, err = idx.SearchBookmarks(models.SearchQuery{Query: "programming", Sort: models.SortNewest, Cursor: first.NextCursor})
+	assert.ErrorIs(t, err, models.ErrInvalidCursor, "a cursor only works for its own sort")
+}
@@ -0,0 +1,80 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/* Activity functions - what users do on bookmarks, streamed in the feeds of their followers */
+
+var activitySortKeys = map[string][]sortKey{
+	models.SortNewest: {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+// recordActivity - record, within the transaction of the change itself, that actor did kind on a bookmark
+func recordActivity(ctx context.Context, tx *sql.Tx, actorID int, kind string, bookmarkID int, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO activities (actor_id, kind, bookmark_id, details, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, stmt, actorID, kind, bookmarkID, raw, time.Now())
+	return err
+}
+
+// GetFeed - paginated activity, newest first, of the users, projects and categories a user follows
+// (their own activity left out), filterable on the date
+func (m *PostgresDBRepo) GetFeed(userID int, opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	base.where("a.actor_id <> ?", userID)
+	me := len(base.args)
+	base.conds = append(base.conds, fmt.Sprintf(`(a.actor_id IN (SELECT followed_id FROM user_follows WHERE user_id = $%[1]d)
+			OR b.project_id IN (SELECT project_id FROM project_follows WHERE user_id = $%[1]d)
+			OR p.category_id IN (SELECT category_id FROM category_follows WHERE user_id = $%[1]d))`, me))
+	if !opts.From.IsZero() {
+		base.where("a.created_at >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		base.where("a.created_at < ?", opts.To)
+	}
+
+	listed := `SELECT a.id, a.kind, a.actor_id, u.username AS actor_name, a.bookmark_id, b.url AS bookmark_url,
+			COALESCE(b.description, '') AS description, b.project_id, p.name AS project_name, c.category, a.details, a.created_at
+		FROM activities a
+		JOIN users u ON u.id = a.actor_id
+		JOIN bookmarks b ON b.id = a.bookmark_id
+		JOIN projects p ON p.id = b.project_id
+		JOIN categories c ON c.id = p.category_id
+		` + base.clause()
+	columns := `id, kind, actor_id, actor_name, bookmark_id, bookmark_url, description, project_id, project_name, category, details, created_at`
+
+	activities := []*models.Activity{}
+	page, err := m.listNewest(listed, base, columns, activitySortKeys, opts, func(rows *sql.Rows) (time.Time, int, error) {
+		var a models.Activity
+		var details []byte
+		err := rows.Scan(&a.ID, &a.Kind, &a.ActorID, &a.ActorName, &a.BookmarkID, &a.BookmarkURL, &a.Description,
+			&a.ProjectID, &a.ProjectName, &a.Category, &details, &a.CreatedAt)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		err = json.Unmarshal(details, &a.Details)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		activities = append(activities, &a)
+		return a.CreatedAt, a.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = activities
+	return page, nil
+}
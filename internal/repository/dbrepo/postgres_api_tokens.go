@@ -0,0 +1,101 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+/* Personal access tokens functions - stored hashed, scopes kept as a space separated list */
+
+// apiTokenLastUsedPrecision - last use is recorded at most once per this duration, not to write on every request
+const apiTokenLastUsedPrecision = time.Minute
+
+const apiTokenColumns = `id, user_id, name, prefix, scopes, last_used_at, expires_at, created_at`
+
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (*models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	var lastUsed, expires sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &lastUsed, &expires, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		t.ExpiresAt = &expires.Time
+	}
+	return &t, nil
+}
+
+// CreateAPIToken - store a new personal access token by its hash, filling its ID and creation date
+func (m *PostgresDBRepo) CreateAPIToken(t *models.APIToken, tokenHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	return m.DB.QueryRowContext(ctx, stmt, t.UserID, t.Name, tokenHash, t.Prefix, strings.Join(t.Scopes, " "), t.ExpiresAt).
+		Scan(&t.ID, &t.CreatedAt)
+}
+
+// GetAPITokenByHash - fetch an unexpired personal access token by its hash
+func (m *PostgresDBRepo) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens
+		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > $2)`
+	return scanAPIToken(m.DB.QueryRowContext(ctx, query, tokenHash, time.Now()))
+}
+
+// GetAPITokensByUser - list the personal access tokens of a user, newest first
+func (m *PostgresDBRepo) GetAPITokensByUser(userID int) ([]*models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken - record a use of a personal access token
+func (m *PostgresDBRepo) TouchAPIToken(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `UPDATE api_tokens SET last_used_at = $1 WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+	_, err := m.DB.ExecContext(ctx, stmt, now, id, now.Add(-apiTokenLastUsedPrecision))
+	return err
+}
+
+// DeleteAPIToken - revoke a personal access token of a user; returns sql.ErrNoRows when the user has no such token
+func (m *PostgresDBRepo) DeleteAPIToken(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
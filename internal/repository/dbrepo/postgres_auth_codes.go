@@ -0,0 +1,33 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+/* Authorization codes functions - one-time codes standing for a completed Oauth login, stored hashed */
+
+// CreateAuthCode - store the hash of an authorization code issued to a user
+func (m *PostgresDBRepo) CreateAuthCode(userID int, codeHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO auth_codes (user_id, code_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, codeHash, expiresAt)
+	return err
+}
+
+// ConsumeAuthCode - use up a valid authorization code, returning the user it was issued to
+// Returns sql.ErrNoRows for an unknown, expired or already used code
+func (m *PostgresDBRepo) ConsumeAuthCode(codeHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	now := time.Now()
+	var userID int
+	stmt := `UPDATE auth_codes SET used_at = $1
+		WHERE code_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id`
+	err := m.DB.QueryRowContext(ctx, stmt, now, codeHash).Scan(&userID)
+	return userID, err
+}
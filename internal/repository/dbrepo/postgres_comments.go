@@ -0,0 +1,74 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"time"
+)
+
+/* Comments functions - users write about bookmarks; comments are not edited, only removed with their bookmark */
+
+var commentSortKeys = map[string][]sortKey{
+	models.SortNewest: {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+// commentExcerpt - runes of a comment quoted in the feed
+const commentExcerpt = 140
+
+// InsertComment - add a comment to a bookmark, filling back its id and creation time; recorded in the feed
+func (m *PostgresDBRepo) InsertComment(c *models.Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO comments (bookmark_id, user_id, body, created_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, stmt, c.BookmarkID, c.UserID, c.Body, time.Now()).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	excerpt := []rune(c.Body)
+	if len(excerpt) > commentExcerpt {
+		excerpt = append(excerpt[:commentExcerpt-1], '…')
+	}
+	err = recordActivity(ctx, tx, c.UserID, models.ActivityBookmarkComment, c.BookmarkID,
+		map[string]interface{}{"comment_id": c.ID, "excerpt": string(excerpt)})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetComments - paginated comments of a bookmark, newest first
+func (m *PostgresDBRepo) GetComments(bookmarkID int, opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	base.where("c.bookmark_id = ?", bookmarkID)
+
+	listed := `SELECT c.id, c.bookmark_id, c.user_id, u.username AS user_name, c.body, c.created_at
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		` + base.clause()
+
+	comments := []*models.Comment{}
+	page, err := m.listNewest(listed, base, `id, bookmark_id, user_id, user_name, body, created_at`, commentSortKeys, opts,
+		func(rows *sql.Rows) (time.Time, int, error) {
+			var c models.Comment
+			err := rows.Scan(&c.ID, &c.BookmarkID, &c.UserID, &c.UserName, &c.Body, &c.CreatedAt)
+			if err != nil {
+				return time.Time{}, 0, err
+			}
+			comments = append(comments, &c)
+			return c.CreatedAt, c.ID, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = comments
+	return page, nil
+}
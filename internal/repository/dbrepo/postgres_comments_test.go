@@ -0,0 +1,81 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInsertComment - the comment and its activity, quoting an excerpt of it, are written together
+func TestInsertComment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	body := strings.Repeat("a", 200)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO comments \(bookmark_id, user_id, body, created_at\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at`).
+		WithArgs(12, 7, body, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+	mock.ExpectExec(`INSERT INTO activities \(actor_id, kind, bookmark_id, details, created_at\)`).
+		WithArgs(7, models.ActivityBookmarkComment, 12, []byte(`{"comment_id":4,"excerpt":"`+strings.Repeat("a", 139)+`…"}`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectCommit()
+
+	c := &models.Comment{BookmarkID: 12, UserID: 7, Body: body}
+	err = repo.InsertComment(c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.ID)
+	assert.False(t, c.CreatedAt.IsZero())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestGetComments - the comments of a bookmark, with the name of their authors and a cursor to the next page
+func TestGetComments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	created := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`WITH listed AS \(.*FROM comments c.*WHERE c.bookmark_id = \$1\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`JOIN users u ON u.id = c.user_id.*ORDER BY created_at DESC, id DESC LIMIT 3 OFFSET 0`).
+		WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "bookmark_id", "user_id", "user_name", "body", "created_at"}).
+			AddRow(6, 12, 7, "lisa", "thanks", created).
+			AddRow(5, 12, 8, "bart", "cool", created).
+			AddRow(4, 12, 7, "lisa", "first", created))
+
+	page, err := repo.GetComments(12, models.ListOptions{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	comments := page.Items.([]*models.Comment)
+	if assert.Len(t, comments, 2) {
+		assert.Equal(t, "lisa", comments[0].UserName)
+	}
+	next, err := models.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2026-10-18T09:30:00", "5"}, next.Values)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -5,10 +5,9 @@ import (
 	"context"
 	"database/sql"
 	"log"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/markbates/goth"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -50,74 +49,170 @@ func (m *PostgresDBRepo) GetProjectsByCategory(category string) ([]*models.Proje
 	return projects, nil
 }
 
-func (m *PostgresDBRepo) GetResourcesByCategoryAndProject(category, project string) ([]*models.Bookmark, error) {
+// GetResourcesByCategoryAndProject - paginated resources of a project, with their rating aggregate
+func (m *PostgresDBRepo) GetResourcesByCategoryAndProject(category, project string, opts models.ListOptions) (*models.Page, error) {
+	var q pageQuery
+	q.where("c.category = ?", category)
+	q.where("p.name = ?", project)
+
+	return m.listBookmarks(q, opts)
+}
+
+// InsertBookmark - insert a new bookmark, filling back its id and timestamps
+func (m *PostgresDBRepo) InsertBookmark(bkm *models.Bookmark) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	var resources []*models.Bookmark
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `SELECT b.id, b.type, b.description, b.url FROM bookmarks b
-		JOIN projects p ON b.project_id = p.id
-		JOIN categories c ON p.category_id = c.id
-		WHERE c.category = $1 AND p.name = $2`
+	stmt := `
+	INSERT INTO bookmarks (url, description, user_id, project_id, type)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, updated_at`
 
-	rows, err := m.DB.QueryContext(ctx, query, category, project)
+	err = tx.QueryRowContext(ctx, stmt, bkm.Url, bkm.Description, bkm.UserID, bkm.ProjectID, bkm.Type).
+		Scan(&bkm.ID, &bkm.CreatedAt, &bkm.UpdatedAt)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var r models.Bookmark
-		err := rows.Scan(&r.ID, &r.Type, &r.Description, &r.Url)
-		if err != nil {
-			return nil, err
-		}
-		resources = append(resources, &r)
+	err = recordActivity(ctx, tx, bkm.UserID, models.ActivityBookmarkCreate, bkm.ID, nil)
+	if err != nil {
+		return err
 	}
-	return resources, nil
+	return tx.Commit()
 }
 
-func (m *PostgresDBRepo) InsertBookmark(bkm *models.Bookmark) error {
+// GetBookmarkByID - fetch a single bookmark by its id
+func (m *PostgresDBRepo) GetBookmarkByID(id int) (*models.Bookmark, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	stmt := `
-	INSERT INTO bookmarks (url, description, user_id, project_id, type)
-	VALUES ($1, $2, $3, $4, $5)`
+	var b models.Bookmark
 
-	_, err := m.DB.ExecContext(ctx, stmt, bkm.Url, bkm.Description, bkm.UserID, bkm.ProjectID, bkm.Type)
+	query := `SELECT id, url, COALESCE(type, ''), COALESCE(description, ''), user_id, project_id,
+		COALESCE(created_at, to_timestamp(0)::timestamp), COALESCE(updated_at, to_timestamp(0)::timestamp)
+		FROM bookmarks WHERE id = $1`
 
-	return err
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&b.ID,
+		&b.Url,
+		&b.Type,
+		&b.Description,
+		&b.UserID,
+		&b.ProjectID,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
 }
 
-func (m *PostgresDBRepo) GetContributors() ([]*models.User, error) {
+// UpdateBookmark - update the editable fields of a bookmark and bump its updated_at
+// The edition by actorID is recorded in the feed, replacing their previous one of the bookmark
+func (m *PostgresDBRepo) UpdateBookmark(bkm *models.Bookmark, actorID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	var conts []*models.User
-	query := `SELECT id, username, email, coalesce(nickname, ''), coalesce(avatar_url, '') FROM users`
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var cont models.User
-		err = rows.Scan(
-			&cont.ID,
-			&cont.UserName,
-			&cont.Email,
-			&cont.NickName,
-			&cont.AvatarURL,
-		)
-		if err != nil {
-			return nil, err
-		}
-		conts = append(conts, &cont)
+	stmt := `UPDATE bookmarks SET url = $1, type = $2, description = $3, project_id = $4, updated_at = $5
+		WHERE id = $6`
+
+	bkm.UpdatedAt = time.Now()
+	res, err := tx.ExecContext(ctx, stmt, bkm.Url, bkm.Type, bkm.Description, bkm.ProjectID, bkm.UpdatedAt, bkm.ID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM activities WHERE actor_id = $1 AND bookmark_id = $2 AND kind = $3`,
+		actorID, bkm.ID, models.ActivityBookmarkUpdate)
+	if err != nil {
+		return err
+	}
+	err = recordActivity(ctx, tx, actorID, models.ActivityBookmarkUpdate, bkm.ID, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteBookmark - remove a bookmark (ratings, comments and their activities are removed by the ON DELETE CASCADE)
+func (m *PostgresDBRepo) DeleteBookmark(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM bookmarks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// checkRowsAffected - report sql.ErrNoRows when a statement targeting a single row touched nothing
+func checkRowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
-	return conts, nil
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetContributors - paginated listing of the users (newest first), filterable on their registration date
+func (m *PostgresDBRepo) GetContributors(opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	if !opts.From.IsZero() {
+		base.where("created_at >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		base.where("created_at < ?", opts.To)
+	}
+
+	listed := `SELECT id, username, email, coalesce(nickname, '') AS nickname, coalesce(avatar_url, '') AS avatar_url,
+			COALESCE(created_at, to_timestamp(0)::timestamp) AS created_at
+		FROM users ` + base.clause()
+
+	conts := []*models.User{}
+	page, err := m.listNewest(listed, base, `id, username, email, nickname, avatar_url, created_at`, userSortKeys, opts,
+		func(rows *sql.Rows) (time.Time, int, error) {
+			var cont models.User
+			err := rows.Scan(
+				&cont.ID,
+				&cont.UserName,
+				&cont.Email,
+				&cont.NickName,
+				&cont.AvatarURL,
+				&cont.CreatedAt,
+			)
+			if err != nil {
+				return time.Time{}, 0, err
+			}
+			conts = append(conts, &cont)
+			return cont.CreatedAt, cont.ID, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = conts
+	return page, nil
 }
 
 func (m *PostgresDBRepo) CheckEmailConflict(email string) (bool, error) {
@@ -149,7 +244,10 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
 
 	var u models.User
 
-	query := `SELECT id, jwt_token_id, username, email, password_hash, COALESCE(email_token, ''), token_hash, avatar_url, verified, is_admin, created_at, updated_at FROM users WHERE email = $1`
+	var sentAt, lockedUntil sql.NullTime
+
+	query := `SELECT id, jwt_token_id, username, email, password_hash, COALESCE(email_token, ''), token_hash, avatar_url, verified, is_admin, created_at, updated_at,
+		email_token_sent_at, totp_enabled, locked_until, language FROM users WHERE email = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, email)
 	err := row.Scan(
@@ -165,11 +263,17 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (models.User, error) {
 		&u.IsAdmin,
 		&u.CreatedAt,
 		&u.UpdatedAt,
+		&sentAt,
+		&u.TwoFactorEnabled,
+		&lockedUntil,
+		&u.Language,
 	)
 	if err != nil {
 		log.Println(err)
 		return u, err
 	}
+	u.EmailTokenSentAt = sentAt.Time
+	u.LockedUntil = lockedUntil.Time
 
 	return u, nil
 }
@@ -179,7 +283,10 @@ func (m *PostgresDBRepo) GetUserByID(userID int) (*models.User, error) {
 	defer cancel()
 
 	var u models.User
-	query := `SELECT id, username, email, avatar_url, verified, is_admin FROM users WHERE id = $1`
+	var roles string
+	query := `SELECT id, username, email, avatar_url, verified, is_admin, totp_enabled, language, digest_frequency,
+		COALESCE((SELECT string_agg(role, ' ' ORDER BY role) FROM user_roles WHERE user_id = users.id), '')
+		FROM users WHERE id = $1`
 	row := m.DB.QueryRowContext(ctx, query, userID)
 	err := row.Scan(
 		&u.ID,
@@ -188,15 +295,21 @@ func (m *PostgresDBRepo) GetUserByID(userID int) (*models.User, error) {
 		&u.AvatarURL,
 		&u.Verified,
 		&u.IsAdmin,
+		&u.TwoFactorEnabled,
+		&u.Language,
+		&u.DigestFrequency,
+		&roles,
 	)
 	if err != nil {
 		return &u, err
 	}
+	u.Roles = strings.Fields(roles)
 	return &u, nil
 }
 
 // InsertNewUser - Register a new 'classic' user - combination email + password
-func (m *PostgresDBRepo) InsertNewUser(username, email, password, emailToken, defaultAvatar string) (int, error) {
+// emailToken is the hash of the confirmation token sent by email, usable until emailTokenExpiry
+func (m *PostgresDBRepo) InsertNewUser(username, email, password, emailToken, defaultAvatar, language string, emailTokenExpiry time.Time) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
@@ -206,10 +319,10 @@ func (m *PostgresDBRepo) InsertNewUser(username, email, password, emailToken, de
 		return 0, err
 	}
 
-	stmt := `INSERT INTO users (username, email, password_hash, email_token, avatar_url, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	stmt := `INSERT INTO users (username, email, password_hash, email_token, email_token_expires_at, email_token_sent_at, avatar_url, language, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $6, $6) RETURNING id`
 
-	err = m.DB.QueryRowContext(ctx, stmt, username, email, hashedPassword, emailToken, defaultAvatar, time.Now(), time.Now()).Scan(&userID)
+	err = m.DB.QueryRowContext(ctx, stmt, username, email, hashedPassword, emailToken, emailTokenExpiry, time.Now(), defaultAvatar, language).Scan(&userID)
 
 	if err != nil {
 		return 0, err
@@ -217,47 +330,6 @@ func (m *PostgresDBRepo) InsertNewUser(username, email, password, emailToken, de
 	return userID, nil
 }
 
-// StoreUserInDB - stores a new user who log/register with OAUTH (Github provider)
-// func (m *PostgresDBRepo) StoreUserInDB(userID string, user *goth.User) error {
-// 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-// 	defer cancel()
-
-// 	fakeMail := "any"
-// 	fakePass := "pass"
-
-// 	stmt := `INSERT INTO users (email, password_hash, username, avatar_url) VALUES ($1, $2, $3, $4)`
-// 	_, err := m.DB.ExecContext(ctx, stmt, fakeMail, fakePass, user.NickName, user.AvatarURL)
-// 	if err != nil {
-// 		log.Println("StoreUserInDB:: failed to insert user", err)
-// 		return err
-// 	}
-// 	return nil
-// }
-
-func (m *PostgresDBRepo) StoreUserInDB(userID string, user *goth.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	// fakeMail := "any"
-	fakePass := "nope"
-	realID, _ := strconv.Atoi(userID)
-
-	stmt := `INSERT INTO users (id, email, password_hash, username, avatar_url) VALUES ($1, $2, $3, $4, $5)`
-	_, err := m.DB.ExecContext(ctx, stmt, realID, user.Email, fakePass, user.NickName, user.AvatarURL)
-	if err != nil {
-		log.Println("StoreUserInDB:: failed to insert user", err)
-		return err
-	}
-	return nil
-}
-
-func (m *PostgresDBRepo) StoreTokenPairs(userID int, accessToken, refreshToken string, expiry time.Time) error {
-	stmt := `INSERT INTO tokens (user_id, access_token, refresh_token, expiry_date)
-		VALUES ($1, $2, $3, $4)`
-	_, err := m.DB.Exec(stmt, userID, accessToken, refreshToken, expiry)
-	return err
-}
-
 // FetchUserFromDB - fetch a user by ID to give information to dashboard protected route
 func (m *PostgresDBRepo) FetchUserFromDB(userID string) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
@@ -265,17 +337,15 @@ func (m *PostgresDBRepo) FetchUserFromDB(userID string) (models.User, error) {
 	var u models.User
 	log.Println("FetchUserFromDB:: userID just before querying db => ", userID)
 
-	query := `SELECT username, COALESCE(email, ''), COALESCE(nickname, ''), password_hash, COALESCE(email_token, ''), COALESCE(token_hash, ''),
-	avatar_url, verified, is_admin FROM users WHERE id = $1`
+	// never the password hash nor the tokens: the user is handed over as is
+	query := `SELECT username, COALESCE(email, ''), COALESCE(nickname, ''), avatar_url, verified, is_admin
+	FROM users WHERE id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, userID)
 	err := row.Scan(
 		&u.UserName,
 		&u.Email,
 		&u.NickName,
-		&u.Password,
-		&u.EmailToken,
-		&u.TokenHash,
 		&u.AvatarURL,
 		&u.Verified,
 		&u.IsAdmin,
@@ -293,7 +363,8 @@ func (m *PostgresDBRepo) GetUserByConfirmationToken(token string) (*models.User,
 	defer cancel()
 
 	var user models.User
-	query := `SELECT id, username, email, verified FROM users WHERE email_token = $1`
+	var expiry sql.NullTime
+	query := `SELECT id, username, email, verified, email_token_expires_at FROM users WHERE email_token = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, token)
 	err := row.Scan(
@@ -301,18 +372,30 @@ func (m *PostgresDBRepo) GetUserByConfirmationToken(token string) (*models.User,
 		&user.UserName,
 		&user.Email,
 		&user.Verified,
+		&expiry,
 	)
 	if err != nil {
 		return &user, err
 	}
+	user.EmailTokenExpiry = expiry.Time
 	return &user, nil
 }
 
+// SetEmailToken - replace the email confirmation token of a user (hashed), recording that it is being sent now
+func (m *PostgresDBRepo) SetEmailToken(userID int, emailToken string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE users SET email_token = $1, email_token_expires_at = $2, email_token_sent_at = $3 WHERE id = $4`
+	_, err := m.DB.ExecContext(ctx, stmt, emailToken, expiresAt, time.Now(), userID)
+	return err
+}
+
 func (m *PostgresDBRepo) VerifyUser(userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	stmt := `UPDATE users SET verified = TRUE, email_token = NULL WHERE id = $1`
+	stmt := `UPDATE users SET verified = TRUE, email_token = NULL, email_token_expires_at = NULL WHERE id = $1`
 	_, err := m.DB.ExecContext(ctx, stmt, userID)
 	if err != nil {
 		return err
@@ -320,11 +403,6 @@ func (m *PostgresDBRepo) VerifyUser(userID int) error {
 	return nil
 }
 
-func (m *PostgresDBRepo) DeleteTokensPairOnLogOut(userID int) error {
-	_, err := m.DB.Exec("DELETE FROM tokens WHERE user_id = $1", userID)
-	return err
-}
-
 func (m *PostgresDBRepo) SaveAvatarURL(userID int, avatarURL string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
@@ -355,48 +433,36 @@ func (m *PostgresDBRepo) GetDashboardStats(userID int) (int, error) {
 	return count, nil
 }
 
-func (m *PostgresDBRepo) GetBookmarksByUser(userID int) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// GetBookmarksByUser - paginated bookmarks submitted by a user
+func (m *PostgresDBRepo) GetBookmarksByUser(userID int, opts models.ListOptions) (*models.Page, error) {
+	var q pageQuery
+	q.where("b.user_id = ?", userID)
 
-	var bkmsByUser []map[string]interface{}
+	return m.listBookmarks(q, opts)
+}
 
-	query := `SELECT b.id, b.url, b.type, b.description, p.name AS project_name
-	FROM bookmarks b
-	JOIN projects p ON b.project_id = p.id
-	WHERE user_id = $1`
+// UpdateLanguage - set the language of the emails sent to a user
+func (m *PostgresDBRepo) UpdateLanguage(userID int, language string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	stmt := `UPDATE users SET language = $1, updated_at = $2 WHERE id = $3`
+	res, err := m.DB.ExecContext(ctx, stmt, language, time.Now(), userID)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id int
-		var url, bkType, bkDesc, bkProjectName string
+	return checkRowsAffected(res)
+}
 
-		err := rows.Scan(
-			&id,
-			&url,
-			&bkType,
-			&bkDesc,
-			&bkProjectName,
-		)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		bkm := map[string]interface{}{
-			"id":           id,
-			"url":          url,
-			"type":         bkType,
-			"description":  bkDesc,
-			"project_name": bkProjectName,
-		}
-		bkmsByUser = append(bkmsByUser, bkm)
+// UpdateDigestFrequency - set how often the digest of the followed projects is sent to a user
+func (m *PostgresDBRepo) UpdateDigestFrequency(userID int, frequency string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
 
+	stmt := `UPDATE users SET digest_frequency = $1, updated_at = $2 WHERE id = $3`
+	res, err := m.DB.ExecContext(ctx, stmt, frequency, time.Now(), userID)
+	if err != nil {
+		return err
 	}
-	return bkmsByUser, nil
+	return checkRowsAffected(res)
 }
@@ -1,7 +1,12 @@
 package dbrepo
 
 import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -48,28 +53,168 @@ func TestGetResourcesByCategoryAndProject(t *testing.T) {
 	// test data
 	category := "system-linux"
 	project := "libasm"
+	now := time.Now()
 
-	// Mock the expected results
-	rows := sqlmock.NewRows([]string{"id", "type", "description", "url"}).AddRow(1, "tutorial", "Assembly little project", "https://assemblyDesmystified.com")
+	// expected queries - total count, then the page itself ordered by score
+	mock.ExpectQuery(`WITH listed AS \(.*WHERE c.category = \$1 AND p.name = \$2.*\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(category, project).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
-	// expected query
-	mock.ExpectQuery(`SELECT b.id, b.type, b.description, b.url FROM bookmarks b
-	JOIN projects p ON b.project_id = p.id
-	JOIN categories c ON p.category_id = c.id
-	WHERE c.category = \$1 AND p.name = \$2`).WithArgs(category, project).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"id", "url", "type", "description", "user_id", "project_id", "project_name", "created_at", "updated_at", "average_rating", "rating_count"}).
+		AddRow(1, "https://assemblyDesmystified.com", "tutorial", "Assembly little project", 75, 1, project, now, now, 4.5, 2)
 
-	resources, err := repo.GetResourcesByCategoryAndProject(category, project)
+	mock.ExpectQuery(`FROM listed ORDER BY average_rating DESC, rating_count DESC, id DESC LIMIT 21 OFFSET 0`).
+		WithArgs(category, project).
+		WillReturnRows(rows)
+
+	page, err := repo.GetResourcesByCategoryAndProject(category, project, models.ListOptions{})
 	if err != nil {
 		t.Fatalf("error calling GetResourcesByCategoryAndProject: %v", err)
 	}
+	resources := page.Items.([]*models.Bookmark)
 
 	// Check results
+	assert.Equal(t, 1, page.Total, "expected total to match")
+	assert.Empty(t, page.NextCursor, "expected no next page")
 	assert.Equal(t, 1, len(resources), "expected one resources")
 	assert.Equal(t, "tutorial", resources[0].Type, "expected resource type to match")
 	assert.Equal(t, "Assembly little project", resources[0].Description, "expected description to match")
 	assert.Equal(t, "https://assemblyDesmystified.com", resources[0].Url, "expected url links to match")
+	assert.Equal(t, 4.5, resources[0].AverageRating, "expected average rating to match")
+	assert.Equal(t, 2, resources[0].RatingCount, "expected rating count to match")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there was unfulfilled expectations: %s", err)
+	}
+}
+
+// TestGetBookmarksByUserKeyset - a cursor turns into a row comparison, and a full page yields the next cursor
+func TestGetBookmarksByUserKeyset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	created := time.Date(2024, 6, 19, 10, 2, 38, 0, time.UTC)
+	cursor := models.Cursor{Sort: models.SortNewest, Values: []string{"2024-06-20T08:00:00", "12"}}.Encode()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(75, "video").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	rows := sqlmock.NewRows([]string{"id", "url", "type", "description", "user_id", "project_id", "project_name", "created_at", "updated_at", "average_rating", "rating_count"}).
+		AddRow(11, "https://youtu.be/a", "video", "", 75, 1, "libasm", created, created, 0, 0).
+		AddRow(10, "https://youtu.be/b", "video", "", 75, 1, "libasm", created, created, 0, 0)
+
+	mock.ExpectQuery(`FROM listed WHERE \(created_at, id\) < \(\$3::timestamp, \$4::integer\) ORDER BY created_at DESC, id DESC LIMIT 2 OFFSET 0`).
+		WithArgs(75, "video", "2024-06-20T08:00:00", "12").
+		WillReturnRows(rows)
+
+	page, err := repo.GetBookmarksByUser(75, models.ListOptions{Limit: 1, Offset: 3, Cursor: cursor, Sort: models.SortNewest, Type: "video"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, page.Total)
+	assert.Equal(t, 0, page.Offset, "offset is ignored when paginating with a cursor")
+	assert.Len(t, page.Items, 1)
+
+	next, err := models.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-06-19T10:02:38", "11"}, next.Values)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there was unfulfilled expectations: %s", err)
 	}
 }
+
+// TestUpdateBookmark - updating a bookmark must bump updated_at, target the right row and replace the previous
+// edition of the actor in the feed
+func TestUpdateBookmark(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	bkm := &models.Bookmark{ID: 3, Url: "https://beej.us/guide/bgnet", Type: "article", Description: "Beej sockets guide", ProjectID: 8}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE bookmarks SET url = \$1, type = \$2, description = \$3, project_id = \$4, updated_at = \$5`).
+		WithArgs(bkm.Url, bkm.Type, bkm.Description, bkm.ProjectID, sqlmock.AnyArg(), bkm.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM activities WHERE actor_id = \$1 AND bookmark_id = \$2 AND kind = \$3`).
+		WithArgs(5, bkm.ID, models.ActivityBookmarkUpdate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO activities \(actor_id, kind, bookmark_id, details, created_at\)`).
+		WithArgs(5, models.ActivityBookmarkUpdate, bkm.ID, []byte(`{}`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	err = repo.UpdateBookmark(bkm, 5)
+
+	assert.NoError(t, err)
+	assert.False(t, bkm.UpdatedAt.IsZero(), "expected updated_at to be set")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestDeleteBookmarkNotFound - deleting a bookmark which does not exist reports sql.ErrNoRows
+func TestDeleteBookmarkNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectExec(`DELETE FROM bookmarks WHERE id = \$1`).
+		WithArgs(42).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = repo.DeleteBookmark(42)
+
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestFetchUserFromDB - the user info never carries the password hash nor the tokens of the user
+func TestFetchUserFromDB(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		for _, secret := range []string{"password_hash", "email_token", "token_hash"} {
+			if strings.Contains(actualSQL, secret) {
+				return fmt.Errorf("query selects %s", secret)
+			}
+		}
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+	})))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email", "nickname", "avatar_url", "verified", "is_admin"}).
+			AddRow("lisa", "lisa@example.com", "", "https://example.com/lisa.png", true, false))
+
+	u, err := repo.FetchUserFromDB("7")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "lisa", u.UserName)
+	assert.Empty(t, u.Password)
+	assert.Empty(t, u.TokenHash)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+/* Follows and digest functions - users follow projects, categories and contributors, and get the new bookmarks
+of the projects by email */
+
+// followTable - table of the follows of a type, its column naming the followed row, and the table of that row
+type followTable struct {
+	table, column, target string
+}
+
+var followTables = map[string]followTable{
+	models.FollowProject:  {"project_follows", "project_id", "projects"},
+	models.FollowCategory: {"category_follows", "category_id", "categories"},
+	models.FollowUser:     {"user_follows", "followed_id", "users"},
+}
+
+func followTableOf(followType string) (followTable, error) {
+	t, ok := followTables[followType]
+	if !ok {
+		return t, fmt.Errorf("cannot follow a %q", followType)
+	}
+	return t, nil
+}
+
+// Follow - follow a project, category or user (no-op when already followed); returns sql.ErrNoRows for an unknown one
+func (m *PostgresDBRepo) Follow(userID int, followType string, targetID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	t, err := followTableOf(followType)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+t.target+` WHERE id = $1)`, targetID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	stmt := `INSERT INTO ` + t.table + ` (user_id, ` + t.column + `, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	_, err = m.DB.ExecContext(ctx, stmt, userID, targetID, time.Now())
+	return err
+}
+
+// Unfollow - stop following a project, category or user; returns sql.ErrNoRows when it was not followed
+func (m *PostgresDBRepo) Unfollow(userID int, followType string, targetID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	t, err := followTableOf(followType)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM `+t.table+` WHERE user_id = $1 AND `+t.column+` = $2`, userID, targetID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// GetFollows - projects, categories and users followed by a user, most recently followed first
+func (m *PostgresDBRepo) GetFollows(userID int) ([]*models.Follow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT $2, p.id, p.name, f.created_at FROM project_follows f JOIN projects p ON p.id = f.project_id
+		WHERE f.user_id = $1
+		UNION ALL
+		SELECT $3, c.id, c.category, f.created_at FROM category_follows f JOIN categories c ON c.id = f.category_id
+		WHERE f.user_id = $1
+		UNION ALL
+		SELECT $4, u.id, u.username, f.created_at FROM user_follows f JOIN users u ON u.id = f.followed_id
+		WHERE f.user_id = $1
+		ORDER BY 4 DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, models.FollowProject, models.FollowCategory, models.FollowUser)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []*models.Follow{}
+	for rows.Next() {
+		var f models.Follow
+		err := rows.Scan(&f.Type, &f.ID, &f.Name, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		follows = append(follows, &f)
+	}
+	return follows, rows.Err()
+}
+
+// ClaimDueDigests - users whose digest is due at now, following something; they are leased until leaseUntil
+// so that another instance of the job skips them, and are due again then unless CompleteDigest was called.
+// Since is their previous digest, or one period ago for the first
+func (m *PostgresDBRepo) ClaimDueDigests(now, leaseUntil time.Time, limit int) ([]*models.DigestRecipient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `WITH due AS (
+			SELECT id FROM users
+			WHERE verified
+				AND ((digest_frequency = $2 AND (last_digest_at IS NULL OR last_digest_at <= $3))
+					OR (digest_frequency = $4 AND (last_digest_at IS NULL OR last_digest_at <= $5)))
+				AND (digest_claimed_until IS NULL OR digest_claimed_until <= $7)
+				AND (EXISTS (SELECT 1 FROM project_follows WHERE user_id = users.id)
+					OR EXISTS (SELECT 1 FROM category_follows WHERE user_id = users.id))
+			ORDER BY id
+			LIMIT $6
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE users u SET digest_claimed_until = $1 FROM due WHERE u.id = due.id
+		RETURNING u.id, u.username, u.email, u.language, u.digest_frequency, u.last_digest_at`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, leaseUntil,
+		models.DigestDaily, now.Add(-models.DigestPeriods[models.DigestDaily]),
+		models.DigestWeekly, now.Add(-models.DigestPeriods[models.DigestWeekly]),
+		limit, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []*models.DigestRecipient
+	for rows.Next() {
+		var d models.DigestRecipient
+		var last sql.NullTime
+		err := rows.Scan(&d.User.ID, &d.User.UserName, &d.User.Email, &d.User.Language, &d.User.DigestFrequency, &last)
+		if err != nil {
+			return nil, err
+		}
+		d.Since = now.Add(-models.DigestPeriods[d.User.DigestFrequency])
+		if last.Valid {
+			d.Since = last.Time
+		}
+		recipients = append(recipients, &d)
+	}
+	return recipients, rows.Err()
+}
+
+// CompleteDigest - queue the digest e of a claimed user, nil when there was nothing new, and move their last
+// digest to sentAt: in one transaction, so that a period is neither lost nor sent twice
+func (m *PostgresDBRepo) CompleteDigest(userID int, sentAt time.Time, e *models.OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if e != nil {
+		err = tx.QueryRowContext(ctx, enqueueEmailStmt, e.Recipient, e.Subject, e.HTMLBody, e.TextBody, e.UnsubscribeURL, models.EmailPending, time.Now()).Scan(&e.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `UPDATE users SET last_digest_at = $1, digest_claimed_until = NULL WHERE id = $2`
+	res, err := tx.ExecContext(ctx, stmt, sentAt, userID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDigestProjects - bookmarks added by others since since to the projects a user follows, directly or through
+// their category, grouped by project; the top-rated come first, within and across the projects
+func (m *PostgresDBRepo) GetDigestProjects(userID int, since time.Time, limit int) ([]*models.DigestProject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT b.id, b.url, COALESCE(b.type, ''), COALESCE(b.description, ''), b.user_id, b.project_id, b.created_at,
+		p.name, c.category, COALESCE(AVG(r.rating), 0) AS average, COUNT(r.id) AS count
+		FROM bookmarks b
+		JOIN projects p ON p.id = b.project_id
+		JOIN categories c ON c.id = p.category_id
+		LEFT JOIN ratings r ON r.bookmark_id = b.id
+		WHERE b.created_at > $2 AND b.user_id <> $1
+			AND (b.project_id IN (SELECT project_id FROM project_follows WHERE user_id = $1)
+				OR p.category_id IN (SELECT category_id FROM category_follows WHERE user_id = $1))
+		GROUP BY b.id, p.name, c.category
+		ORDER BY average DESC, count DESC, b.created_at DESC
+		LIMIT $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []*models.DigestProject
+	byID := map[int]*models.DigestProject{}
+	for rows.Next() {
+		var b models.Bookmark
+		var category string
+		err := rows.Scan(&b.ID, &b.Url, &b.Type, &b.Description, &b.UserID, &b.ProjectID, &b.CreatedAt,
+			&b.ProjectName, &category, &b.AverageRating, &b.RatingCount)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := byID[b.ProjectID]
+		if !ok {
+			p = &models.DigestProject{ID: b.ProjectID, Name: b.ProjectName, Category: category}
+			byID[b.ProjectID] = p
+			projects = append(projects, p)
+		}
+		p.Bookmarks = append(p.Bookmarks, &b)
+	}
+	return projects, rows.Err()
+}
@@ -0,0 +1,172 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFollowUnknown - following a project which does not exist is reported, not recorded
+func TestFollowUnknown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM projects WHERE id = \$1\)`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	assert.ErrorIs(t, repo.Follow(1, "project", 42), sql.ErrNoRows)
+	assert.Error(t, repo.Follow(1, "bookmark", 42), "only projects and categories")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestGetDigestProjects - the bookmarks are grouped by project, in the order of their best bookmark
+func TestGetDigestProjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	since := time.Now().Add(-24 * time.Hour)
+	columns := []string{"id", "url", "type", "description", "user_id", "project_id", "created_at", "name", "category", "average", "count"}
+
+	mock.ExpectQuery(`FROM bookmarks b.*WHERE b.created_at > \$2 AND b.user_id <> \$1.*ORDER BY average DESC`).
+		WithArgs(1, since, 50).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(7, "https://example.com/elf", "doc", "ELF", 2, 3, time.Now(), "readelf", "System", 4.5, 2).
+			AddRow(9, "https://example.com/proc", "doc", "proc", 2, 4, time.Now(), "procfs", "System", 4.0, 1).
+			AddRow(8, "https://example.com/sections", "doc", "", 3, 3, time.Now(), "readelf", "System", 0.0, 0))
+
+	projects, err := repo.GetDigestProjects(1, since, 50)
+
+	assert.NoError(t, err)
+	if assert.Len(t, projects, 2) {
+		assert.Equal(t, "readelf", projects[0].Name)
+		assert.Len(t, projects[0].Bookmarks, 2)
+		assert.Equal(t, 4.5, projects[0].Bookmarks[0].AverageRating)
+		assert.Equal(t, "procfs", projects[1].Name)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestClaimDueDigests - the due users are leased, their last digest left alone until theirs is queued
+func TestClaimDueDigests(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	now := time.Now()
+	lease := now.Add(10 * time.Minute)
+	last := now.Add(-8 * 24 * time.Hour)
+
+	mock.ExpectQuery(`FOR UPDATE SKIP LOCKED\s*\)\s*UPDATE users u SET digest_claimed_until = \$1 FROM due`).
+		WithArgs(lease, models.DigestDaily, sqlmock.AnyArg(), models.DigestWeekly, sqlmock.AnyArg(), 100, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "language", "digest_frequency", "last_digest_at"}).
+			AddRow(1, "ned", "ned@example.com", "en", models.DigestWeekly, last).
+			AddRow(2, "arya", "arya@example.com", "fr", models.DigestDaily, nil))
+
+	recipients, err := repo.ClaimDueDigests(now, lease, 100)
+
+	assert.NoError(t, err)
+	if assert.Len(t, recipients, 2) {
+		assert.Equal(t, last, recipients[0].Since)
+		assert.Equal(t, now.Add(-24*time.Hour), recipients[1].Since, "one period back for a first digest")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestCompleteDigest - the digest is queued and the last digest moved in one transaction, or not at all
+func TestCompleteDigest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO email_outbox`).
+		WithArgs("ned@example.com", "digest", "<p>digest</p>", "digest", "https://api.example.com/digest/unsubscribe?token=t", models.EmailPending, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec(`UPDATE users SET last_digest_at = \$1, digest_claimed_until = NULL WHERE id = \$2`).
+		WithArgs(now, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// the outbox is out of reach: the last digest stays where it was
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO email_outbox`).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	e := &models.OutboxEmail{Recipient: "ned@example.com", Subject: "digest", HTMLBody: "<p>digest</p>", TextBody: "digest", UnsubscribeURL: "https://api.example.com/digest/unsubscribe?token=t"}
+	assert.NoError(t, repo.CompleteDigest(1, now, e))
+	assert.Equal(t, 5, e.ID)
+	assert.ErrorIs(t, repo.CompleteDigest(1, now, &models.OutboxEmail{}), sql.ErrConnDone)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestGetFeed - the activity of the followed users, projects and categories, the caller's own left out
+func TestGetFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	now := time.Now()
+
+	mock.ExpectQuery(`WITH listed AS \(.*WHERE a.actor_id <> \$1 AND \(a.actor_id IN \(SELECT followed_id FROM user_follows WHERE user_id = \$1\).*\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`FROM listed ORDER BY created_at DESC, id DESC LIMIT 3 OFFSET 0`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "actor_id", "actor_name", "bookmark_id", "bookmark_url", "description", "project_id", "project_name", "category", "details", "created_at"}).
+			AddRow(9, models.ActivityBookmarkRate, 2, "arya", 7, "https://example.com/elf", "The ELF format", 3, "readelf", "System", []byte(`{"rating":5}`), now).
+			AddRow(8, models.ActivityBookmarkCreate, 2, "arya", 7, "https://example.com/elf", "The ELF format", 3, "readelf", "System", []byte(`{}`), now.Add(-time.Minute)).
+			AddRow(6, models.ActivityBookmarkCreate, 4, "sansa", 5, "https://example.com/proc", "", 4, "procfs", "System", []byte(`{}`), now.Add(-time.Hour)))
+
+	page, err := repo.GetFeed(1, models.ListOptions{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	activities := page.Items.([]*models.Activity)
+	if assert.Len(t, activities, 2) {
+		assert.Equal(t, float64(5), activities[0].Details["rating"])
+		assert.Equal(t, "arya", activities[1].ActorName)
+		assert.Equal(t, "The ELF format", activities[1].Description)
+	}
+	assert.NotEmpty(t, page.NextCursor)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
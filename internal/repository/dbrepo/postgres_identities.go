@@ -0,0 +1,141 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"time"
+)
+
+/* Oauth identities functions - a provider account (provider + its user id) belongs to a single user */
+
+// GetUserByIdentity - fetch the user a provider account is linked to
+func (m *PostgresDBRepo) GetUserByIdentity(provider, providerUserID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var u models.User
+	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.verified, u.is_admin
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.provider_user_id = $2`
+	err := m.DB.QueryRowContext(ctx, query, provider, providerUserID).Scan(
+		&u.ID,
+		&u.UserName,
+		&u.Email,
+		&u.AvatarURL,
+		&u.Verified,
+		&u.IsAdmin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// LinkIdentity - link a provider account to identity.UserID, filling the identity ID
+// Linking again to the same user is a no-op; returns models.ErrIdentityTaken when it belongs to another user
+func (m *PostgresDBRepo) LinkIdentity(identity *models.Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO user_identities (user_id, provider, provider_user_id, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, provider_user_id) DO UPDATE SET email = EXCLUDED.email
+		WHERE user_identities.user_id = EXCLUDED.user_id
+		RETURNING id, created_at`
+	err := m.DB.QueryRowContext(ctx, stmt, identity.UserID, identity.Provider, identity.ProviderUserID, identity.Email).
+		Scan(&identity.ID, &identity.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.ErrIdentityTaken
+	}
+	return err
+}
+
+// CreateUserWithIdentity - register a user coming from an Oauth provider, along with its identity
+// The user has no password; when the username is taken, the provider user id is appended to it
+func (m *PostgresDBRepo) CreateUserWithIdentity(u *models.User, identity *models.Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `INSERT INTO users (username, email, password_hash, avatar_url, verified, created_at, updated_at)
+		VALUES (CASE WHEN EXISTS (SELECT 1 FROM users WHERE username = $1) THEN $1 || '-' || $2 ELSE $1 END,
+			$3, '', $4, $5, $6, $6)
+		RETURNING id, username`
+	err = tx.QueryRowContext(ctx, stmt, u.UserName, identity.ProviderUserID, u.Email, u.AvatarURL, u.Verified, now).
+		Scan(&u.ID, &u.UserName)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = u.ID
+	stmt = `INSERT INTO user_identities (user_id, provider, provider_user_id, email) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, stmt, identity.UserID, identity.Provider, identity.ProviderUserID, identity.Email).
+		Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetIdentitiesByUser - list the provider accounts linked to a user
+func (m *PostgresDBRepo) GetIdentitiesByUser(userID int) ([]*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT id, user_id, provider, provider_user_id, email, created_at
+		FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*models.Identity
+	for rows.Next() {
+		var i models.Identity
+		err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.ProviderUserID, &i.Email, &i.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &i)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteIdentity - unlink a provider account from its user
+// Refused with models.ErrLastLoginMethod when the user has neither a password nor another identity;
+// returns sql.ErrNoRows when the user has no such identity
+func (m *PostgresDBRepo) DeleteIdentity(userID, identityID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `DELETE FROM user_identities WHERE id = $1 AND user_id = $2
+		AND (EXISTS (SELECT 1 FROM users WHERE id = $2 AND password_hash <> '')
+			OR EXISTS (SELECT 1 FROM user_identities WHERE user_id = $2 AND id <> $1))`
+	res, err := m.DB.ExecContext(ctx, stmt, identityID, userID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM user_identities WHERE id = $1 AND user_id = $2)`
+	err = m.DB.QueryRowContext(ctx, query, identityID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return models.ErrLastLoginMethod
+	}
+	return sql.ErrNoRows
+}
@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeleteIdentity - unlinking is refused when it is the last way to log in, missing identities are reported
+func TestDeleteIdentity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectExec(`DELETE FROM user_identities WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(3, 12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(3, 12).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectExec(`DELETE FROM user_identities WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(4, 12).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(4, 12).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	assert.ErrorIs(t, repo.DeleteIdentity(12, 3), models.ErrLastLoginMethod)
+	assert.ErrorIs(t, repo.DeleteIdentity(12, 4), sql.ErrNoRows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestLinkIdentityTaken - a provider account linked to another user is not taken over
+func TestLinkIdentityTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectQuery(`INSERT INTO user_identities .* ON CONFLICT \(provider, provider_user_id\)`).
+		WithArgs(12, "github", "583231", "octocat@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+
+	err = repo.LinkIdentity(&models.Identity{UserID: 12, Provider: "github", ProviderUserID: "583231", Email: "octocat@example.com"})
+	assert.ErrorIs(t, err, models.ErrIdentityTaken)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
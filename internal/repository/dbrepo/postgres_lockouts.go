@@ -0,0 +1,34 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+/* Lockout functions - accounts refusing password logins for a while, after too many failures */
+
+// LockUser - refuse the password logins of a user until the given time
+func (m *PostgresDBRepo) LockUser(userID int, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE users SET locked_until = $1, updated_at = $2 WHERE id = $3`
+	res, err := m.DB.ExecContext(ctx, stmt, until, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// UnlockUser - lift the lockout of a user, if any; returns sql.ErrNoRows for an unknown user
+func (m *PostgresDBRepo) UnlockUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE users SET locked_until = NULL, updated_at = $1 WHERE id = $2`
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
@@ -0,0 +1,48 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+/* Magic links functions - passwordless login links, stored hashed, usable once */
+
+// CreateMagicLink - store the hash of a login link token emailed to a user
+func (m *PostgresDBRepo) CreateMagicLink(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO magic_links (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expiresAt)
+	return err
+}
+
+// ConsumeMagicLink - use up a valid login link, returning the user it was sent to
+// Every other pending link of the user is consumed as well; returns sql.ErrNoRows for an unknown,
+// expired or already used link
+func (m *PostgresDBRepo) ConsumeMagicLink(tokenHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	stmt := `UPDATE magic_links SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id`
+	err = tx.QueryRowContext(ctx, stmt, now, tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE magic_links SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`, now, userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
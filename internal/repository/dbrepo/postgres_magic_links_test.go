@@ -0,0 +1,47 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConsumeMagicLink - a valid link logs its user in and burns every pending link of that user
+func TestConsumeMagicLink(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE magic_links SET used_at = \$1\s+WHERE token_hash = \$2 AND used_at IS NULL AND expires_at > \$1`).
+		WithArgs(sqlmock.AnyArg(), "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(31))
+	mock.ExpectExec(`UPDATE magic_links SET used_at = \$1 WHERE user_id = \$2 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 31).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	userID, err := repo.ConsumeMagicLink("hash")
+	assert.NoError(t, err)
+	assert.Equal(t, 31, userID)
+
+	// used, expired or unknown
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE magic_links SET used_at`).
+		WithArgs(sqlmock.AnyArg(), "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	_, err = repo.ConsumeMagicLink("hash")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+/* Notifications functions - kept until read, fanned out between the instances with LISTEN/NOTIFY */
+
+// NotificationsChannel - postgres channel the notifications are published on
+const NotificationsChannel = "notifications"
+
+var notificationSortKeys = map[string][]sortKey{
+	models.SortNewest: {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+const notificationColumns = `id, user_id, kind, COALESCE(actor_id, 0) AS actor_id, bookmark_id, details, read_at IS NOT NULL AS is_read, created_at`
+
+// notificationListedColumns - the notificationColumns, as named in a listing
+const notificationListedColumns = `id, user_id, kind, actor_id, bookmark_id, details, is_read, created_at`
+
+// scanNotification - a notification of the notificationColumns
+func scanNotification(scan func(dest ...interface{}) error) (*models.Notification, error) {
+	var n models.Notification
+	var details []byte
+	err := scan(&n.ID, &n.UserID, &n.Kind, &n.ActorID, &n.BookmarkID, &details, &n.Read, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(details, &n.Details)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// notificationDetails - details of n, as stored
+func notificationDetails(n *models.Notification) ([]byte, error) {
+	if n.Details == nil {
+		return []byte(`{}`), nil
+	}
+	return json.Marshal(n.Details)
+}
+
+// InsertNotification - notify n.UserID, filling the ID and creation time of n
+func (m *PostgresDBRepo) InsertNotification(n *models.Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	details, err := notificationDetails(n)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO notifications (user_id, kind, actor_id, bookmark_id, details, created_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
+		RETURNING id, created_at`
+	return m.DB.QueryRowContext(ctx, stmt, n.UserID, n.Kind, n.ActorID, n.BookmarkID, details, time.Now()).Scan(&n.ID, &n.CreatedAt)
+}
+
+// NotifyFollowers - notify n to the followers of a project, directly or through its category, but its actor;
+// returns the notifications created, one per follower
+func (m *PostgresDBRepo) NotifyFollowers(n *models.Notification, projectID int) ([]*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	details, err := notificationDetails(n)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO notifications (user_id, kind, actor_id, bookmark_id, details, created_at)
+		SELECT f.user_id, $2, NULLIF($3, 0), $4, $5, $6 FROM (
+			SELECT user_id FROM project_follows WHERE project_id = $1
+			UNION
+			SELECT cf.user_id FROM category_follows cf JOIN projects p ON p.category_id = cf.category_id WHERE p.id = $1
+		) f
+		WHERE f.user_id <> $3
+		RETURNING ` + notificationColumns
+
+	rows, err := m.DB.QueryContext(ctx, stmt, projectID, n.Kind, n.ActorID, n.BookmarkID, details, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*models.Notification
+	for rows.Next() {
+		created, err := scanNotification(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, created)
+	}
+	return notifications, rows.Err()
+}
+
+// GetNotifications - paginated notifications of a user, newest first, only the unread ones if unreadOnly
+func (m *PostgresDBRepo) GetNotifications(userID int, unreadOnly bool, opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	base.where("user_id = ?", userID)
+	if unreadOnly {
+		base.conds = append(base.conds, "read_at IS NULL")
+	}
+	if !opts.From.IsZero() {
+		base.where("created_at >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		base.where("created_at < ?", opts.To)
+	}
+
+	listed := `SELECT ` + notificationColumns + ` FROM notifications ` + base.clause()
+
+	notifications := []*models.Notification{}
+	page, err := m.listNewest(listed, base, notificationListedColumns, notificationSortKeys, opts, func(rows *sql.Rows) (time.Time, int, error) {
+		n, err := scanNotification(rows.Scan)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		notifications = append(notifications, n)
+		return n.CreatedAt, n.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = notifications
+	return page, nil
+}
+
+// GetNotificationsAfter - notifications of a user with an id above afterID, oldest first: what a client
+// reconnecting after afterID missed
+func (m *PostgresDBRepo) GetNotificationsAfter(userID, afterID, limit int) ([]*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT ` + notificationColumns + ` FROM notifications WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3`
+	rows, err := m.DB.QueryContext(ctx, query, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*models.Notification
+	for rows.Next() {
+		n, err := scanNotification(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationsRead - mark the notifications ids of a user as read (or unread), all of them when ids is empty;
+// returns the number of notifications changed
+func (m *PostgresDBRepo) MarkNotificationsRead(userID int, ids []int, read bool) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var readAt interface{}
+	if read {
+		readAt = time.Now()
+	}
+	stmt := `UPDATE notifications SET read_at = $1 WHERE user_id = $2 AND (read_at IS NULL) = $3`
+	args := []interface{}{readAt, userID, read}
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, id := range ids {
+			args = append(args, id)
+			params[i] = "$" + strconv.Itoa(len(args))
+		}
+		stmt += ` AND id IN (` + strings.Join(params, ", ") + `)`
+	}
+
+	res, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PublishNotification - publish payload on the notifications channel, to the listeners of every instance
+func (m *PostgresDBRepo) PublishNotification(payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `SELECT pg_notify($1, $2)`, NotificationsChannel, string(payload))
+	return err
+}
+
+// ListenNotifications - call handle with the payload of every notification published, on a connection of its own,
+// until ctx is done or the connection fails
+func (m *PostgresDBRepo) ListenNotifications(ctx context.Context, handle func(payload string)) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("listening to notifications needs the pgx driver")
+		}
+		_, err := c.Conn().Exec(ctx, "LISTEN "+NotificationsChannel)
+		if err != nil {
+			return err
+		}
+		for {
+			n, err := c.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			handle(n.Payload)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMarkNotificationsRead - some or all of the notifications of a user, read or back to unread
+func TestMarkNotificationsRead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectExec(`UPDATE notifications SET read_at = \$1 WHERE user_id = \$2 AND \(read_at IS NULL\) = \$3 AND id IN \(\$4, \$5\)`).
+		WithArgs(sqlmock.AnyArg(), 1, true, 7, 9).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE notifications SET read_at = \$1 WHERE user_id = \$2 AND \(read_at IS NULL\) = \$3$`).
+		WithArgs(nil, 1, false).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+
+	n, err := repo.MarkNotificationsRead(1, []int{7, 9}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = repo.MarkNotificationsRead(1, nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestGetNotifications - the unread notifications of a user, a page after the cursor, with the cursor of the next one
+func TestGetNotifications(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	created := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	cursor := models.Cursor{Sort: models.SortNewest, Values: []string{"2026-10-18T10:00:00", "12"}}.Encode()
+	columns := []string{"id", "user_id", "kind", "actor_id", "bookmark_id", "details", "is_read", "created_at"}
+
+	mock.ExpectQuery(`WITH listed AS \(SELECT .* FROM notifications WHERE user_id = \$1 AND read_at IS NULL\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectQuery(`FROM listed WHERE \(created_at, id\) < \(\$2::timestamp, \$3::integer\) ORDER BY created_at DESC, id DESC LIMIT 3 OFFSET 0`).
+		WithArgs(7, "2026-10-18T10:00:00", "12").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(11, 7, models.NotifyBookmarkCommented, 8, 3, []byte(`{"comment_id":4}`), false, created).
+			AddRow(10, 7, models.NotifyBookmarkCommented, 9, 3, []byte(`{}`), false, created).
+			AddRow(9, 7, models.NotifyBookmarkCommented, 8, 2, []byte(`{}`), false, created))
+
+	page, err := repo.GetNotifications(7, true, models.ListOptions{Limit: 2, Cursor: cursor})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, page.Total)
+	notifications := page.Items.([]*models.Notification)
+	if assert.Len(t, notifications, 2) {
+		assert.Equal(t, 11, notifications[0].ID)
+		assert.Equal(t, float64(4), notifications[0].Details["comment_id"])
+	}
+	next, err := models.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2026-10-18T09:30:00", "10"}, next.Values)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
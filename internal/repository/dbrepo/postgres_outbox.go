@@ -0,0 +1,130 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"time"
+)
+
+/* Outbox functions - emails are stored first, then delivered by a worker which retries the failures */
+
+// enqueueEmailStmt - insert of an email in the outbox, returning its id
+const enqueueEmailStmt = `INSERT INTO email_outbox (recipient, subject, html_body, text_body, unsubscribe_url, status, next_attempt_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+// EnqueueEmail - add an email to the outbox, due right away
+func (m *PostgresDBRepo) EnqueueEmail(e *models.OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, enqueueEmailStmt, e.Recipient, e.Subject, e.HTMLBody, e.TextBody, e.UnsubscribeURL, models.EmailPending, time.Now()).Scan(&e.ID)
+}
+
+// ClaimDueEmails - up to limit pending emails which are due, oldest first
+// They are leased until the given time: should the worker die while sending them, they are due again then.
+// Locked rows are skipped, so that several workers never claim the same email
+func (m *PostgresDBRepo) ClaimDueEmails(limit int, leaseUntil time.Time) ([]*models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `UPDATE email_outbox SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, html_body, text_body, unsubscribe_url, status, attempts, created_at`
+	rows, err := m.DB.QueryContext(ctx, query, leaseUntil, models.EmailPending, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*models.OutboxEmail
+	for rows.Next() {
+		e := models.OutboxEmail{NextAttemptAt: leaseUntil}
+		err := rows.Scan(&e.ID, &e.Recipient, &e.Subject, &e.HTMLBody, &e.TextBody, &e.UnsubscribeURL, &e.Status, &e.Attempts, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &e)
+	}
+	return emails, rows.Err()
+}
+
+// MarkEmailSent - record the delivery of an email claimed with the lease leaseUntil
+// The bodies are cleared: they carry the live tokens of the links, which have no business staying in the table.
+// Returns sql.ErrNoRows when the lease was lost, the email being claimed again since
+func (m *PostgresDBRepo) MarkEmailSent(id int, leaseUntil time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE email_outbox SET status = $1, attempts = attempts + 1, sent_at = $2, last_error = NULL,
+		html_body = '', text_body = '', unsubscribe_url = '' WHERE id = $3 AND status = $4 AND next_attempt_at = $5`
+	res, err := m.DB.ExecContext(ctx, stmt, models.EmailSent, time.Now(), id, models.EmailPending, leaseUntil)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// MarkEmailFailed - record a failed delivery of an email claimed with the lease leaseUntil: the email is due
+// again at nextAttempt, or dead when given up on. Returns sql.ErrNoRows when the lease was lost.
+// A dead email keeps its recipient, subject and error for the admins, its bodies (and their tokens) are cleared
+func (m *PostgresDBRepo) MarkEmailFailed(id int, leaseUntil time.Time, lastError string, nextAttempt time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE email_outbox SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $4 AND status = $5 AND next_attempt_at = $6`
+	status := models.EmailPending
+	if dead {
+		status = models.EmailDead
+		stmt = `UPDATE email_outbox SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3,
+			html_body = '', text_body = '', unsubscribe_url = '' WHERE id = $4 AND status = $5 AND next_attempt_at = $6`
+	}
+	res, err := m.DB.ExecContext(ctx, stmt, status, lastError, nextAttempt, id, models.EmailPending, leaseUntil)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// GetDeadEmails - the emails given up on, newest first
+func (m *PostgresDBRepo) GetDeadEmails(limit int) ([]*models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT id, recipient, subject, status, attempts, next_attempt_at, COALESCE(last_error, ''), created_at
+		FROM email_outbox WHERE status = $1 ORDER BY id DESC LIMIT $2`
+	rows, err := m.DB.QueryContext(ctx, query, models.EmailDead, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*models.OutboxEmail
+	for rows.Next() {
+		var e models.OutboxEmail
+		err := rows.Scan(&e.ID, &e.Recipient, &e.Subject, &e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &e)
+	}
+	return emails, rows.Err()
+}
+
+// PurgeSentEmails - delete the emails sent before the given time; returns the number of rows deleted
+func (m *PostgresDBRepo) PurgeSentEmails(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM email_outbox WHERE status = $1 AND sent_at < $2`, models.EmailSent, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
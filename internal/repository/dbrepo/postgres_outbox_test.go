@@ -0,0 +1,120 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClaimDueEmails - due emails are leased, skipping the ones another worker holds
+func TestClaimDueEmails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	lease := time.Now().Add(5 * time.Minute)
+
+	mock.ExpectQuery(`UPDATE email_outbox SET next_attempt_at = \$1\s+WHERE id IN \(.*FOR UPDATE SKIP LOCKED\s*\)`).
+		WithArgs(lease, models.EmailPending, sqlmock.AnyArg(), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "recipient", "subject", "html_body", "text_body", "unsubscribe_url", "status", "attempts", "created_at"}).
+			AddRow(3, "ned@example.com", "hello", "<p>hello</p>", "hello", "https://api.example.com/digest/unsubscribe?token=t", models.EmailPending, 2, time.Now()))
+
+	emails, err := repo.ClaimDueEmails(20, lease)
+
+	assert.NoError(t, err)
+	if assert.Len(t, emails, 1) {
+		assert.Equal(t, 3, emails[0].ID)
+		assert.Equal(t, 2, emails[0].Attempts)
+		assert.Equal(t, lease, emails[0].NextAttemptAt)
+		assert.Equal(t, "https://api.example.com/digest/unsubscribe?token=t", emails[0].UnsubscribeURL)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestMarkEmailFailed - a failure reschedules the email, the last one makes it dead
+func TestMarkEmailFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	lease := time.Now()
+	next := time.Now().Add(time.Minute)
+
+	mock.ExpectExec(`UPDATE email_outbox SET status = \$1, attempts = attempts \+ 1`).
+		WithArgs(models.EmailPending, "timeout", next, 3, models.EmailPending, lease).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE email_outbox SET status = \$1, attempts = attempts \+ 1, .*html_body = '', text_body = '', unsubscribe_url = ''`).
+		WithArgs(models.EmailDead, "timeout", next, 3, models.EmailPending, lease).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.MarkEmailFailed(3, lease, "timeout", next, false))
+	assert.NoError(t, repo.MarkEmailFailed(3, lease, "timeout", next, true))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestMarkEmailSent - a sent email loses its bodies, and the tokens in them; only under the lease it was claimed with
+func TestMarkEmailSent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	lease := time.Now()
+
+	mock.ExpectExec(`UPDATE email_outbox SET status = \$1, .*html_body = '', text_body = '', unsubscribe_url = ''\s+WHERE id = \$3 AND status = \$4 AND next_attempt_at = \$5`).
+		WithArgs(models.EmailSent, sqlmock.AnyArg(), 3, models.EmailPending, lease).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// claimed again by another worker since
+	mock.ExpectExec(`UPDATE email_outbox SET status = \$1`).
+		WithArgs(models.EmailSent, sqlmock.AnyArg(), 3, models.EmailPending, lease).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, repo.MarkEmailSent(3, lease))
+	assert.ErrorIs(t, repo.MarkEmailSent(3, lease), sql.ErrNoRows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestPurgeSentEmails - only the emails sent before the retention limit are deleted
+func TestPurgeSentEmails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	before := time.Now().Add(-24 * time.Hour)
+
+	mock.ExpectExec(`DELETE FROM email_outbox WHERE status = \$1 AND sent_at < \$2`).
+		WithArgs(models.EmailSent, before).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	n, err := repo.PurgeSentEmails(before)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
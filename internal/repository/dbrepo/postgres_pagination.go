@@ -0,0 +1,272 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestamp layout used to store created_at in a cursor (columns are 'timestamp without time zone')
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+// sortKey - a column of a listing ORDER BY, along with the postgres type its cursor value is cast to
+type sortKey struct {
+	column string
+	cast   string
+}
+
+// Every key is sorted descending, so that a keyset page boils down to a single row comparison
+var bookmarkSortKeys = map[string][]sortKey{
+	models.SortScore:     {{"average_rating", "numeric"}, {"rating_count", "bigint"}, {"id", "integer"}},
+	models.SortNewest:    {{"created_at", "timestamp"}, {"id", "integer"}},
+	models.SortMostRated: {{"rating_count", "bigint"}, {"average_rating", "numeric"}, {"id", "integer"}},
+}
+
+var userSortKeys = map[string][]sortKey{
+	models.SortNewest: {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+// pageQuery - accumulates the WHERE conditions and positional arguments of a listing
+type pageQuery struct {
+	conds []string
+	args  []interface{}
+}
+
+// where - add a condition, '?' being replaced by the next positional argument
+func (q *pageQuery) where(cond string, arg interface{}) {
+	q.args = append(q.args, arg)
+	q.conds = append(q.conds, strings.Replace(cond, "?", "$"+strconv.Itoa(len(q.args)), 1))
+}
+
+// clause - the WHERE clause (or nothing when there is no condition)
+func (q *pageQuery) clause() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(q.conds, " AND ")
+}
+
+// normalizeListOptions - apply defaults and bounds to opts, and check the sort order is supported
+func normalizeListOptions(opts *models.ListOptions, keys map[string][]sortKey, defaultSort string) error {
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+	if _, ok := keys[opts.Sort]; !ok {
+		return fmt.Errorf("%w: %q", models.ErrUnsupportedSort, opts.Sort)
+	}
+	opts.Limit = models.PageLimit(opts.Limit)
+	if opts.Offset < 0 || opts.Cursor != "" {
+		opts.Offset = 0
+	}
+	return nil
+}
+
+// keyset - add to q the row comparison positioning the listing right after the cursor
+func (q *pageQuery) keyset(cursor string, sort string, keys []sortKey) error {
+	c, err := models.DecodeCursor(cursor)
+	if err != nil {
+		return err
+	}
+	if c.Sort != sort || len(c.Values) != len(keys) {
+		return models.ErrInvalidCursor
+	}
+
+	cols := make([]string, len(keys))
+	params := make([]string, len(keys))
+	for i, k := range keys {
+		q.args = append(q.args, c.Values[i])
+		cols[i] = k.column
+		params[i] = fmt.Sprintf("$%d::%s", len(q.args), k.cast)
+	}
+	q.conds = append(q.conds, fmt.Sprintf("(%s) < (%s)", strings.Join(cols, ", "), strings.Join(params, ", ")))
+	return nil
+}
+
+// orderBy - ORDER BY clause matching the sort keys
+func orderBy(keys []sortKey) string {
+	cols := make([]string, len(keys))
+	for i, k := range keys {
+		cols[i] = k.column + " DESC"
+	}
+	return "ORDER BY " + strings.Join(cols, ", ")
+}
+
+// listBookmarks - shared listing of bookmarks (with their rating aggregate), filtered, sorted and paginated by opts
+// base holds the conditions specific to the caller (category, project, user...)
+func (m *PostgresDBRepo) listBookmarks(base pageQuery, opts models.ListOptions) (*models.Page, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := normalizeListOptions(&opts, bookmarkSortKeys, models.SortScore)
+	if err != nil {
+		return nil, err
+	}
+	keys := bookmarkSortKeys[opts.Sort]
+
+	if opts.Type != "" {
+		base.where("b.type = ?", opts.Type)
+	}
+	if opts.SubmitterID != 0 {
+		base.where("b.user_id = ?", opts.SubmitterID)
+	}
+	if !opts.From.IsZero() {
+		base.where("b.created_at >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		base.where("b.created_at < ?", opts.To)
+	}
+
+	// The aggregate is computed once in a CTE, the keyset is then applied on its output columns
+	// average is rounded so that its value survives the round trip through a cursor
+	listed := fmt.Sprintf(`WITH listed AS (
+		SELECT b.id, b.url, COALESCE(b.type, '') AS type, COALESCE(b.description, '') AS description,
+			b.user_id, b.project_id, p.name AS project_name,
+			COALESCE(b.created_at, to_timestamp(0)::timestamp) AS created_at,
+			COALESCE(b.updated_at, to_timestamp(0)::timestamp) AS updated_at,
+			ROUND(COALESCE(AVG(r.rating), 0), 4) AS average_rating, COUNT(r.id) AS rating_count
+		FROM bookmarks b
+		JOIN projects p ON b.project_id = p.id
+		JOIN categories c ON p.category_id = c.id
+		LEFT JOIN ratings r ON r.bookmark_id = b.id
+		%s
+		GROUP BY b.id, p.name
+	)`, base.clause())
+
+	page := &models.Page{Limit: opts.Limit, Offset: opts.Offset}
+	err = m.DB.QueryRowContext(ctx, listed+` SELECT COUNT(*) FROM listed`, base.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	outer := pageQuery{args: base.args}
+	if opts.Cursor != "" {
+		err = outer.keyset(opts.Cursor, opts.Sort, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// fetch one extra row to know whether there is a next page
+	query := fmt.Sprintf(`%s SELECT id, url, type, description, user_id, project_id, project_name, created_at, updated_at, average_rating, rating_count
+		FROM listed %s %s LIMIT %d OFFSET %d`, listed, outer.clause(), orderBy(keys), opts.Limit+1, opts.Offset)
+
+	rows, err := m.DB.QueryContext(ctx, query, outer.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []*models.Bookmark{}
+	for rows.Next() {
+		var b models.Bookmark
+		err := rows.Scan(
+			&b.ID,
+			&b.Url,
+			&b.Type,
+			&b.Description,
+			&b.UserID,
+			&b.ProjectID,
+			&b.ProjectName,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+			&b.AverageRating,
+			&b.RatingCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(bookmarks) > opts.Limit {
+		bookmarks = bookmarks[:opts.Limit]
+		page.NextCursor = bookmarkCursor(opts.Sort, bookmarks[len(bookmarks)-1])
+	}
+	page.Items = bookmarks
+	return page, nil
+}
+
+// bookmarkCursor - cursor pointing right after b for the given sort order
+func bookmarkCursor(sort string, b *models.Bookmark) string {
+	var values []string
+	for _, k := range bookmarkSortKeys[sort] {
+		switch k.column {
+		case "average_rating":
+			values = append(values, strconv.FormatFloat(b.AverageRating, 'f', -1, 64))
+		case "rating_count":
+			values = append(values, strconv.Itoa(b.RatingCount))
+		case "created_at":
+			values = append(values, b.CreatedAt.Format(cursorTimeLayout))
+		case "id":
+			values = append(values, strconv.Itoa(b.ID))
+		}
+	}
+	return models.Cursor{Sort: sort, Values: values}.Encode()
+}
+
+// listNewest - shared listing sorted newest first (a timestamp, then the id), paginated by opts
+// listed selects the rows, filtered by the conditions of base; scan reads the columns of a row in the rows, keeps
+// it, and returns its sort key values. Only the rows of the page are scanned, the extra one telling there is a next
+func (m *PostgresDBRepo) listNewest(listed string, base pageQuery, columns string, sortKeys map[string][]sortKey,
+	opts models.ListOptions, scan func(rows *sql.Rows) (time.Time, int, error)) (*models.Page, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := normalizeListOptions(&opts, sortKeys, models.SortNewest)
+	if err != nil {
+		return nil, err
+	}
+	keys := sortKeys[opts.Sort]
+	listed = "WITH listed AS (" + listed + ")"
+
+	page := &models.Page{Limit: opts.Limit, Offset: opts.Offset}
+	err = m.DB.QueryRowContext(ctx, listed+` SELECT COUNT(*) FROM listed`, base.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	outer := pageQuery{args: base.args}
+	if opts.Cursor != "" {
+		err = outer.keyset(opts.Cursor, opts.Sort, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// fetch one extra row to know whether there is a next page
+	query := fmt.Sprintf(`%s SELECT %s FROM listed %s %s LIMIT %d OFFSET %d`,
+		listed, columns, outer.clause(), orderBy(keys), opts.Limit+1, opts.Offset)
+
+	rows, err := m.DB.QueryContext(ctx, query, outer.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var last time.Time
+	var lastID int
+	for n := 0; rows.Next(); n++ {
+		if n == opts.Limit {
+			page.NextCursor = models.Cursor{
+				Sort:   opts.Sort,
+				Values: []string{last.Format(cursorTimeLayout), strconv.Itoa(lastID)},
+			}.Encode()
+			break
+		}
+		last, lastID, err = scan(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
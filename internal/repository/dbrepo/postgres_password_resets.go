@@ -0,0 +1,63 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* Password reset functions - tokens are stored hashed, and can be used once */
+
+// CreatePasswordReset - store the hash of a password reset token issued to a user
+func (m *PostgresDBRepo) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expiresAt)
+	return err
+}
+
+// ResetPassword - consume a valid reset token and set the new password of its user, returning its id and email
+// Proving the email unlocks the account; every other pending reset token of the user is consumed as well.
+// Returns sql.ErrNoRows for an unknown, expired or already used token
+func (m *PostgresDBRepo) ResetPassword(tokenHash, newPassword string) (int, string, error) {
+	// hashed first: bcrypt is slow on purpose, it must not eat the time given to the database
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	if err != nil {
+		return 0, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	query := `SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, tokenHash, now).Scan(&userID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var email string
+	stmt := `UPDATE users SET password_hash = $1, locked_until = NULL, updated_at = $2 WHERE id = $3 RETURNING email`
+	err = tx.QueryRowContext(ctx, stmt, hashedPassword, now, userID).Scan(&email)
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`, now, userID)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, email, tx.Commit()
+}
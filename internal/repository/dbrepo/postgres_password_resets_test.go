@@ -0,0 +1,68 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResetPassword - a valid token updates the password, unlocks the account and consumes every pending token
+// of the user
+func TestResetPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT user_id FROM password_resets`).
+		WithArgs("hash", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(75))
+	mock.ExpectQuery(`UPDATE users SET password_hash = \$1, locked_until = NULL, updated_at = \$2 WHERE id = \$3 RETURNING email`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 75).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("lisa@example.com"))
+	mock.ExpectExec(`UPDATE password_resets SET used_at = \$1 WHERE user_id = \$2 AND used_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 75).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	userID, email, err := repo.ResetPassword("hash", "correct horse battery staple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 75, userID)
+	assert.Equal(t, "lisa@example.com", email)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestResetPasswordUsedToken - an unknown, expired or used token changes nothing
+func TestResetPasswordUsedToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT user_id FROM password_resets`).
+		WithArgs("hash", sqlmock.AnyArg()).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, _, err = repo.ResetPassword("hash", "correct horse battery staple")
+
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"time"
+)
+
+/* Ratings functions - a user rates a bookmark from 1 to 5, once (re-rating overwrites) */
+
+// A re-rated bookmark moves back to the top of the ratings of its user
+var ratingSortKeys = map[string][]sortKey{
+	models.SortNewest: {{"updated_at", "timestamp"}, {"id", "integer"}},
+}
+
+// UpsertRating - rate a bookmark, or overwrite the rating the user already gave to it
+func (m *PostgresDBRepo) UpsertRating(userID, bookmarkID, rating int) (*models.Rating, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	r := models.Rating{
+		UserID:     userID,
+		BookmarkID: bookmarkID,
+		Rating:     rating,
+	}
+
+	stmt := `INSERT INTO ratings (user_id, bookmark_id, rating, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (user_id, bookmark_id) DO UPDATE SET rating = EXCLUDED.rating, updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at, updated_at`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt, userID, bookmarkID, rating, time.Now()).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// re-rating replaces the activity of the previous rating, the feed keeps the latest one only
+	_, err = tx.ExecContext(ctx, `DELETE FROM activities WHERE actor_id = $1 AND bookmark_id = $2 AND kind = $3`,
+		userID, bookmarkID, models.ActivityBookmarkRate)
+	if err != nil {
+		return nil, err
+	}
+	err = recordActivity(ctx, tx, userID, models.ActivityBookmarkRate, bookmarkID, map[string]interface{}{"rating": rating})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DeleteRating - remove the rating a user gave to a bookmark
+func (m *PostgresDBRepo) DeleteRating(userID, bookmarkID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM ratings WHERE user_id = $1 AND bookmark_id = $2`, userID, bookmarkID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err != nil {
+		return err
+	}
+
+	// a withdrawn rating leaves the feeds
+	_, err = tx.ExecContext(ctx, `DELETE FROM activities WHERE actor_id = $1 AND bookmark_id = $2 AND kind = $3`,
+		userID, bookmarkID, models.ActivityBookmarkRate)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRatingSummary - average and number of ratings of a bookmark
+func (m *PostgresDBRepo) GetRatingSummary(bookmarkID int) (*models.RatingSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	s := models.RatingSummary{BookmarkID: bookmarkID}
+
+	query := `SELECT COALESCE(AVG(rating), 0), COUNT(id) FROM ratings WHERE bookmark_id = $1`
+	err := m.DB.QueryRowContext(ctx, query, bookmarkID).Scan(&s.Average, &s.Count)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetRatingsByUser - the ratings given by a user, along with the rated bookmark (most recently rated first)
+func (m *PostgresDBRepo) GetRatingsByUser(userID int, opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	base.where("r.user_id = ?", userID)
+
+	listed := `SELECT r.id, r.user_id, r.bookmark_id, r.rating,
+			COALESCE(r.created_at, to_timestamp(0)::timestamp) AS created_at,
+			COALESCE(r.updated_at, r.created_at, to_timestamp(0)::timestamp) AS updated_at,
+			b.url, COALESCE(b.type, '') AS type, COALESCE(b.description, '') AS description,
+			b.user_id AS bookmark_user_id, b.project_id
+		FROM ratings r
+		JOIN bookmarks b ON r.bookmark_id = b.id
+		` + base.clause()
+	columns := `id, user_id, bookmark_id, rating, created_at, updated_at, url, type, description, bookmark_user_id, project_id`
+
+	ratings := []*models.Rating{}
+	page, err := m.listNewest(listed, base, columns, ratingSortKeys, opts, func(rows *sql.Rows) (time.Time, int, error) {
+		var r models.Rating
+		var b models.Bookmark
+		err := rows.Scan(
+			&r.ID,
+			&r.UserID,
+			&r.BookmarkID,
+			&r.Rating,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&b.Url,
+			&b.Type,
+			&b.Description,
+			&b.UserID,
+			&b.ProjectID,
+		)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		b.ID = r.BookmarkID
+		r.Bookmark = &b
+		ratings = append(ratings, &r)
+		return r.UpdatedAt, r.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = ratings
+	return page, nil
+}
@@ -0,0 +1,104 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpsertRating - rating twice the same bookmark goes through the ON CONFLICT clause, and replaces the activity
+// of the previous rating
+func TestUpsertRating(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO ratings \(user_id, bookmark_id, rating, created_at, updated_at\)
+		VALUES \(\$1, \$2, \$3, \$4, \$4\)
+		ON CONFLICT \(user_id, bookmark_id\) DO UPDATE`).
+		WithArgs(7, 12, 4, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(3, now, now))
+	mock.ExpectExec(`DELETE FROM activities WHERE actor_id = \$1 AND bookmark_id = \$2 AND kind = \$3`).
+		WithArgs(7, 12, models.ActivityBookmarkRate).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO activities \(actor_id, kind, bookmark_id, details, created_at\)`).
+		WithArgs(7, models.ActivityBookmarkRate, 12, []byte(`{"rating":4}`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	rating, err := repo.UpsertRating(7, 12, 4)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rating.ID)
+	assert.Equal(t, 7, rating.UserID)
+	assert.Equal(t, 12, rating.BookmarkID)
+	assert.Equal(t, 4, rating.Rating)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestGetRatingsByUser - the ratings of a user with their bookmark, most recently rated first, as an empty list
+// rather than null when there is none
+func TestGetRatingsByUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	rated := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	columns := []string{"id", "user_id", "bookmark_id", "rating", "created_at", "updated_at", "url", "type", "description", "bookmark_user_id", "project_id"}
+
+	mock.ExpectQuery(`WITH listed AS \(.*FROM ratings r.*WHERE r.user_id = \$1\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`JOIN bookmarks b ON r.bookmark_id = b.id.*ORDER BY updated_at DESC, id DESC LIMIT 3 OFFSET 0`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(9, 7, 12, 4, rated, rated, "https://go.dev", "doc", "Go", 8, 2).
+			AddRow(5, 7, 13, 2, rated, rated, "https://gobyexample.com", "", "", 8, 2).
+			AddRow(4, 7, 14, 5, rated, rated, "https://pkg.go.dev", "", "", 9, 2))
+
+	page, err := repo.GetRatingsByUser(7, models.ListOptions{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	ratings := page.Items.([]*models.Rating)
+	if assert.Len(t, ratings, 2) {
+		assert.Equal(t, 12, ratings[0].Bookmark.ID)
+		assert.Equal(t, "https://go.dev", ratings[0].Bookmark.Url)
+	}
+	next, err := models.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2026-10-18T09:30:00", "5"}, next.Values)
+
+	mock.ExpectQuery(`WITH listed AS \(.*FROM ratings r.*WHERE r.user_id = \$1\) SELECT COUNT\(\*\) FROM listed`).
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM listed\s+ORDER BY updated_at DESC, id DESC`).
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	page, err = repo.GetRatingsByUser(8, models.ListOptions{})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, page.Items)
+	assert.Empty(t, page.Items)
+	assert.Empty(t, page.NextCursor)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
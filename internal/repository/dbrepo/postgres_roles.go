@@ -0,0 +1,157 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+/* Roles, audit log and catalog functions */
+
+var auditSortKeys = map[string][]sortKey{
+	models.SortNewest: {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+// GrantRole - grant role to a user (no-op when already held); granting admin also sets users.is_admin
+func (m *PostgresDBRepo) GrantRole(userID int, role string, grantedBy int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO user_roles (user_id, role, granted_by, granted_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, role) DO NOTHING`
+	_, err = tx.ExecContext(ctx, stmt, userID, role, grantedBy, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if role == models.RoleAdmin {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET is_admin = true, updated_at = $1 WHERE id = $2`, time.Now(), userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RevokeRole - take role back from a user; returns sql.ErrNoRows when the user does not hold it
+func (m *PostgresDBRepo) RevokeRole(userID int, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res); err != nil {
+		return err
+	}
+
+	if role == models.RoleAdmin {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET is_admin = false, updated_at = $1 WHERE id = $2`, time.Now(), userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// InsertAuditEntry - record a privileged action, filling the entry ID and date
+func (m *PostgresDBRepo) InsertAuditEntry(e *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+	if e.Details == nil {
+		details = []byte("{}")
+	}
+
+	stmt := `INSERT INTO audit_log (actor_id, action, target_type, target_id, details) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	return m.DB.QueryRowContext(ctx, stmt, e.ActorID, e.Action, e.TargetType, e.TargetID, details).Scan(&e.ID, &e.CreatedAt)
+}
+
+// GetAuditLog - paginated audit log, newest first, filterable on the date
+func (m *PostgresDBRepo) GetAuditLog(opts models.ListOptions) (*models.Page, error) {
+	var base pageQuery
+	if !opts.From.IsZero() {
+		base.where("created_at >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		base.where("created_at < ?", opts.To)
+	}
+
+	listed := `SELECT id, COALESCE(actor_id, 0) AS actor_id, action, target_type, target_id, details, created_at
+		FROM audit_log ` + base.clause()
+
+	entries := []*models.AuditEntry{}
+	page, err := m.listNewest(listed, base, `id, actor_id, action, target_type, target_id, details, created_at`, auditSortKeys, opts,
+		func(rows *sql.Rows) (time.Time, int, error) {
+			var e models.AuditEntry
+			var details []byte
+			err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &details, &e.CreatedAt)
+			if err != nil {
+				return time.Time{}, 0, err
+			}
+			err = json.Unmarshal(details, &e.Details)
+			if err != nil {
+				return time.Time{}, 0, err
+			}
+			entries = append(entries, &e)
+			return e.CreatedAt, e.ID, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	page.Items = entries
+	return page, nil
+}
+
+// InsertCategory - create a category; returns models.ErrAlreadyExists when the name is taken
+func (m *PostgresDBRepo) InsertCategory(name string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var id int
+	stmt := `INSERT INTO categories (category) VALUES ($1) ON CONFLICT (category) DO NOTHING RETURNING id`
+	err := m.DB.QueryRowContext(ctx, stmt, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrAlreadyExists
+	}
+	return id, err
+}
+
+// InsertProject - create a project in an existing category, filling its ID and category ID
+// Returns sql.ErrNoRows for an unknown category, models.ErrAlreadyExists when the name is taken
+func (m *PostgresDBRepo) InsertProject(p *models.Project) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, `SELECT id FROM categories WHERE category = $1`, p.Category).Scan(&p.CategoryID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO projects (name, category_id) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING RETURNING id`
+	err = m.DB.QueryRowContext(ctx, stmt, p.Name, p.CategoryID).Scan(&p.ID)
+	if err == sql.ErrNoRows {
+		return models.ErrAlreadyExists
+	}
+	return err
+}
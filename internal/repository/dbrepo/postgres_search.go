@@ -0,0 +1,203 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// headlineOptions - how ts_headline highlights the matching words of a snippet
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2"
+
+// rank is a postgres real: its cursor value is cast back to real, so that it compares equal to itself
+var searchSortKeys = map[string][]sortKey{
+	models.SortRelevance: {{"rank", "real"}, {"id", "integer"}},
+	models.SortNewest:    {{"created_at", "timestamp"}, {"id", "integer"}},
+}
+
+// searchSort - sort order of a search and its keys, relevance by default
+func searchSort(sq models.SearchQuery) (string, []sortKey, error) {
+	sort := sq.Sort
+	if sort == "" {
+		sort = models.SortRelevance
+	}
+	keys, ok := searchSortKeys[sort]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %q", models.ErrUnsupportedSort, sort)
+	}
+	return sort, keys, nil
+}
+
+// searchCursor - cursor pointing right after h for the given sort order
+func searchCursor(sort string, h *models.SearchHit) string {
+	var values []string
+	for _, k := range searchSortKeys[sort] {
+		switch k.column {
+		case "rank":
+			values = append(values, strconv.FormatFloat(h.Rank, 'g', -1, 64))
+		case "created_at":
+			values = append(values, h.CreatedAt.Format(cursorTimeLayout))
+		case "id":
+			values = append(values, strconv.Itoa(h.ID))
+		}
+	}
+	return models.Cursor{Sort: sort, Values: values}.Encode()
+}
+
+// SearchBookmarks - full-text search over bookmarks (description, type, url, project name and category)
+// Facets are computed over every match of the text query, the category/project/type filters only narrow the hits
+func (m *PostgresDBRepo) SearchBookmarks(sq models.SearchQuery) (*models.SearchResults, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	sort, keys, err := searchSort(sq)
+	if err != nil {
+		return nil, err
+	}
+	res := &models.SearchResults{
+		Query:  sq.Query,
+		Limit:  models.PageLimit(sq.Limit),
+		Offset: max(sq.Offset, 0),
+		Hits:   []*models.SearchHit{},
+	}
+	if sq.Cursor != "" {
+		res.Offset = 0
+	}
+
+	// Facets and total - one row per (category, project) among the matches
+	facetQuery := `SELECT c.category, p.name, COALESCE(b.type, ''), COUNT(*)
+		FROM bookmarks b
+		JOIN projects p ON b.project_id = p.id
+		JOIN categories c ON p.category_id = c.id
+		WHERE b.search_vector @@ websearch_to_tsquery('english', $1)
+		GROUP BY c.category, p.name, b.type`
+
+	rows, err := m.DB.QueryContext(ctx, facetQuery, sq.Query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := map[string]int{}
+	projects := map[string]int{}
+	for rows.Next() {
+		var category, project, bkType string
+		var count int
+		err := rows.Scan(&category, &project, &bkType, &count)
+		if err != nil {
+			return nil, err
+		}
+		categories[category] += count
+		projects[project] += count
+		if matchesSearchFilters(sq, category, project, bkType) {
+			res.Total += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	res.Facets.Categories = sortedFacets(categories)
+	res.Facets.Projects = sortedFacets(projects)
+
+	// The page of hits, most relevant first
+	q := pageQuery{args: []interface{}{sq.Query}}
+	q.conds = append(q.conds, "b.search_vector @@ query")
+	if sq.Category != "" {
+		q.where("c.category = ?", sq.Category)
+	}
+	if sq.Project != "" {
+		q.where("p.name = ?", sq.Project)
+	}
+	if sq.Type != "" {
+		q.where("b.type = ?", sq.Type)
+	}
+
+	// The matches are ranked in a CTE, the keyset is then applied on its output columns
+	matched := fmt.Sprintf(`WITH matched AS (
+		SELECT b.id, b.url, COALESCE(b.type, '') AS type, COALESCE(b.description, '') AS description, b.user_id, b.project_id,
+			p.name AS project_name, c.category,
+			COALESCE(b.created_at, to_timestamp(0)::timestamp) AS created_at,
+			COALESCE(b.updated_at, to_timestamp(0)::timestamp) AS updated_at,
+			ts_rank(b.search_vector, query) AS rank
+		FROM bookmarks b
+		JOIN projects p ON b.project_id = p.id
+		JOIN categories c ON p.category_id = c.id
+		CROSS JOIN websearch_to_tsquery('english', $1) AS query
+		%s
+	)`, q.clause())
+
+	outer := pageQuery{args: q.args}
+	if sq.Cursor != "" {
+		err = outer.keyset(sq.Cursor, sort, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// snippets of the page only; one extra row tells whether there is a next page
+	hitsQuery := fmt.Sprintf(`%s SELECT id, url, type, description, user_id, project_id, project_name, category, created_at, updated_at, rank,
+			ts_headline('english', COALESCE(NULLIF(description, ''), url), websearch_to_tsquery('english', $1), '%s')
+		FROM matched %s %s LIMIT %d OFFSET %d`, matched, headlineOptions, outer.clause(), orderBy(keys), res.Limit+1, res.Offset)
+
+	hitRows, err := m.DB.QueryContext(ctx, hitsQuery, outer.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer hitRows.Close()
+
+	for hitRows.Next() {
+		var h models.SearchHit
+		err := hitRows.Scan(
+			&h.ID,
+			&h.Url,
+			&h.Type,
+			&h.Description,
+			&h.UserID,
+			&h.ProjectID,
+			&h.ProjectName,
+			&h.Category,
+			&h.CreatedAt,
+			&h.UpdatedAt,
+			&h.Rank,
+			&h.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res.Hits = append(res.Hits, &h)
+	}
+	if err := hitRows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(res.Hits) > res.Limit {
+		res.Hits = res.Hits[:res.Limit]
+		res.NextCursor = searchCursor(sort, res.Hits[len(res.Hits)-1])
+	}
+	return res, nil
+}
+
+// matchesSearchFilters - whether a match falls within the category/project/type filters of the search
+func matchesSearchFilters(sq models.SearchQuery, category, project, bkType string) bool {
+	return (sq.Category == "" || sq.Category == category) &&
+		(sq.Project == "" || sq.Project == project) &&
+		(sq.Type == "" || sq.Type == bkType)
+}
+
+// sortedFacets - facets by decreasing count, then alphabetically
+func sortedFacets(counts map[string]int) []models.Facet {
+	facets := make([]models.Facet, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, models.Facet{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
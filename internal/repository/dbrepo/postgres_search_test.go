@@ -0,0 +1,85 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var searchHitColumns = []string{"id", "url", "type", "description", "user_id", "project_id", "project_name", "category", "created_at", "updated_at", "rank", "snippet"}
+
+// TestSearchBookmarks - facets over every match, the filters only narrowing the hits and the total
+func TestSearchBookmarks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT c.category, p.name, COALESCE\(b.type, ''\), COUNT\(\*\).*WHERE b.search_vector @@ websearch_to_tsquery\('english', \$1\)`).
+		WithArgs("sockets").
+		WillReturnRows(sqlmock.NewRows([]string{"category", "name", "type", "count"}).
+			AddRow("system-linux", "sockets", "video", 2).
+			AddRow("system-linux", "sockets", "article", 1).
+			AddRow("system-algorithms", "huffman", "article", 1))
+	mock.ExpectQuery(`WITH matched AS \(.*ts_rank\(b.search_vector, query\) AS rank.*WHERE b.search_vector @@ query AND b.type = \$2.*\) SELECT .*ts_headline.* FROM matched ORDER BY rank DESC, id DESC LIMIT 2 OFFSET 0`).
+		WithArgs("sockets", "video").
+		WillReturnRows(sqlmock.NewRows(searchHitColumns).
+			AddRow(7, "https://example.com/a", "video", "sockets in C", 1, 3, "sockets", "system-linux", now, now, 0.5, "<mark>sockets</mark> in C").
+			AddRow(6, "https://example.com/b", "video", "more sockets", 1, 3, "sockets", "system-linux", now, now, 0.25, "more <mark>sockets</mark>"))
+
+	res, err := repo.SearchBookmarks(models.SearchQuery{Query: "sockets", Type: "video", Limit: 1})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, []models.Facet{{Value: "system-linux", Count: 3}, {Value: "system-algorithms", Count: 1}}, res.Facets.Categories)
+	assert.Equal(t, []models.Facet{{Value: "sockets", Count: 3}, {Value: "huffman", Count: 1}}, res.Facets.Projects)
+	if assert.Len(t, res.Hits, 1) {
+		assert.Equal(t, "<mark>sockets</mark> in C", res.Hits[0].Snippet)
+	}
+	c, err := models.DecodeCursor(res.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cursor{Sort: models.SortRelevance, Values: []string{"0.5", "7"}}, c)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+// TestSearchBookmarksKeyset - the next page starts right after the cursor, in the order of its sort
+func TestSearchBookmarksKeyset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	created := time.Date(2024, 6, 11, 10, 0, 0, 0, time.UTC)
+	cursor := models.Cursor{Sort: models.SortNewest, Values: []string{created.Format(cursorTimeLayout), "7"}}.Encode()
+
+	mock.ExpectQuery(`SELECT c.category`).WithArgs("sockets").
+		WillReturnRows(sqlmock.NewRows([]string{"category", "name", "type", "count"}))
+	mock.ExpectQuery(`FROM matched WHERE \(created_at, id\) < \(\$2::timestamp, \$3::integer\) ORDER BY created_at DESC, id DESC LIMIT 21 OFFSET 0`).
+		WithArgs("sockets", created.Format(cursorTimeLayout), "7").
+		WillReturnRows(sqlmock.NewRows(searchHitColumns))
+
+	res, err := repo.SearchBookmarks(models.SearchQuery{Query: "sockets", Sort: models.SortNewest, Cursor: cursor, Offset: 40})
+	assert.NoError(t, err)
+	assert.Zero(t, res.Offset, "the cursor replaces the offset")
+	assert.Empty(t, res.NextCursor)
+
+	_, err = repo.SearchBookmarks(models.SearchQuery{Query: "sockets", Sort: models.SortMostRated})
+	assert.True(t, errors.Is(err, models.ErrUnsupportedSort))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"time"
+)
+
+/* Sessions functions - a session is a login on a device, its id is the family_id of its tokens */
+
+// CreateSession - record a new login
+func (m *PostgresDBRepo) CreateSession(s *models.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6)`
+
+	now := time.Now()
+	_, err := m.DB.ExecContext(ctx, stmt, s.ID, s.UserID, s.UserAgent, s.IP, now, s.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	s.CreatedAt = now
+	s.LastSeenAt = now
+	return nil
+}
+
+// TouchSession - the session has just been used (token refresh): update its device info and push its expiry
+func (m *PostgresDBRepo) TouchSession(id, userAgent, ip string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET user_agent = $1, ip = $2, last_seen_at = $3, expires_at = $4 WHERE id = $5`
+	_, err := m.DB.ExecContext(ctx, stmt, userAgent, ip, time.Now(), expiresAt, id)
+	return err
+}
+
+// GetSessionByID - fetch a session, expired or not
+func (m *PostgresDBRepo) GetSessionByID(id string) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var s models.Session
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at FROM sessions WHERE id = $1`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&s.ID,
+		&s.UserID,
+		&s.UserAgent,
+		&s.IP,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSessionsByUser - active sessions of a user, most recently used first
+func (m *PostgresDBRepo) GetSessionsByUser(userID int) ([]*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY last_seen_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		var s models.Session
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.UserAgent,
+			&s.IP,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+			&s.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, rows.Err()
+}
+
+// PurgeExpiredSessions - delete expired sessions, token pairs and denylist entries; returns the number of rows deleted
+// A denylisted id can be forgotten once expired, the token it identifies is refused on its expiry anyway
+func (m *PostgresDBRepo) PurgeExpiredSessions() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	now := time.Now()
+	var total int64
+	for _, stmt := range []string{
+		`DELETE FROM tokens WHERE expiry_date < $1`,
+		`DELETE FROM sessions WHERE expires_at < $1`,
+		`DELETE FROM revoked_tokens WHERE expires_at < $1`,
+	} {
+		res, err := m.DB.ExecContext(ctx, stmt, now)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,32 @@
+package dbrepo
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPurgeExpiredSessions - expired tokens, sessions and denylist entries are all purged
+func TestPurgeExpiredSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+
+	mock.ExpectExec(`DELETE FROM tokens WHERE expiry_date < \$1`).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`DELETE FROM sessions WHERE expires_at < \$1`).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM revoked_tokens WHERE expires_at < \$1`).WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := repo.PurgeExpiredSessions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/* Tokens functions - one row per issued token pair, grouped in families of rotations */
+
+// tokenColumns - columns scanned by scanToken
+const tokenColumns = `id, user_id, family_id, access_token, refresh_token, COALESCE(access_jti, ''), COALESCE(refresh_jti, ''),
+	expiry_date, rotated_at, created_at`
+
+// scanToken - scan a row selected with tokenColumns
+func scanToken(row interface{ Scan(dest ...any) error }) (*models.Token, error) {
+	var t models.Token
+	var rotatedAt sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.AccessToken,
+		&t.RefreshToken,
+		&t.AccessJTI,
+		&t.RefreshJTI,
+		&t.ExpiryDate,
+		&rotatedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rotatedAt.Valid {
+		t.RotatedAt = &rotatedAt.Time
+	}
+	return &t, nil
+}
+
+// StoreTokenPairs - store a freshly issued token pair, ExpiryDate being the one of the refresh token
+func (m *PostgresDBRepo) StoreTokenPairs(t *models.Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO tokens (user_id, family_id, access_token, refresh_token, access_jti, refresh_jti, expiry_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	return m.DB.QueryRowContext(ctx, stmt, t.UserID, t.FamilyID, t.AccessToken, t.RefreshToken, t.AccessJTI, t.RefreshJTI, t.ExpiryDate).
+		Scan(&t.ID, &t.CreatedAt)
+}
+
+// GetTokenByRefreshToken - fetch the row a refresh token was issued with, rotated or not
+func (m *PostgresDBRepo) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT ` + tokenColumns + ` FROM tokens WHERE refresh_token = $1`
+	return scanToken(m.DB.QueryRowContext(ctx, query, refreshToken))
+}
+
+// GetTokenByJTI - fetch the row holding the token (access or refresh) with the given jwt id
+func (m *PostgresDBRepo) GetTokenByJTI(jti string) (*models.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `SELECT ` + tokenColumns + ` FROM tokens WHERE access_jti = $1 OR refresh_jti = $1`
+	return scanToken(m.DB.QueryRowContext(ctx, query, jti))
+}
+
+// RotateTokenPair - mark old as rotated and store next in the same family, atomically
+// The old refresh token is denylisted, so it cannot be used as a credential anymore
+// Returns models.ErrRefreshTokenReused when old has been rotated in the meantime (concurrent use of the same token)
+func (m *PostgresDBRepo) RotateTokenPair(old, next *models.Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = $1 WHERE id = $2 AND rotated_at IS NULL`, time.Now(), old.ID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrRefreshTokenReused
+	}
+	if err != nil {
+		return err
+	}
+
+	if old.RefreshJTI != "" {
+		_, err = tx.ExecContext(ctx, `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`,
+			old.RefreshJTI, old.UserID, old.ExpiryDate)
+		if err != nil {
+			return err
+		}
+	}
+
+	next.UserID = old.UserID
+	next.FamilyID = old.FamilyID
+	stmt := `INSERT INTO tokens (user_id, family_id, access_token, refresh_token, access_jti, refresh_jti, expiry_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, stmt, next.UserID, next.FamilyID, next.AccessToken, next.RefreshToken, next.AccessJTI, next.RefreshJTI, next.ExpiryDate).
+		Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeTokensWhere - denylist the jwt ids of the token rows matching cond, then delete those rows and their sessions
+// cond and sessionCond are trusted sql conditions on the tokens (t) and sessions (s) tables using $1
+// the newly revoked jwt ids are returned
+func (m *PostgresDBRepo) revokeTokensWhere(cond, sessionCond string, arg interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := fmt.Sprintf(`INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		SELECT j.jti, t.user_id, t.expiry_date
+		FROM tokens t, LATERAL (VALUES (t.access_jti), (t.refresh_jti)) AS j(jti)
+		WHERE %s AND j.jti IS NOT NULL
+		ON CONFLICT (jti) DO NOTHING
+		RETURNING jti`, cond)
+
+	rows, err := tx.QueryContext(ctx, stmt, arg)
+	if err != nil {
+		return nil, err
+	}
+	var jtis []string
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jtis = append(jtis, jti)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens t WHERE `+cond, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM sessions s WHERE `+sessionCond, arg)
+	if err != nil {
+		return nil, err
+	}
+	return jtis, tx.Commit()
+}
+
+// RevokeTokenFamily - revoke every token pair of a family (the whole login, ie the session)
+func (m *PostgresDBRepo) RevokeTokenFamily(familyID string) ([]string, error) {
+	return m.revokeTokensWhere("t.family_id = $1", "s.id = $1", familyID)
+}
+
+// DeleteTokensPairOnLogOut - revoke every token pair of a user (log out everywhere)
+func (m *PostgresDBRepo) DeleteTokensPairOnLogOut(userID int) ([]string, error) {
+	return m.revokeTokensWhere("t.user_id = $1", "s.user_id = $1", userID)
+}
+
+// RevokeToken - denylist a single jwt id, for tokens not tracked in the tokens table
+func (m *PostgresDBRepo) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+	_, err := m.DB.ExecContext(ctx, stmt, jti, userID, expiresAt)
+	return err
+}
+
+// IsTokenRevoked - whether a jwt id has been denylisted
+func (m *PostgresDBRepo) IsTokenRevoked(jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var revoked bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`, jti).Scan(&revoked)
+	return revoked, err
+}
@@ -0,0 +1,36 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRotateTokenPairAlreadyRotated - losing the race on a rotation reports a reuse and stores nothing
+func TestRotateTokenPairAlreadyRotated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresDBRepo{DB: db}
+	old := &models.Token{ID: 9, UserID: 75, FamilyID: "0b6c1c52-5b0e-4f34-9d43-5ad4a9ad1f3e"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE tokens SET rotated_at = \$1 WHERE id = \$2 AND rotated_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = repo.RotateTokenPair(old, &models.Token{AccessToken: "access", RefreshToken: "refresh", ExpiryDate: time.Now().Add(time.Hour)})
+
+	assert.ErrorIs(t, err, models.ErrRefreshTokenReused)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
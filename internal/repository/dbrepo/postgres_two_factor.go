@@ -0,0 +1,130 @@
+package dbrepo
+
+import (
+	"bookmarks/internal/models"
+	"context"
+	"database/sql"
+	"time"
+)
+
+/* Two-factor authentication functions - TOTP secret on the user, single-use recovery codes stored hashed */
+
+// GetTwoFactor - TOTP settings of a user
+func (m *PostgresDBRepo) GetTwoFactor(userID int) (*models.TwoFactor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tf := models.TwoFactor{UserID: userID}
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step FROM users WHERE id = $1`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// SetTOTPSecret - start an enrolment, replacing any pending one
+// Returns models.ErrTwoFactorEnabled when two-factor authentication is already on
+func (m *PostgresDBRepo) SetTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_secret = $1, updated_at = $2 WHERE id = $3 AND NOT totp_enabled`
+	res, err := m.DB.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(res)
+	if err == sql.ErrNoRows {
+		return models.ErrTwoFactorEnabled
+	}
+	return err
+}
+
+// EnableTwoFactor - turn two-factor authentication on, with step as the first used code and a fresh set of recovery codes
+func (m *PostgresDBRepo) EnableTwoFactor(userID int, step int64, recoveryHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_enabled = true, totp_last_step = $1, updated_at = $2
+		WHERE id = $3 AND NOT totp_enabled AND totp_secret IS NOT NULL`
+	res, err := tx.ExecContext(ctx, stmt, step, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, h := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, h)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep - record the time step of an accepted code; returns sql.ErrNoRows when that step,
+// or a later one, was already used (replayed code)
+func (m *PostgresDBRepo) UseTOTPStep(userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_enabled AND totp_last_step < $1`
+	res, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// UseRecoveryCode - consume a recovery code of the user; returns sql.ErrNoRows for an unknown or used code
+func (m *PostgresDBRepo) UseRecoveryCode(userID int, codeHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	stmt := `UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// ResetTwoFactor - turn two-factor authentication off, forgetting the secret and the recovery codes
+func (m *PostgresDBRepo) ResetTwoFactor(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0, updated_at = $1 WHERE id = $2`
+	res, err := tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
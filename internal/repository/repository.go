@@ -2,26 +2,62 @@ package repository
 
 import (
 	"bookmarks/internal/models"
+	"context"
 	"database/sql"
 	"time"
-
-	"github.com/markbates/goth"
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
+	SearchRepo
 	GetProjectsByCategory(category string) ([]*models.Project, error)
 	// GetProjectResources(projectID int) ([]*models.Bookmark, error)
-	GetResourcesByCategoryAndProject(category, project string) ([]*models.Bookmark, error)
+	GetResourcesByCategoryAndProject(category, project string, opts models.ListOptions) (*models.Page, error)
 	InsertBookmark(bkm *models.Bookmark) error
+	GetBookmarkByID(id int) (*models.Bookmark, error)
+	UpdateBookmark(bkm *models.Bookmark, actorID int) error
+	DeleteBookmark(id int) error
+
+	// Ratings functions
+	UpsertRating(userID, bookmarkID, rating int) (*models.Rating, error)
+	DeleteRating(userID, bookmarkID int) error
+	GetRatingSummary(bookmarkID int) (*models.RatingSummary, error)
+	GetRatingsByUser(userID int, opts models.ListOptions) (*models.Page, error)
+
+	// Comments functions
+	InsertComment(c *models.Comment) error
+	GetComments(bookmarkID int, opts models.ListOptions) (*models.Page, error)
 
 	GetUserByEmail(email string) (models.User, error)
 	GetUserByID(userID int) (*models.User, error)
-	StoreUserInDB(userID string, user *goth.User) error
+
+	// Oauth identities functions
+	GetUserByIdentity(provider, providerUserID string) (*models.User, error)
+	LinkIdentity(identity *models.Identity) error
+	CreateUserWithIdentity(u *models.User, identity *models.Identity) error
+	GetIdentitiesByUser(userID int) ([]*models.Identity, error)
+	DeleteIdentity(userID, identityID int) error
+	// Authorization codes functions - handoff of an Oauth login to the frontend
+	CreateAuthCode(userID int, codeHash string, expiresAt time.Time) error
+	ConsumeAuthCode(codeHash string) (int, error)
 
 	// Tokens related functions
-	StoreTokenPairs(userID int, accessToken, refreshToken string, expiry time.Time) error
-	DeleteTokensPairOnLogOut(userID int) error
+	StoreTokenPairs(t *models.Token) error
+	GetTokenByRefreshToken(refreshToken string) (*models.Token, error)
+	GetTokenByJTI(jti string) (*models.Token, error)
+	RotateTokenPair(old, next *models.Token) error
+	// Revocation - the revoking functions return the newly denylisted jwt ids
+	RevokeTokenFamily(familyID string) ([]string, error)
+	DeleteTokensPairOnLogOut(userID int) ([]string, error)
+	RevokeToken(jti string, userID int, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+
+	// Sessions functions - a session id is the family id of its tokens
+	CreateSession(s *models.Session) error
+	TouchSession(id, userAgent, ip string, expiresAt time.Time) error
+	GetSessionByID(id string) (*models.Session, error)
+	GetSessionsByUser(userID int) ([]*models.Session, error)
+	PurgeExpiredSessions() (int64, error)
 
 	FetchUserFromDB(userID string) (models.User, error)
 	// email confirmation && classic authentication function
@@ -29,11 +65,80 @@ type DatabaseRepo interface {
 	GetUserByConfirmationToken(token string) (*models.User, error)
 	VerifyUser(userID int) error
 	CheckEmailConflict(email string) (bool, error)
-	InsertNewUser(username, email, password, emailToken, defaultAvatar string) (int, error)
+	InsertNewUser(username, email, password, emailToken, defaultAvatar, language string, emailTokenExpiry time.Time) (int, error)
+	SetEmailToken(userID int, emailToken string, expiresAt time.Time) error
+	// Lockout functions - after too many failed logins
+	LockUser(userID int, until time.Time) error
+	UnlockUser(userID int) error
+
+	// Roles, audit log and catalog functions
+	GrantRole(userID int, role string, grantedBy int) error
+	RevokeRole(userID int, role string) error
+	InsertAuditEntry(e *models.AuditEntry) error
+	GetAuditLog(opts models.ListOptions) (*models.Page, error)
+	InsertCategory(name string) (int, error)
+	InsertProject(p *models.Project) error
+
+	// Personal access tokens functions
+	CreateAPIToken(t *models.APIToken, tokenHash string) error
+	GetAPITokenByHash(tokenHash string) (*models.APIToken, error)
+	GetAPITokensByUser(userID int) ([]*models.APIToken, error)
+	TouchAPIToken(id int) error
+	DeleteAPIToken(userID, id int) error
+
+	// Outbox functions - emails delivered by a background worker
+	EnqueueEmail(e *models.OutboxEmail) error
+	ClaimDueEmails(limit int, leaseUntil time.Time) ([]*models.OutboxEmail, error)
+	MarkEmailSent(id int, leaseUntil time.Time) error
+	MarkEmailFailed(id int, leaseUntil time.Time, lastError string, nextAttempt time.Time, dead bool) error
+	GetDeadEmails(limit int) ([]*models.OutboxEmail, error)
+	PurgeSentEmails(before time.Time) (int64, error)
+
+	// Follows and digest functions - followed projects, categories and users; new bookmarks of the projects are emailed periodically
+	Follow(userID int, followType string, targetID int) error
+	Unfollow(userID int, followType string, targetID int) error
+	GetFollows(userID int) ([]*models.Follow, error)
+	ClaimDueDigests(now, leaseUntil time.Time, limit int) ([]*models.DigestRecipient, error)
+	CompleteDigest(userID int, sentAt time.Time, e *models.OutboxEmail) error
+	GetDigestProjects(userID int, since time.Time, limit int) ([]*models.DigestProject, error)
+	// Activity functions - written by the bookmark and rating functions, read as the feed of the followers
+	GetFeed(userID int, opts models.ListOptions) (*models.Page, error)
+
+	// Notifications functions - kept until read; published to every instance, which listen to them
+	InsertNotification(n *models.Notification) error
+	NotifyFollowers(n *models.Notification, projectID int) ([]*models.Notification, error)
+	GetNotifications(userID int, unreadOnly bool, opts models.ListOptions) (*models.Page, error)
+	GetNotificationsAfter(userID, afterID, limit int) ([]*models.Notification, error)
+	MarkNotificationsRead(userID int, ids []int, read bool) (int64, error)
+	PublishNotification(payload []byte) error
+	ListenNotifications(ctx context.Context, handle func(payload string)) error
+
+	// Magic links functions - passwordless login
+	CreateMagicLink(userID int, tokenHash string, expiresAt time.Time) error
+	ConsumeMagicLink(tokenHash string) (int, error)
+
+	// Two-factor authentication functions
+	GetTwoFactor(userID int) (*models.TwoFactor, error)
+	SetTOTPSecret(userID int, secret string) error
+	EnableTwoFactor(userID int, step int64, recoveryHashes []string) error
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, codeHash string) error
+	ResetTwoFactor(userID int) error
+
+	// Password reset functions
+	CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(tokenHash, newPassword string) (int, string, error)
 
 	// Contributors functions
-	GetContributors() ([]*models.User, error)
+	GetContributors(opts models.ListOptions) (*models.Page, error)
 
 	SaveAvatarURL(userID int, avatarURL string) error
-	GetBookmarksByUser(userID int) ([]map[string]interface{}, error)
+	UpdateLanguage(userID int, language string) error
+	UpdateDigestFrequency(userID int, frequency string) error
+	GetBookmarksByUser(userID int, opts models.ListOptions) (*models.Page, error)
+}
+
+// SearchRepo - full-text search over the bookmarks
+type SearchRepo interface {
+	SearchBookmarks(sq models.SearchQuery) (*models.SearchResults, error)
 }
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.idx_bookmarks_search_vector;
+DROP TRIGGER IF EXISTS bookmarks_search_vector_trigger ON public.bookmarks;
+DROP FUNCTION IF EXISTS public.bookmarks_search_vector_refresh();
+ALTER TABLE public.bookmarks DROP COLUMN IF EXISTS search_vector;
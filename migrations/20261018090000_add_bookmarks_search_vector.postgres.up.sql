@@ -0,0 +1,31 @@
+ALTER TABLE public.bookmarks ADD COLUMN search_vector tsvector;
+
+-- description weighs the most, then type and project name, then category, then the url itself
+CREATE OR REPLACE FUNCTION public.bookmarks_search_vector_refresh() RETURNS trigger AS $$
+DECLARE
+	project_name TEXT;
+	category_name TEXT;
+BEGIN
+	SELECT p.name, c.category INTO project_name, category_name
+	FROM public.projects p
+	JOIN public.categories c ON p.category_id = c.id
+	WHERE p.id = NEW.project_id;
+
+	NEW.search_vector :=
+		setweight(to_tsvector('english', COALESCE(NEW.description, '')), 'A') ||
+		setweight(to_tsvector('english', COALESCE(NEW.type, '')), 'B') ||
+		setweight(to_tsvector('english', COALESCE(project_name, '')), 'B') ||
+		setweight(to_tsvector('english', COALESCE(category_name, '')), 'C') ||
+		setweight(to_tsvector('english', NEW.url), 'D');
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bookmarks_search_vector_trigger
+	BEFORE INSERT OR UPDATE OF url, type, description, project_id ON public.bookmarks
+	FOR EACH ROW EXECUTE FUNCTION public.bookmarks_search_vector_refresh();
+
+-- backfill existing rows through the trigger
+UPDATE public.bookmarks SET url = url;
+
+CREATE INDEX idx_bookmarks_search_vector ON public.bookmarks USING gin (search_vector);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.idx_tokens_family_id;
+DROP INDEX IF EXISTS public.idx_tokens_refresh_token;
+ALTER TABLE public.tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE public.tokens DROP COLUMN IF EXISTS family_id;
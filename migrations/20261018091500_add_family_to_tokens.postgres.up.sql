@@ -0,0 +1,7 @@
+-- a family groups the successive refresh tokens of one login; rotated rows are kept to detect reuse
+ALTER TABLE public.tokens ADD COLUMN family_id VARCHAR(36) NOT NULL DEFAULT gen_random_uuid()::text;
+ALTER TABLE public.tokens ADD COLUMN rotated_at TIMESTAMP;
+ALTER TABLE public.tokens ALTER COLUMN family_id DROP DEFAULT;
+
+CREATE UNIQUE INDEX idx_tokens_refresh_token ON public.tokens (refresh_token);
+CREATE INDEX idx_tokens_family_id ON public.tokens (family_id);
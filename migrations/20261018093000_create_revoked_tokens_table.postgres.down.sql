@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.revoked_tokens;
+DROP INDEX IF EXISTS public.idx_tokens_refresh_jti;
+DROP INDEX IF EXISTS public.idx_tokens_access_jti;
+ALTER TABLE public.tokens DROP COLUMN IF EXISTS refresh_jti;
+ALTER TABLE public.tokens DROP COLUMN IF EXISTS access_jti;
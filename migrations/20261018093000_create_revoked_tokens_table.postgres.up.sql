@@ -0,0 +1,16 @@
+ALTER TABLE public.tokens ADD COLUMN access_jti VARCHAR(36);
+ALTER TABLE public.tokens ADD COLUMN refresh_jti VARCHAR(36);
+
+CREATE INDEX idx_tokens_access_jti ON public.tokens (access_jti);
+CREATE INDEX idx_tokens_refresh_jti ON public.tokens (refresh_jti);
+
+-- denylist of the jwt ids revoked before their expiry (logout, reuse detection...)
+CREATE TABLE IF NOT EXISTS public.revoked_tokens (
+	jti VARCHAR(36) PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON public.revoked_tokens (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.idx_tokens_expiry_date;
+DROP TABLE IF EXISTS public.sessions;
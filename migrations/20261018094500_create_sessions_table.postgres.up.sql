@@ -0,0 +1,21 @@
+-- one row per login (token family), tokens.family_id being the session id
+CREATE TABLE IF NOT EXISTS public.sessions (
+	id VARCHAR(36) PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user_id ON public.sessions (user_id);
+CREATE INDEX idx_sessions_expires_at ON public.sessions (expires_at);
+CREATE INDEX idx_tokens_expiry_date ON public.tokens (expiry_date);
+
+-- logins made before this migration, without device information
+INSERT INTO public.sessions (id, user_id, created_at, last_seen_at, expires_at)
+SELECT family_id, user_id, MIN(created_at), MAX(created_at), MAX(expiry_date)
+FROM public.tokens
+GROUP BY family_id, user_id;
@@ -0,0 +1,12 @@
+-- single-use password reset tokens, only their sha256 is stored
+CREATE TABLE IF NOT EXISTS public.password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_password_resets_user_id ON public.password_resets (user_id);
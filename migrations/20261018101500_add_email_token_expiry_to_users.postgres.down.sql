@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.idx_users_email_token;
+ALTER TABLE public.users DROP COLUMN IF EXISTS email_token_sent_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS email_token_expires_at;
@@ -0,0 +1,11 @@
+ALTER TABLE public.users ADD COLUMN email_token_expires_at TIMESTAMP;
+ALTER TABLE public.users ADD COLUMN email_token_sent_at TIMESTAMP;
+
+-- confirmation tokens are now stored as their sha256; pending ones get a day to be used
+UPDATE public.users
+SET email_token = encode(sha256(convert_to(email_token, 'UTF8')), 'hex'),
+	email_token_expires_at = CURRENT_TIMESTAMP + INTERVAL '1 day',
+	email_token_sent_at = CURRENT_TIMESTAMP
+WHERE email_token IS NOT NULL AND email_token <> '';
+
+CREATE INDEX idx_users_email_token ON public.users (email_token);
@@ -0,0 +1,13 @@
+-- accounts of Oauth providers linked to a user, a user can log in with any of them
+CREATE TABLE IF NOT EXISTS public.user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	provider VARCHAR(50) NOT NULL,
+	provider_user_id VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (provider, provider_user_id),
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_identities_user_id ON public.user_identities (user_id);
@@ -0,0 +1,10 @@
+-- single-use codes handed to the frontend after an Oauth login, exchanged for the tokens; only their sha256 is stored
+CREATE TABLE IF NOT EXISTS public.auth_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	code_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
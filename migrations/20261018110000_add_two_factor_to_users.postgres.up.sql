@@ -0,0 +1,17 @@
+-- TOTP two-factor authentication: the secret is set at enrolment, used once enabled by a first valid code
+-- totp_last_step is the time step of the last accepted code, so that a code cannot be replayed
+ALTER TABLE public.users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE public.users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE public.users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- single-use recovery codes, for when the authenticator is lost; only their sha256 is stored
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON public.recovery_codes (user_id);
@@ -0,0 +1,16 @@
+-- personal access tokens, for scripts and the browser extension; only their sha256 is stored
+-- scopes is a space separated list (bookmarks:read bookmarks:write admin), prefix the start of the token, to tell them apart
+CREATE TABLE IF NOT EXISTS public.api_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	prefix VARCHAR(16) NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	last_used_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_api_tokens_user_id ON public.api_tokens (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.audit_log;
+DROP TABLE IF EXISTS public.user_roles;
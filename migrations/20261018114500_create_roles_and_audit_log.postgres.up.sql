@@ -0,0 +1,28 @@
+-- roles granted to users (member is implicit); users.is_admin is kept in sync with the admin role
+CREATE TABLE IF NOT EXISTS public.user_roles (
+	user_id INTEGER NOT NULL,
+	role VARCHAR(50) NOT NULL,
+	granted_by INTEGER,
+	granted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, role),
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (granted_by) REFERENCES public.users (id) ON DELETE SET NULL
+);
+
+INSERT INTO public.user_roles (user_id, role)
+SELECT id, 'admin' FROM public.users WHERE is_admin
+ON CONFLICT DO NOTHING;
+
+-- privileged actions: role changes, moderation of the bookmarks of others, catalog changes...
+CREATE TABLE IF NOT EXISTS public.audit_log (
+	id SERIAL PRIMARY KEY,
+	actor_id INTEGER,
+	action VARCHAR(50) NOT NULL,
+	target_type VARCHAR(50) NOT NULL,
+	target_id INTEGER NOT NULL,
+	details JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (actor_id) REFERENCES public.users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_audit_log_created_at ON public.audit_log (created_at);
@@ -0,0 +1,2 @@
+-- temporary lockout after too many failed logins, lifted by time or by an admin
+ALTER TABLE public.users ADD COLUMN locked_until TIMESTAMP;
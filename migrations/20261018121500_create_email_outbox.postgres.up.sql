@@ -0,0 +1,15 @@
+-- emails waiting to be delivered by the outbox worker, retried with a backoff until sent or dead
+CREATE TABLE IF NOT EXISTS public.email_outbox (
+	id SERIAL PRIMARY KEY,
+	recipient VARCHAR(255) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	html_body TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_error TEXT,
+	sent_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_email_outbox_due ON public.email_outbox (next_attempt_at) WHERE status = 'pending';
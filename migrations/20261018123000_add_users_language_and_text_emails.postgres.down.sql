@@ -0,0 +1,2 @@
+ALTER TABLE public.email_outbox DROP COLUMN IF EXISTS text_body;
+ALTER TABLE public.users DROP COLUMN IF EXISTS language;
@@ -0,0 +1,5 @@
+-- language of the emails sent to the user
+ALTER TABLE public.users ADD COLUMN language VARCHAR(8) NOT NULL DEFAULT 'en';
+
+-- plain text alternative of the queued emails
+ALTER TABLE public.email_outbox ADD COLUMN text_body TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.idx_bookmarks_project_created_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS last_digest_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS digest_frequency;
+DROP TABLE IF EXISTS public.category_follows;
+DROP TABLE IF EXISTS public.project_follows;
@@ -0,0 +1,24 @@
+-- projects and categories followed by users, whose new bookmarks are sent in their digest
+CREATE TABLE IF NOT EXISTS public.project_follows (
+	user_id INTEGER NOT NULL,
+	project_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, project_id),
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (project_id) REFERENCES public.projects (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.category_follows (
+	user_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, category_id),
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES public.categories (id) ON DELETE CASCADE
+);
+
+-- how often the digest is sent (daily, weekly or off), and when the last one was
+ALTER TABLE public.users ADD COLUMN digest_frequency VARCHAR(8) NOT NULL DEFAULT 'weekly';
+ALTER TABLE public.users ADD COLUMN last_digest_at TIMESTAMP;
+
+CREATE INDEX idx_bookmarks_project_created_at ON public.bookmarks (project_id, created_at);
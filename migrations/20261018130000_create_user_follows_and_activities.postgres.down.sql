@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.activities;
+DROP TABLE IF EXISTS public.user_follows;
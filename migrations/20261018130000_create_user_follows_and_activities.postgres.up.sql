@@ -0,0 +1,26 @@
+-- contributors followed by users
+CREATE TABLE IF NOT EXISTS public.user_follows (
+	user_id INTEGER NOT NULL,
+	followed_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, followed_id),
+	CHECK (user_id <> followed_id),
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (followed_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+-- what users did on bookmarks (submitted, rated...), streamed in the feeds of their followers
+CREATE TABLE IF NOT EXISTS public.activities (
+	id SERIAL PRIMARY KEY,
+	actor_id INTEGER NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	bookmark_id INTEGER NOT NULL,
+	details JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (actor_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (bookmark_id) REFERENCES public.bookmarks (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_activities_created_at ON public.activities (created_at, id);
+CREATE INDEX idx_activities_actor_id ON public.activities (actor_id);
+CREATE INDEX idx_activities_bookmark_id ON public.activities (bookmark_id);
@@ -0,0 +1,17 @@
+-- notifications of the users, pushed over server-sent events and kept until read
+-- bookmark_id has no foreign key: a notification outlives the bookmark a moderator deleted
+CREATE TABLE IF NOT EXISTS public.notifications (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	actor_id INTEGER,
+	bookmark_id INTEGER NOT NULL,
+	details JSONB NOT NULL DEFAULT '{}',
+	read_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE,
+	FOREIGN KEY (actor_id) REFERENCES public.users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_notifications_user_id ON public.notifications (user_id, created_at, id);
+CREATE INDEX idx_notifications_unread ON public.notifications (user_id) WHERE read_at IS NULL;
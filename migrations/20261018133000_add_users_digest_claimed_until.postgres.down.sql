@@ -0,0 +1 @@
+ALTER TABLE public.users DROP COLUMN IF EXISTS digest_claimed_until;
@@ -0,0 +1,2 @@
+-- lease of the digest job on a user: the last digest only moves once the new one is queued
+ALTER TABLE public.users ADD COLUMN digest_claimed_until TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.idx_comments_bookmark_id;
+DROP TABLE IF EXISTS public.comments;
@@ -0,0 +1,12 @@
+-- comments of the users on bookmarks, streamed in the feeds of their followers
+CREATE TABLE IF NOT EXISTS public.comments (
+	id SERIAL PRIMARY KEY,
+	bookmark_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (bookmark_id) REFERENCES public.bookmarks (id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_comments_bookmark_id ON public.comments (bookmark_id, created_at, id);
@@ -0,0 +1 @@
+ALTER TABLE public.email_outbox DROP COLUMN IF EXISTS unsubscribe_url;
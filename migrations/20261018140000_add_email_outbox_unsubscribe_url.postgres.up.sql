@@ -0,0 +1,2 @@
+-- one-click unsubscribe link of the queued emails sent to a list (the digests), put in their List-Unsubscribe header
+ALTER TABLE public.email_outbox ADD COLUMN unsubscribe_url TEXT NOT NULL DEFAULT '';